@@ -6,6 +6,9 @@ import (
 	"github.com/xh3b4sd/tracer"
 
 	"github.com/venturemark/apiworker/cmd/daemon"
+	"github.com/venturemark/apiworker/cmd/fsck"
+	"github.com/venturemark/apiworker/cmd/liftsuppression"
+	"github.com/venturemark/apiworker/cmd/restore"
 	"github.com/venturemark/apiworker/cmd/version"
 	"github.com/venturemark/apiworker/pkg/project"
 )
@@ -39,6 +42,42 @@ func New(config Config) (*cobra.Command, error) {
 		}
 	}
 
+	var fsckCmd *cobra.Command
+	{
+		c := fsck.Config{
+			Logger: config.Logger,
+		}
+
+		fsckCmd, err = fsck.New(c)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+	}
+
+	var liftSuppressionCmd *cobra.Command
+	{
+		c := liftsuppression.Config{
+			Logger: config.Logger,
+		}
+
+		liftSuppressionCmd, err = liftsuppression.New(c)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+	}
+
+	var restoreCmd *cobra.Command
+	{
+		c := restore.Config{
+			Logger: config.Logger,
+		}
+
+		restoreCmd, err = restore.New(c)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+	}
+
 	var versionCmd *cobra.Command
 	{
 		c := version.Config{
@@ -71,6 +110,9 @@ func New(config Config) (*cobra.Command, error) {
 		}
 
 		c.AddCommand(daemonCmd)
+		c.AddCommand(fsckCmd)
+		c.AddCommand(liftSuppressionCmd)
+		c.AddCommand(restoreCmd)
 		c.AddCommand(versionCmd)
 	}
 
@@ -14,47 +14,114 @@ type flag struct {
 		Port                   string
 		TerminationGracePeriod time.Duration
 	}
+	Archive struct {
+		Retention time.Duration
+	}
+	Blob struct {
+		Directory string
+		Kind      string
+	}
 	Controller struct {
 		Interval time.Duration
 	}
 	Handler struct {
-		Timeout time.Duration
+		ChunkSize   int
+		DryRun      bool
+		Erasure     string
+		GracePeriod time.Duration
+		Timeout     time.Duration
+		WalkTimeout time.Duration
+	}
+	Invite struct {
+		Notify bool
+		TTL    time.Duration
+	}
+	Mailer struct {
+		Directory string
+		Kind      string
+		Limit     int
 	}
 	Metrics struct {
-		Host string
-		Port string
+		Debug bool
+		Host  string
+		Port  string
 	}
 	Postmark struct {
 		Token struct {
 			Account string
 			Server  string
+			Webhook string
 		}
 	}
 	Redis struct {
-		Host string
-		Kind string
-		Port string
+		Host   string
+		Kind   string
+		Master string
+		Port   string
+	}
+	Reminder struct {
+		Limit int
+	}
+	SMTP struct {
+		Address  string
+		Password string
+		Username string
+	}
+	Unsubscribe struct {
+		Secret string
+		TTL    time.Duration
+		URL    string
 	}
 }
 
 func (f *flag) Init(cmd *cobra.Command) {
-	cmd.Flags().StringVarP(&f.ApiWorker.Host, "apiworker-host", "", "127.0.0.1", "The host for binding the grpc apiworker to.")
-	cmd.Flags().StringVarP(&f.ApiWorker.Port, "apiworker-port", "", "7777", "The port for binding the grpc apiworker to.")
+	cmd.Flags().StringVarP(&f.ApiWorker.Host, "apiworker-host", "", "127.0.0.1", "The host for binding the public http endpoints to, e.g. for unsubscribing from emails.")
+	cmd.Flags().StringVarP(&f.ApiWorker.Port, "apiworker-port", "", "7777", "The port for binding the public http endpoints to, e.g. for unsubscribing from emails.")
 	cmd.Flags().DurationVarP(&f.ApiWorker.TerminationGracePeriod, "apiworker-termination-grace-period", "", 5*time.Second, "The time to wait before terminating the apiworker process.")
 
+	cmd.Flags().DurationVarP(&f.Archive.Retention, "archive-retention", "", 0, "The time archives of deleted resources are kept in the blob store, e.g. 720h, archiving is disabled if zero.")
+
+	cmd.Flags().StringVarP(&f.Blob.Directory, "blob-directory", "", "/var/lib/apiworker", "The directory for storing blobs if the blob kind is local.")
+	cmd.Flags().StringVarP(&f.Blob.Kind, "blob-kind", "", "local", "The kind of blob store to write exports and archives to, e.g. local.")
+
 	cmd.Flags().DurationVarP(&f.Controller.Interval, "controller-interval", "", 5*time.Second, "The interval of the controller to reconcile.")
 
+	cmd.Flags().IntVarP(&f.Handler.ChunkSize, "handler-chunk-size", "", 500, "The number of child resources cascading handlers process per execution, e.g. when deleting or moving timelines.")
+	cmd.Flags().BoolVarP(&f.Handler.DryRun, "handler-dry-run", "", false, "Whether deletion handlers should only log the operations they would perform.")
+	cmd.Flags().StringVarP(&f.Handler.Erasure, "handler-erasure", "", "anonymise", "The policy applied to content authored by deleted users, e.g. anonymise or delete.")
+	cmd.Flags().DurationVarP(&f.Handler.GracePeriod, "handler-grace-period", "", 7*24*time.Hour, "The time deleted resources are kept in tombstones before being deleted irrecoverably, zero to disable soft deletion.")
 	cmd.Flags().DurationVarP(&f.Handler.Timeout, "handler-timeout", "", 5*time.Second, "The timeout for a handler to give up.")
+	cmd.Flags().DurationVarP(&f.Handler.WalkTimeout, "handler-walk-timeout", "", 10*time.Minute, "The timeout for handlers walking the whole keyspace, e.g. when backfilling the subject index.")
+
+	cmd.Flags().BoolVarP(&f.Invite.Notify, "invite-notify", "", false, "Whether to notify inviters via email once their invite expired.")
+	cmd.Flags().DurationVarP(&f.Invite.TTL, "invite-ttl", "", 14*24*time.Hour, "The time after which pending invites expire.")
+
+	cmd.Flags().StringVarP(&f.Mailer.Directory, "mailer-directory", "", "", "The directory for writing emails to if the mailer kind is file or capture, stdout or memory if empty.")
+	cmd.Flags().StringVarP(&f.Mailer.Kind, "mailer-kind", "", "postmark", "The kind of mailer used to send emails, e.g. postmark, smtp, file or capture.")
+	cmd.Flags().IntVarP(&f.Mailer.Limit, "mailer-limit", "", 1000, "The maximum number of emails kept in memory if the mailer kind is capture.")
 
+	cmd.Flags().BoolVarP(&f.Metrics.Debug, "metrics-debug", "", false, "Whether to serve pprof and controller state endpoints on the http metrics server.")
 	cmd.Flags().StringVarP(&f.Metrics.Host, "metrics-host", "", "127.0.0.1", "The host for binding the http metrics endpoints to.")
 	cmd.Flags().StringVarP(&f.Metrics.Port, "metrics-port", "", "8000", "The port for binding the http metrics endpoints to.")
 
 	cmd.Flags().StringVarP(&f.Postmark.Token.Account, "postmark-token-account", "", os.Getenv("APIWORKER_POSTMARK_TOKEN_ACCOUNT"), "The postmark account token used to send emails.")
 	cmd.Flags().StringVarP(&f.Postmark.Token.Server, "postmark-token-server", "", os.Getenv("APIWORKER_POSTMARK_TOKEN_SERVER"), "The postmark server token used to send emails.")
+	cmd.Flags().StringVarP(&f.Postmark.Token.Webhook, "postmark-token-webhook", "", os.Getenv("APIWORKER_POSTMARK_TOKEN_WEBHOOK"), "The basic auth password postmark sends along with bounce and spam complaint webhooks, empty to disable the webhook endpoint.")
 
 	cmd.Flags().StringVarP(&f.Redis.Host, "redis-host", "", "127.0.0.1", "The host for connecting with redis.")
-	cmd.Flags().StringVarP(&f.Redis.Kind, "redis-kind", "", "single", "The kind of redis to connect to, e.g. simple or sentinel.")
+	cmd.Flags().StringVarP(&f.Redis.Kind, "redis-kind", "", "single", "The kind of redis to connect to, e.g. single or sentinel.")
+	cmd.Flags().StringVarP(&f.Redis.Master, "redis-master", "", "mymaster", "The name of the master monitored by the sentinel, in case --redis-kind is sentinel.")
 	cmd.Flags().StringVarP(&f.Redis.Port, "redis-port", "", "6379", "The port for connecting with redis.")
+
+	cmd.Flags().IntVarP(&f.Reminder.Limit, "reminder-limit", "", 5, "The maximum number of updates per venture shown in a single reminder.")
+
+	cmd.Flags().StringVarP(&f.SMTP.Address, "smtp-address", "", "127.0.0.1:25", "The address of the SMTP server used to send emails if the mailer kind is smtp.")
+	cmd.Flags().StringVarP(&f.SMTP.Password, "smtp-password", "", os.Getenv("APIWORKER_SMTP_PASSWORD"), "The password for authenticating with the SMTP server.")
+	cmd.Flags().StringVarP(&f.SMTP.Username, "smtp-username", "", "", "The username for authenticating with the SMTP server, no authentication if empty.")
+
+	cmd.Flags().StringVarP(&f.Unsubscribe.Secret, "unsubscribe-secret", "", os.Getenv("APIWORKER_UNSUBSCRIBE_SECRET"), "The secret for signing unsubscribe tokens, empty to disable unsubscribe links.")
+	cmd.Flags().DurationVarP(&f.Unsubscribe.TTL, "unsubscribe-ttl", "", 90*24*time.Hour, "The time after which unsubscribe links expire.")
+	cmd.Flags().StringVarP(&f.Unsubscribe.URL, "unsubscribe-url", "", "", "The public URL of the unsubscribe endpoint, e.g. https://example.com/unsubscribe.")
 }
 
 func (f *flag) Validate() error {
@@ -70,6 +137,15 @@ func (f *flag) Validate() error {
 		}
 	}
 
+	{
+		if f.Blob.Kind != "local" {
+			return tracer.Maskf(invalidFlagError, "--blob-kind must be local")
+		}
+		if f.Blob.Directory == "" {
+			return tracer.Maskf(invalidFlagError, "--blob-directory must not be empty")
+		}
+	}
+
 	{
 		if f.Controller.Interval == 0 {
 			return tracer.Maskf(invalidFlagError, "--controller-interval must not be empty")
@@ -77,9 +153,24 @@ func (f *flag) Validate() error {
 	}
 
 	{
+		if f.Handler.ChunkSize <= 0 {
+			return tracer.Maskf(invalidFlagError, "--handler-chunk-size must be greater than 0")
+		}
+		if f.Handler.Erasure != "anonymise" && f.Handler.Erasure != "delete" {
+			return tracer.Maskf(invalidFlagError, "--handler-erasure must be anonymise or delete")
+		}
 		if f.Handler.Timeout == 0 {
 			return tracer.Maskf(invalidFlagError, "--handler-timeout must not be empty")
 		}
+		if f.Handler.WalkTimeout == 0 {
+			return tracer.Maskf(invalidFlagError, "--handler-walk-timeout must not be empty")
+		}
+	}
+
+	{
+		if f.Invite.TTL == 0 {
+			return tracer.Maskf(invalidFlagError, "--invite-ttl must not be empty")
+		}
 	}
 
 	{
@@ -92,6 +183,15 @@ func (f *flag) Validate() error {
 	}
 
 	{
+		if f.Mailer.Kind != "capture" && f.Mailer.Kind != "file" && f.Mailer.Kind != "postmark" && f.Mailer.Kind != "smtp" {
+			return tracer.Maskf(invalidFlagError, "--mailer-kind must be capture, file, postmark or smtp")
+		}
+		if f.Mailer.Kind == "capture" && f.Mailer.Directory == "" && f.Mailer.Limit <= 0 {
+			return tracer.Maskf(invalidFlagError, "--mailer-limit must be greater than 0")
+		}
+	}
+
+	if f.Mailer.Kind == "postmark" {
 		if f.Postmark.Token.Account == "" {
 			return tracer.Maskf(invalidFlagError, "--postmark-token-account must not be empty")
 		}
@@ -112,5 +212,26 @@ func (f *flag) Validate() error {
 		}
 	}
 
+	{
+		if f.Reminder.Limit <= 0 {
+			return tracer.Maskf(invalidFlagError, "--reminder-limit must be greater than 0")
+		}
+	}
+
+	if f.Mailer.Kind == "smtp" {
+		if f.SMTP.Address == "" {
+			return tracer.Maskf(invalidFlagError, "--smtp-address must not be empty")
+		}
+	}
+
+	if f.Unsubscribe.Secret != "" {
+		if f.Unsubscribe.TTL == 0 {
+			return tracer.Maskf(invalidFlagError, "--unsubscribe-ttl must not be empty")
+		}
+		if f.Unsubscribe.URL == "" {
+			return tracer.Maskf(invalidFlagError, "--unsubscribe-url must not be empty")
+		}
+	}
+
 	return nil
 }
@@ -8,6 +8,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/gomodule/redigo/redis"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
 	"github.com/xh3b4sd/logger"
@@ -19,19 +20,47 @@ import (
 	"github.com/xh3b4sd/rescue/pkg/metric"
 	"github.com/xh3b4sd/tracer"
 
+	"github.com/venturemark/apiworker/pkg/archive"
+	"github.com/venturemark/apiworker/pkg/blob"
+	"github.com/venturemark/apiworker/pkg/blob/local"
 	"github.com/venturemark/apiworker/pkg/controller"
 	"github.com/venturemark/apiworker/pkg/controller/queue"
+	"github.com/venturemark/apiworker/pkg/cursor"
 	"github.com/venturemark/apiworker/pkg/handler"
+	"github.com/venturemark/apiworker/pkg/handler/archivedelete"
 	"github.com/venturemark/apiworker/pkg/handler/invitedelete"
+	"github.com/venturemark/apiworker/pkg/handler/inviteexpire"
 	"github.com/venturemark/apiworker/pkg/handler/messagedelete"
+	"github.com/venturemark/apiworker/pkg/handler/orphandelete"
+	"github.com/venturemark/apiworker/pkg/handler/preferenceupdate"
 	"github.com/venturemark/apiworker/pkg/handler/remindercreate"
 	"github.com/venturemark/apiworker/pkg/handler/roledelete"
 	"github.com/venturemark/apiworker/pkg/handler/subjectdelete"
+	"github.com/venturemark/apiworker/pkg/handler/subjectindex"
 	"github.com/venturemark/apiworker/pkg/handler/timelinedelete"
+	"github.com/venturemark/apiworker/pkg/handler/timelinemove"
+	"github.com/venturemark/apiworker/pkg/handler/tombstonedelete"
+	"github.com/venturemark/apiworker/pkg/handler/tombstonerestore"
 	"github.com/venturemark/apiworker/pkg/handler/updatedelete"
 	"github.com/venturemark/apiworker/pkg/handler/userdelete"
+	"github.com/venturemark/apiworker/pkg/handler/userexport"
 	"github.com/venturemark/apiworker/pkg/handler/venturedelete"
+	"github.com/venturemark/apiworker/pkg/handler/venturetransfer"
+	"github.com/venturemark/apiworker/pkg/mailer"
+	"github.com/venturemark/apiworker/pkg/mailer/capture"
+	"github.com/venturemark/apiworker/pkg/mailer/file"
+	"github.com/venturemark/apiworker/pkg/mailer/postmark"
+	"github.com/venturemark/apiworker/pkg/mailer/smtp"
+	"github.com/venturemark/apiworker/pkg/mailer/suppress"
+	"github.com/venturemark/apiworker/pkg/preference"
+	"github.com/venturemark/apiworker/pkg/readmarker"
+	"github.com/venturemark/apiworker/pkg/render"
 	"github.com/venturemark/apiworker/pkg/server"
+	"github.com/venturemark/apiworker/pkg/suppression"
+	"github.com/venturemark/apiworker/pkg/tombstone"
+	"github.com/venturemark/apiworker/pkg/transaction"
+	"github.com/venturemark/apiworker/pkg/transaction/multi"
+	"github.com/venturemark/apiworker/pkg/unsubscribe"
 )
 
 type runner struct {
@@ -71,6 +100,20 @@ func (r *runner) run(ctx context.Context, cmd *cobra.Command, args []string) err
 		}
 	}
 
+	var redisPool *redis.Pool
+	{
+		c := multi.PoolConfig{
+			Address: net.JoinHostPort(r.flag.Redis.Host, r.flag.Redis.Port),
+			Kind:    r.flag.Redis.Kind,
+			Master:  r.flag.Redis.Master,
+		}
+
+		redisPool, err = multi.NewPool(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
 	//************************************************************************//
 
 	var rescueMetric *metric.Collection
@@ -109,12 +152,215 @@ func (r *runner) run(ctx context.Context, cmd *cobra.Command, args []string) err
 
 	//************************************************************************//
 
+	var newBlob blob.Interface
+	{
+		c := local.BlobConfig{
+			Directory: r.flag.Blob.Directory,
+		}
+
+		newBlob, err = local.NewBlob(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var newSuppression *suppression.Store
+	{
+		c := suppression.Config{
+			Redigo: redigoClient,
+		}
+
+		newSuppression, err = suppression.New(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var captureMailer *capture.Mailer
+	var backendMailer mailer.Interface
+	{
+		switch r.flag.Mailer.Kind {
+		case "capture":
+			c := capture.MailerConfig{
+				Directory: r.flag.Mailer.Directory,
+				Limit:     r.flag.Mailer.Limit,
+			}
+
+			captureMailer, err = capture.NewMailer(c)
+			backendMailer = captureMailer
+		case "file":
+			c := file.MailerConfig{
+				Directory: r.flag.Mailer.Directory,
+			}
+
+			backendMailer, err = file.NewMailer(c)
+		case "postmark":
+			c := postmark.MailerConfig{
+				TokenAccount: r.flag.Postmark.Token.Account,
+				TokenServer:  r.flag.Postmark.Token.Server,
+			}
+
+			backendMailer, err = postmark.NewMailer(c)
+		case "smtp":
+			c := smtp.MailerConfig{
+				Address:  r.flag.SMTP.Address,
+				Password: r.flag.SMTP.Password,
+				Username: r.flag.SMTP.Username,
+			}
+
+			backendMailer, err = smtp.NewMailer(c)
+		}
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var newMailer *suppress.Mailer
+	{
+		c := suppress.MailerConfig{
+			Mailer:      backendMailer,
+			Suppression: newSuppression,
+		}
+
+		newMailer, err = suppress.NewMailer(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var newPreference *preference.Store
+	{
+		c := preference.Config{
+			Redigo: redigoClient,
+		}
+
+		newPreference, err = preference.New(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var newReadMarker *readmarker.Store
+	{
+		c := readmarker.Config{
+			Redigo: redigoClient,
+		}
+
+		newReadMarker, err = readmarker.New(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var newUnsubscribe *unsubscribe.Unsubscribe
+	{
+		c := unsubscribe.Config{
+			Preference: newPreference,
+
+			Secret: r.flag.Unsubscribe.Secret,
+			TTL:    r.flag.Unsubscribe.TTL,
+			URL:    r.flag.Unsubscribe.URL,
+		}
+
+		newUnsubscribe, err = unsubscribe.New(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var newRender *render.Render
+	{
+		c := render.Config{}
+
+		newRender, err = render.New(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var newCursor *cursor.Cursor
+	{
+		c := cursor.Config{
+			Redigo: redigoClient,
+
+			Size: r.flag.Handler.ChunkSize,
+		}
+
+		newCursor, err = cursor.New(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var newTransaction transaction.Interface
+	{
+		c := multi.TransactionConfig{
+			Pool: redisPool,
+		}
+
+		newTransaction, err = multi.NewTransaction(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var newTombstone *tombstone.Tombstone
+	{
+		c := tombstone.Config{
+			Redigo:      redigoClient,
+			Transaction: newTransaction,
+
+			Grace: r.flag.Handler.GracePeriod,
+		}
+
+		newTombstone, err = tombstone.New(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var newArchive *archive.Archive
+	{
+		c := archive.Config{
+			Blob:        newBlob,
+			Transaction: newTransaction,
+
+			Retention: r.flag.Archive.Retention,
+		}
+
+		newArchive, err = archive.New(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	//************************************************************************//
+
+	var archiveDeleteHandler handler.Interface
+	{
+		c := archivedelete.HandlerConfig{
+			Archive: newArchive,
+			Logger:  r.logger,
+
+			Timeout: r.flag.Handler.Timeout,
+		}
+
+		archiveDeleteHandler, err = archivedelete.NewHandler(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
 	var inviteDeleteHandler handler.Interface
 	{
 		c := invitedelete.HandlerConfig{
-			Logger: r.logger,
-			Redigo: redigoClient,
-			Rescue: rescueEngine,
+			Logger:      r.logger,
+			Mailer:      newMailer,
+			Preference:  newPreference,
+			Redigo:      redigoClient,
+			Render:      newRender,
+			Rescue:      rescueEngine,
+			Transaction: newTransaction,
 
 			Timeout: r.flag.Handler.Timeout,
 		}
@@ -125,13 +371,34 @@ func (r *runner) run(ctx context.Context, cmd *cobra.Command, args []string) err
 		}
 	}
 
-	var messageDeleteHandler handler.Interface
+	var inviteExpireHandler handler.Interface
 	{
-		c := messagedelete.HandlerConfig{
+		c := inviteexpire.HandlerConfig{
 			Logger: r.logger,
 			Redigo: redigoClient,
 			Rescue: rescueEngine,
 
+			Notify:  r.flag.Invite.Notify,
+			Timeout: r.flag.Handler.WalkTimeout,
+			TTL:     r.flag.Invite.TTL,
+		}
+
+		inviteExpireHandler, err = inviteexpire.NewHandler(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var messageDeleteHandler handler.Interface
+	{
+		c := messagedelete.HandlerConfig{
+			Archive:     newArchive,
+			Logger:      r.logger,
+			Redigo:      redigoClient,
+			Rescue:      rescueEngine,
+			Tombstone:   newTombstone,
+			Transaction: newTransaction,
+
 			Timeout: r.flag.Handler.Timeout,
 		}
 
@@ -141,16 +408,53 @@ func (r *runner) run(ctx context.Context, cmd *cobra.Command, args []string) err
 		}
 	}
 
+	var orphanDeleteHandler handler.Interface
+	{
+		c := orphandelete.HandlerConfig{
+			Logger:      r.logger,
+			Redigo:      redigoClient,
+			Rescue:      rescueEngine,
+			Transaction: newTransaction,
+
+			DryRun:  r.flag.Handler.DryRun,
+			Timeout: r.flag.Handler.WalkTimeout,
+		}
+
+		orphanDeleteHandler, err = orphandelete.NewHandler(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var preferenceUpdateHandler handler.Interface
+	{
+		c := preferenceupdate.HandlerConfig{
+			Logger:     r.logger,
+			Preference: newPreference,
+
+			Timeout: r.flag.Handler.Timeout,
+		}
+
+		preferenceUpdateHandler, err = preferenceupdate.NewHandler(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
 	var reminderCreateUser handler.Interface
 	{
 		c := remindercreate.UserConfig{
-			Logger: r.logger,
-			Redigo: redigoClient,
-			Rescue: rescueEngine,
-
-			PostmarkTokenAccount: r.flag.Postmark.Token.Account,
-			PostmarkTokenServer:  r.flag.Postmark.Token.Server,
-			Timeout:              r.flag.Handler.Timeout,
+			Logger:      r.logger,
+			Mailer:      newMailer,
+			Preference:  newPreference,
+			ReadMarker:  newReadMarker,
+			Redigo:      redigoClient,
+			Render:      newRender,
+			Rescue:      rescueEngine,
+			Unsubscribe: newUnsubscribe,
+
+			Limit:   r.flag.Reminder.Limit,
+			Timeout: r.flag.Handler.Timeout,
 		}
 
 		reminderCreateUser, err = remindercreate.NewUser(c)
@@ -159,17 +463,18 @@ func (r *runner) run(ctx context.Context, cmd *cobra.Command, args []string) err
 		}
 	}
 
-	var reminderCreateWeekly handler.Interface
+	var reminderCreateHourly handler.Interface
 	{
-		c := remindercreate.WeeklyConfig{
-			Logger: r.logger,
-			Redigo: redigoClient,
-			Rescue: rescueEngine,
+		c := remindercreate.HourlyConfig{
+			Logger:     r.logger,
+			Preference: newPreference,
+			Redigo:     redigoClient,
+			Rescue:     rescueEngine,
 
-			Timeout: r.flag.Handler.Timeout,
+			Timeout: r.flag.Handler.WalkTimeout,
 		}
 
-		reminderCreateWeekly, err = remindercreate.NewWeekly(c)
+		reminderCreateHourly, err = remindercreate.NewHourly(c)
 		if err != nil {
 			return tracer.Mask(err)
 		}
@@ -178,10 +483,13 @@ func (r *runner) run(ctx context.Context, cmd *cobra.Command, args []string) err
 	var roleDeleteHandler handler.Interface
 	{
 		c := roledelete.HandlerConfig{
-			Logger: r.logger,
-			Redigo: redigoClient,
-			Rescue: rescueEngine,
+			Logger:      r.logger,
+			Redigo:      redigoClient,
+			Rescue:      rescueEngine,
+			Tombstone:   newTombstone,
+			Transaction: newTransaction,
 
+			DryRun:  r.flag.Handler.DryRun,
 			Timeout: r.flag.Handler.Timeout,
 		}
 
@@ -194,11 +502,13 @@ func (r *runner) run(ctx context.Context, cmd *cobra.Command, args []string) err
 	var subjectDeleteHandler handler.Interface
 	{
 		c := subjectdelete.HandlerConfig{
-			Logger: r.logger,
-			Redigo: redigoClient,
-			Rescue: rescueEngine,
+			Logger:      r.logger,
+			Redigo:      redigoClient,
+			Rescue:      rescueEngine,
+			Transaction: newTransaction,
 
-			Timeout: r.flag.Handler.Timeout,
+			DryRun:  r.flag.Handler.DryRun,
+			Timeout: r.flag.Handler.WalkTimeout,
 		}
 
 		subjectDeleteHandler, err = subjectdelete.NewHandler(c)
@@ -207,13 +517,35 @@ func (r *runner) run(ctx context.Context, cmd *cobra.Command, args []string) err
 		}
 	}
 
+	var subjectIndexHandler handler.Interface
+	{
+		c := subjectindex.HandlerConfig{
+			Logger:      r.logger,
+			Redigo:      redigoClient,
+			Transaction: newTransaction,
+
+			DryRun:  r.flag.Handler.DryRun,
+			Timeout: r.flag.Handler.WalkTimeout,
+		}
+
+		subjectIndexHandler, err = subjectindex.NewHandler(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
 	var timelineDeleteHandler handler.Interface
 	{
 		c := timelinedelete.HandlerConfig{
-			Logger: r.logger,
-			Redigo: redigoClient,
-			Rescue: rescueEngine,
-
+			Archive:     newArchive,
+			Cursor:      newCursor,
+			Logger:      r.logger,
+			Redigo:      redigoClient,
+			Rescue:      rescueEngine,
+			Tombstone:   newTombstone,
+			Transaction: newTransaction,
+
+			DryRun:  r.flag.Handler.DryRun,
 			Timeout: r.flag.Handler.Timeout,
 		}
 
@@ -223,13 +555,66 @@ func (r *runner) run(ctx context.Context, cmd *cobra.Command, args []string) err
 		}
 	}
 
+	var timelineMoveHandler handler.Interface
+	{
+		c := timelinemove.HandlerConfig{
+			Logger:      r.logger,
+			Redigo:      redigoClient,
+			Transaction: newTransaction,
+
+			ChunkSize: r.flag.Handler.ChunkSize,
+			DryRun:    r.flag.Handler.DryRun,
+			Timeout:   r.flag.Handler.Timeout,
+		}
+
+		timelineMoveHandler, err = timelinemove.NewHandler(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var tombstoneDeleteHandler handler.Interface
+	{
+		c := tombstonedelete.HandlerConfig{
+			Logger:    r.logger,
+			Tombstone: newTombstone,
+
+			Timeout: r.flag.Handler.Timeout,
+		}
+
+		tombstoneDeleteHandler, err = tombstonedelete.NewHandler(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var tombstoneRestoreHandler handler.Interface
+	{
+		c := tombstonerestore.HandlerConfig{
+			Logger:    r.logger,
+			Tombstone: newTombstone,
+
+			Timeout: r.flag.Handler.Timeout,
+		}
+
+		tombstoneRestoreHandler, err = tombstonerestore.NewHandler(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
 	var updateDeleteHandler handler.Interface
 	{
 		c := updatedelete.HandlerConfig{
-			Logger: r.logger,
-			Redigo: redigoClient,
-			Rescue: rescueEngine,
-
+			Archive:     newArchive,
+			Cursor:      newCursor,
+			Logger:      r.logger,
+			Redigo:      redigoClient,
+			Rescue:      rescueEngine,
+			Tombstone:   newTombstone,
+			Transaction: newTransaction,
+
+			DryRun:  r.flag.Handler.DryRun,
 			Timeout: r.flag.Handler.Timeout,
 		}
 
@@ -242,10 +627,15 @@ func (r *runner) run(ctx context.Context, cmd *cobra.Command, args []string) err
 	var userDeleteHandler handler.Interface
 	{
 		c := userdelete.HandlerConfig{
-			Logger: r.logger,
-			Redigo: redigoClient,
-			Rescue: rescueEngine,
-
+			Blob:        newBlob,
+			Cursor:      newCursor,
+			Logger:      r.logger,
+			Redigo:      redigoClient,
+			Rescue:      rescueEngine,
+			Transaction: newTransaction,
+
+			DryRun:  r.flag.Handler.DryRun,
+			Erasure: r.flag.Handler.Erasure,
 			Timeout: r.flag.Handler.Timeout,
 		}
 
@@ -255,13 +645,35 @@ func (r *runner) run(ctx context.Context, cmd *cobra.Command, args []string) err
 		}
 	}
 
+	var userExportHandler handler.Interface
+	{
+		c := userexport.HandlerConfig{
+			Cursor:      newCursor,
+			Logger:      r.logger,
+			Redigo:      redigoClient,
+			Transaction: newTransaction,
+
+			Timeout: r.flag.Handler.Timeout,
+		}
+
+		userExportHandler, err = userexport.NewHandler(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
 	var ventureDeleteHandler handler.Interface
 	{
 		c := venturedelete.HandlerConfig{
-			Logger: r.logger,
-			Redigo: redigoClient,
-			Rescue: rescueEngine,
-
+			Archive:     newArchive,
+			Cursor:      newCursor,
+			Logger:      r.logger,
+			Redigo:      redigoClient,
+			Rescue:      rescueEngine,
+			Tombstone:   newTombstone,
+			Transaction: newTransaction,
+
+			DryRun:  r.flag.Handler.DryRun,
 			Timeout: r.flag.Handler.Timeout,
 		}
 
@@ -271,6 +683,26 @@ func (r *runner) run(ctx context.Context, cmd *cobra.Command, args []string) err
 		}
 	}
 
+	var ventureTransferHandler handler.Interface
+	{
+		c := venturetransfer.HandlerConfig{
+			Logger:      r.logger,
+			Mailer:      newMailer,
+			Preference:  newPreference,
+			Redigo:      redigoClient,
+			Render:      newRender,
+			Transaction: newTransaction,
+
+			DryRun:  r.flag.Handler.DryRun,
+			Timeout: r.flag.Handler.Timeout,
+		}
+
+		ventureTransferHandler, err = venturetransfer.NewHandler(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
 	//************************************************************************//
 
 	var donCha chan struct{}
@@ -291,17 +723,34 @@ func (r *runner) run(ctx context.Context, cmd *cobra.Command, args []string) err
 		c := queue.ControllerConfig{
 			DonCha: donCha,
 			ErrCha: errCha,
+			// Handlers are executed in the given order. Note that the user
+			// delete handler has to run before the role and subject delete
+			// handlers. These wait for the deleted user to be gone, because
+			// the user delete handler looks up the memberships of the user
+			// via its roles and subject associations in order to erase the
+			// user's content. Running the user delete handler first allows
+			// all of them to complete within the same execution.
 			Handler: []handler.Interface{
+				archiveDeleteHandler,
 				inviteDeleteHandler,
+				inviteExpireHandler,
 				messageDeleteHandler,
+				orphanDeleteHandler,
+				preferenceUpdateHandler,
 				reminderCreateUser,
-				reminderCreateWeekly,
+				reminderCreateHourly,
+				userDeleteHandler,
 				roleDeleteHandler,
 				subjectDeleteHandler,
+				subjectIndexHandler,
 				timelineDeleteHandler,
+				timelineMoveHandler,
+				tombstoneDeleteHandler,
+				tombstoneRestoreHandler,
 				updateDeleteHandler,
-				userDeleteHandler,
+				userExportHandler,
 				ventureDeleteHandler,
+				ventureTransferHandler,
 			},
 			Logger: r.logger,
 			Redigo: redigoClient,
@@ -319,16 +768,27 @@ func (r *runner) run(ctx context.Context, cmd *cobra.Command, args []string) err
 	var newServer *server.Server
 	{
 		c := server.Config{
+			Capture: captureMailer,
 			Collector: []prometheus.Collector{
 				prometheus.NewGoCollector(),
 				prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
 				rescueCollector,
+				newMailer,
 			},
-			Logger: r.logger,
+			Controller:  newController,
+			Logger:      r.logger,
+			Render:      newRender,
+			Suppression: newSuppression,
+			Unsubscribe: newUnsubscribe,
 
+			Debug:    r.flag.Metrics.Debug,
 			ErrCha:   errCha,
 			HTTPHost: r.flag.Metrics.Host,
 			HTTPPort: r.flag.Metrics.Port,
+
+			PublicHost:   r.flag.ApiWorker.Host,
+			PublicPort:   r.flag.ApiWorker.Port,
+			WebhookToken: r.flag.Postmark.Token.Webhook,
 		}
 
 		newServer, err = server.New(c)
@@ -340,6 +800,7 @@ func (r *runner) run(ctx context.Context, cmd *cobra.Command, args []string) err
 	{
 		go newController.Boot()
 		go newServer.ListenHTTP()
+		go newServer.ListenPublic()
 	}
 
 	{
@@ -0,0 +1,49 @@
+package fsck
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/tracer"
+)
+
+const (
+	name  = "fsck"
+	short = "Check the referential integrity of the data stored in redis."
+	long  = `Check the referential integrity of the data stored in redis. The check
+looks for roles of resources which do not exist anymore, subject associations
+of deleted users, invites of deleted ventures and values which cannot be
+unmarshalled into their schema types. The findings are printed as JSON report.
+Problems which can be repaired automatically are fixed by enqueueing delete
+tasks when --repair is given.`
+)
+
+type Config struct {
+	Logger logger.Interface
+}
+
+func New(config Config) (*cobra.Command, error) {
+	if config.Logger == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", config)
+	}
+
+	var c *cobra.Command
+	{
+		f := &flag{}
+
+		r := &runner{
+			flag:   f,
+			logger: config.Logger,
+		}
+
+		c = &cobra.Command{
+			Use:   name,
+			Short: short,
+			Long:  long,
+			RunE:  r.Run,
+		}
+
+		f.Init(c)
+	}
+
+	return c, nil
+}
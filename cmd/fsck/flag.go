@@ -0,0 +1,49 @@
+package fsck
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/xh3b4sd/tracer"
+)
+
+type flag struct {
+	Redis struct {
+		Host string
+		Kind string
+		Port string
+	}
+	Repair  bool
+	Timeout time.Duration
+}
+
+func (f *flag) Init(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&f.Redis.Host, "redis-host", "", "127.0.0.1", "The host for connecting with redis.")
+	cmd.Flags().StringVarP(&f.Redis.Kind, "redis-kind", "", "single", "The kind of redis to connect to, e.g. simple or sentinel.")
+	cmd.Flags().StringVarP(&f.Redis.Port, "redis-port", "", "6379", "The port for connecting with redis.")
+
+	cmd.Flags().BoolVarP(&f.Repair, "repair", "", false, "Whether to enqueue delete tasks for problems that can be repaired automatically.")
+	cmd.Flags().DurationVarP(&f.Timeout, "timeout", "", time.Minute, "The timeout for walking the keyspace of a single check.")
+}
+
+func (f *flag) Validate() error {
+	{
+		if f.Redis.Host == "" {
+			return tracer.Maskf(invalidFlagError, "--redis-host must not be empty")
+		}
+		if f.Redis.Kind == "" {
+			return tracer.Maskf(invalidFlagError, "--redis-kind must not be empty")
+		}
+		if f.Redis.Port == "" {
+			return tracer.Maskf(invalidFlagError, "--redis-port must not be empty")
+		}
+	}
+
+	{
+		if f.Timeout == 0 {
+			return tracer.Maskf(invalidFlagError, "--timeout must not be empty")
+		}
+	}
+
+	return nil
+}
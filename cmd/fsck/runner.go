@@ -0,0 +1,113 @@
+package fsck
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/redigo/pkg/client"
+	"github.com/xh3b4sd/rescue"
+	"github.com/xh3b4sd/rescue/pkg/engine"
+	"github.com/xh3b4sd/rescue/pkg/metric"
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/fsck"
+)
+
+type runner struct {
+	flag   *flag
+	logger logger.Interface
+}
+
+func (r *runner) Run(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	err := r.flag.Validate()
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	err = r.run(ctx, cmd, args)
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	return nil
+}
+
+func (r *runner) run(ctx context.Context, cmd *cobra.Command, args []string) error {
+	var err error
+
+	var redigoClient redigo.Interface
+	{
+		c := client.Config{
+			Address: net.JoinHostPort(r.flag.Redis.Host, r.flag.Redis.Port),
+			Kind:    r.flag.Redis.Kind,
+		}
+
+		redigoClient, err = client.New(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var rescueEngine rescue.Interface
+	{
+		c := engine.Config{
+			Logger: r.logger,
+			Metric: metric.New(),
+			Redigo: redigoClient,
+		}
+
+		rescueEngine, err = engine.New(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var checker *fsck.Checker
+	{
+		c := fsck.Config{
+			Redigo: redigoClient,
+			Rescue: rescueEngine,
+
+			Timeout: r.flag.Timeout,
+		}
+
+		checker, err = fsck.New(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var rep *fsck.Report
+	{
+		rep, err = checker.Check()
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	if r.flag.Repair {
+		err = checker.Repair(rep)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	{
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+
+		err = e.Encode(rep)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	return nil
+}
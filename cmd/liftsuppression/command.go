@@ -0,0 +1,50 @@
+package liftsuppression
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/tracer"
+)
+
+const (
+	name  = "lift-suppression"
+	short = "Lift the suppression of an email address."
+	long  = `Lift the suppression of an email address. Addresses are suppressed once the
+mail backend reports them as inactive, or once postmark reports hard bounces or
+spam complaints via webhooks. No emails are sent to suppressed addresses. This
+command reactivates the address given by --address at postmark and deletes its
+suppression, so that emails are sent to it again. Addresses postmark cannot
+reactivate, e.g. due to spam complaints, stay suppressed. --postmark-skip only
+deletes the suppression. The lifted suppression is printed as JSON.`
+)
+
+type Config struct {
+	Logger logger.Interface
+}
+
+func New(config Config) (*cobra.Command, error) {
+	if config.Logger == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", config)
+	}
+
+	var c *cobra.Command
+	{
+		f := &flag{}
+
+		r := &runner{
+			flag:   f,
+			logger: config.Logger,
+		}
+
+		c = &cobra.Command{
+			Use:   name,
+			Short: short,
+			Long:  long,
+			RunE:  r.Run,
+		}
+
+		f.Init(c)
+	}
+
+	return c, nil
+}
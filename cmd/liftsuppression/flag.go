@@ -0,0 +1,69 @@
+package liftsuppression
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/xh3b4sd/tracer"
+)
+
+type flag struct {
+	Address  string
+	DryRun   bool
+	Postmark struct {
+		Skip  bool
+		Token struct {
+			Account string
+			Server  string
+		}
+	}
+	Redis struct {
+		Host string
+		Kind string
+		Port string
+	}
+}
+
+func (f *flag) Init(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&f.Postmark.Skip, "postmark-skip", "", false, "Whether to lift the suppression without reactivating the address at postmark.")
+	cmd.Flags().StringVarP(&f.Postmark.Token.Account, "postmark-token-account", "", os.Getenv("APIWORKER_POSTMARK_TOKEN_ACCOUNT"), "The postmark account token used to reactivate the address.")
+	cmd.Flags().StringVarP(&f.Postmark.Token.Server, "postmark-token-server", "", os.Getenv("APIWORKER_POSTMARK_TOKEN_SERVER"), "The postmark server token used to reactivate the address.")
+
+	cmd.Flags().StringVarP(&f.Redis.Host, "redis-host", "", "127.0.0.1", "The host for connecting with redis.")
+	cmd.Flags().StringVarP(&f.Redis.Kind, "redis-kind", "", "single", "The kind of redis to connect to, e.g. simple or sentinel.")
+	cmd.Flags().StringVarP(&f.Redis.Port, "redis-port", "", "6379", "The port for connecting with redis.")
+
+	cmd.Flags().StringVarP(&f.Address, "address", "", "", "The email address to lift the suppression of.")
+	cmd.Flags().BoolVarP(&f.DryRun, "dry-run", "", false, "Whether to only print the suppression which would be lifted.")
+}
+
+func (f *flag) Validate() error {
+	if !f.Postmark.Skip {
+		if f.Postmark.Token.Account == "" {
+			return tracer.Maskf(invalidFlagError, "--postmark-token-account must not be empty")
+		}
+		if f.Postmark.Token.Server == "" {
+			return tracer.Maskf(invalidFlagError, "--postmark-token-server must not be empty")
+		}
+	}
+
+	{
+		if f.Redis.Host == "" {
+			return tracer.Maskf(invalidFlagError, "--redis-host must not be empty")
+		}
+		if f.Redis.Kind == "" {
+			return tracer.Maskf(invalidFlagError, "--redis-kind must not be empty")
+		}
+		if f.Redis.Port == "" {
+			return tracer.Maskf(invalidFlagError, "--redis-port must not be empty")
+		}
+	}
+
+	{
+		if f.Address == "" {
+			return tracer.Maskf(invalidFlagError, "--address must not be empty")
+		}
+	}
+
+	return nil
+}
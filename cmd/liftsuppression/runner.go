@@ -0,0 +1,117 @@
+package liftsuppression
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/redigo/pkg/client"
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/mailer/postmark"
+	"github.com/venturemark/apiworker/pkg/suppression"
+)
+
+type runner struct {
+	flag   *flag
+	logger logger.Interface
+}
+
+func (r *runner) Run(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	err := r.flag.Validate()
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	err = r.run(ctx, cmd, args)
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	return nil
+}
+
+func (r *runner) run(ctx context.Context, cmd *cobra.Command, args []string) error {
+	var err error
+
+	var redigoClient redigo.Interface
+	{
+		c := client.Config{
+			Address: net.JoinHostPort(r.flag.Redis.Host, r.flag.Redis.Port),
+			Kind:    r.flag.Redis.Kind,
+		}
+
+		redigoClient, err = client.New(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var newSuppression *suppression.Store
+	{
+		c := suppression.Config{
+			Redigo: redigoClient,
+		}
+
+		newSuppression, err = suppression.New(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var ent *suppression.Entry
+	{
+		ent, err = newSuppression.Search(r.flag.Address)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	// Postmark rejects emails to addresses it marked inactive, which would
+	// suppress the address again with the next email sent to it. So the
+	// address is reactivated at postmark before its suppression is lifted.
+	if !r.flag.Postmark.Skip && !r.flag.DryRun {
+		c := postmark.MailerConfig{
+			TokenAccount: r.flag.Postmark.Token.Account,
+			TokenServer:  r.flag.Postmark.Token.Server,
+		}
+
+		m, err := postmark.NewMailer(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		cou, err := m.Activate(r.flag.Address)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		r.logger.Log(ctx, "level", "info", "message", "reactivated address at postmark", "address", r.flag.Address, "bounces", strconv.Itoa(cou))
+	}
+
+	if !r.flag.DryRun {
+		err = newSuppression.Delete(r.flag.Address)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	{
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+
+		err = e.Encode(ent)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	return nil
+}
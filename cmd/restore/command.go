@@ -0,0 +1,49 @@
+package restore
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/tracer"
+)
+
+const (
+	name  = "restore-from-archive"
+	short = "Restore deleted resources from the archive."
+	long  = `Restore deleted resources from the archive. Deletion handlers archive the
+values of ventures, timelines, updates and messages before removing them from
+redis. This command writes the most recent archive of the resource given by
+--resource and --id back to redis. With --cascade the archives of all child
+resources are restored as well, e.g. the timelines, updates and messages of a
+venture. The names of the restored archives are printed as JSON.`
+)
+
+type Config struct {
+	Logger logger.Interface
+}
+
+func New(config Config) (*cobra.Command, error) {
+	if config.Logger == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", config)
+	}
+
+	var c *cobra.Command
+	{
+		f := &flag{}
+
+		r := &runner{
+			flag:   f,
+			logger: config.Logger,
+		}
+
+		c = &cobra.Command{
+			Use:   name,
+			Short: short,
+			Long:  long,
+			RunE:  r.Run,
+		}
+
+		f.Init(c)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,72 @@
+package restore
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/xh3b4sd/tracer"
+)
+
+type flag struct {
+	Blob struct {
+		Directory string
+		Kind      string
+	}
+	Cascade bool
+	DryRun  bool
+	ID      string
+	Redis   struct {
+		Host   string
+		Kind   string
+		Master string
+		Port   string
+	}
+	Resource string
+}
+
+func (f *flag) Init(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&f.Blob.Directory, "blob-directory", "", "/var/lib/apiworker", "The directory for storing blobs if the blob kind is local.")
+	cmd.Flags().StringVarP(&f.Blob.Kind, "blob-kind", "", "local", "The kind of blob store to read archives from, e.g. local.")
+
+	cmd.Flags().StringVarP(&f.Redis.Host, "redis-host", "", "127.0.0.1", "The host for connecting with redis.")
+	cmd.Flags().StringVarP(&f.Redis.Kind, "redis-kind", "", "single", "The kind of redis to connect to, e.g. simple or sentinel.")
+	cmd.Flags().StringVarP(&f.Redis.Master, "redis-master", "", "mymaster", "The name of the master monitored by the sentinel, in case --redis-kind is sentinel.")
+	cmd.Flags().StringVarP(&f.Redis.Port, "redis-port", "", "6379", "The port for connecting with redis.")
+
+	cmd.Flags().BoolVarP(&f.Cascade, "cascade", "", true, "Whether to restore the archives of child resources as well.")
+	cmd.Flags().BoolVarP(&f.DryRun, "dry-run", "", false, "Whether to only print the archives which would be restored.")
+	cmd.Flags().StringVarP(&f.ID, "id", "", "", "The ID of the resource to restore.")
+	cmd.Flags().StringVarP(&f.Resource, "resource", "", "", "The kind of resource to restore, e.g. venture, timeline, update or message.")
+}
+
+func (f *flag) Validate() error {
+	{
+		if f.Blob.Kind != "local" {
+			return tracer.Maskf(invalidFlagError, "--blob-kind must be local")
+		}
+		if f.Blob.Directory == "" {
+			return tracer.Maskf(invalidFlagError, "--blob-directory must not be empty")
+		}
+	}
+
+	{
+		if f.Redis.Host == "" {
+			return tracer.Maskf(invalidFlagError, "--redis-host must not be empty")
+		}
+		if f.Redis.Kind == "" {
+			return tracer.Maskf(invalidFlagError, "--redis-kind must not be empty")
+		}
+		if f.Redis.Port == "" {
+			return tracer.Maskf(invalidFlagError, "--redis-port must not be empty")
+		}
+	}
+
+	{
+		if f.ID == "" {
+			return tracer.Maskf(invalidFlagError, "--id must not be empty")
+		}
+		if f.Resource == "" {
+			return tracer.Maskf(invalidFlagError, "--resource must not be empty")
+		}
+	}
+
+	return nil
+}
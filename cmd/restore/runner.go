@@ -0,0 +1,142 @@
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/spf13/cobra"
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/archive"
+	"github.com/venturemark/apiworker/pkg/blob"
+	"github.com/venturemark/apiworker/pkg/blob/local"
+	"github.com/venturemark/apiworker/pkg/transaction"
+	"github.com/venturemark/apiworker/pkg/transaction/multi"
+)
+
+type runner struct {
+	flag   *flag
+	logger logger.Interface
+}
+
+func (r *runner) Run(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	err := r.flag.Validate()
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	err = r.run(ctx, cmd, args)
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	return nil
+}
+
+func (r *runner) run(ctx context.Context, cmd *cobra.Command, args []string) error {
+	var err error
+
+	var newBlob blob.Interface
+	{
+		c := local.BlobConfig{
+			Directory: r.flag.Blob.Directory,
+		}
+
+		newBlob, err = local.NewBlob(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var redisPool *redis.Pool
+	{
+		c := multi.PoolConfig{
+			Address: net.JoinHostPort(r.flag.Redis.Host, r.flag.Redis.Port),
+			Kind:    r.flag.Redis.Kind,
+			Master:  r.flag.Redis.Master,
+		}
+
+		redisPool, err = multi.NewPool(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var newTransaction transaction.Interface
+	{
+		c := multi.TransactionConfig{
+			Pool: redisPool,
+		}
+
+		newTransaction, err = multi.NewTransaction(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var newArchive *archive.Archive
+	{
+		c := archive.Config{
+			Blob:        newBlob,
+			Transaction: newTransaction,
+		}
+
+		newArchive, err = archive.New(c)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var met map[string]string
+	{
+		met, err = archive.Metadata(r.flag.Resource, r.flag.ID)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if !r.flag.Cascade {
+			met[metadata.TaskResource] = r.flag.Resource
+		}
+	}
+
+	var ent []*archive.Entry
+	{
+		ent, err = newArchive.Search(met)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		ent = archive.Latest(ent)
+	}
+
+	nam := []string{}
+	for _, e := range ent {
+		if !r.flag.DryRun {
+			err = newArchive.Restore(e)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+		}
+
+		nam = append(nam, e.Name)
+	}
+
+	{
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+
+		err = e.Encode(nam)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,278 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/blob"
+	"github.com/venturemark/apiworker/pkg/tombstone"
+	"github.com/venturemark/apiworker/pkg/transaction"
+)
+
+const (
+	// Prefix is the blob prefix below which all archives are stored. Archives
+	// are named archive/<resource>/<id>/<unix nano>.json, so that they can be
+	// looked up by resource and expired by the time of their creation.
+	Prefix = "archive"
+)
+
+var (
+	// resource maps the resource kinds being archived to the metadata key of
+	// their resource ID.
+	resource = map[string]string{
+		"message":  metadata.MessageID,
+		"timeline": metadata.TimelineID,
+		"update":   metadata.UpdateID,
+		"venture":  metadata.VentureID,
+	}
+)
+
+// Entry is the archive of a single deleted resource. It carries the same
+// information as a tombstone, but is kept in a cold store outside of redis.
+type Entry struct {
+	Archived int64                `json:"archived"`
+	Element  []*tombstone.Element `json:"element"`
+	Metadata map[string]string    `json:"metadata"`
+	Name     string               `json:"-"`
+}
+
+type Config struct {
+	Blob        blob.Interface
+	Transaction transaction.Interface
+
+	// Retention is the period of time for which archives are kept before
+	// being deleted irrecoverably. Archiving is disabled when Retention is
+	// zero.
+	Retention time.Duration
+}
+
+type Archive struct {
+	blob        blob.Interface
+	transaction transaction.Interface
+
+	retention time.Duration
+}
+
+func New(config Config) (*Archive, error) {
+	if config.Blob == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Blob must not be empty", config)
+	}
+	if config.Transaction == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Transaction must not be empty", config)
+	}
+
+	a := &Archive{
+		blob:        config.Blob,
+		transaction: config.Transaction,
+
+		retention: config.Retention,
+	}
+
+	return a, nil
+}
+
+// Create writes an archive for the given elements of the resource described
+// by the given task metadata. Elements being nil are ignored, so that callers
+// can pass through the results of tombstone.Simple and tombstone.Sorted for
+// data that does not exist anymore.
+func (a *Archive) Create(met map[string]string, ele ...*tombstone.Element) error {
+	var res string
+	var rid string
+	{
+		res = met[metadata.TaskResource]
+
+		k, ok := resource[res]
+		if !ok {
+			return tracer.Maskf(invalidResourceError, "%s", res)
+		}
+
+		rid = met[k]
+	}
+
+	var e *Entry
+	{
+		e = &Entry{
+			Archived: time.Now().UTC().UnixNano(),
+			Metadata: map[string]string{},
+		}
+
+		for k, v := range met {
+			e.Metadata[k] = v
+		}
+
+		for _, l := range ele {
+			if l != nil {
+				e.Element = append(e.Element, l)
+			}
+		}
+	}
+
+	if len(e.Element) == 0 {
+		return nil
+	}
+
+	{
+		byt, err := json.Marshal(e)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		_, err = a.blob.Write(path.Join(Prefix, res, rid, fmt.Sprintf("%d.json", e.Archived)), byt)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	return nil
+}
+
+// Delete removes the archive with the given name, which makes its data
+// irrecoverable.
+func (a *Archive) Delete(name string) error {
+	err := a.blob.Delete(name)
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	return nil
+}
+
+// Enabled expresses whether deletion handlers should write archives before
+// deleting data.
+func (a *Archive) Enabled() bool {
+	return a.retention != 0
+}
+
+// Expired returns the names of all archives of which the retention period
+// ended.
+func (a *Archive) Expired() ([]string, error) {
+	nam, err := a.blob.Search(Prefix)
+	if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	var exp []string
+	{
+		dea := time.Now().UTC().Add(-a.retention).UnixNano()
+
+		for _, n := range nam {
+			t, err := strconv.ParseInt(strings.TrimSuffix(path.Base(n), ".json"), 10, 64)
+			if err != nil {
+				continue
+			}
+
+			if t < dea {
+				exp = append(exp, n)
+			}
+		}
+	}
+
+	return exp, nil
+}
+
+// Latest returns the most recent archive of every resource among the given
+// archives, since a resource may have been archived multiple times, e.g. when
+// its deletion got retried.
+func Latest(ent []*Entry) []*Entry {
+	lat := map[string]*Entry{}
+
+	var dir []string
+	for _, e := range ent {
+		d := path.Dir(e.Name)
+
+		l, ok := lat[d]
+		if !ok {
+			dir = append(dir, d)
+		}
+
+		if !ok || l.Archived < e.Archived {
+			lat[d] = e
+		}
+	}
+
+	var res []*Entry
+	for _, d := range dir {
+		res = append(res, lat[d])
+	}
+
+	return res
+}
+
+// Metadata returns the metadata for searching the archives of the given
+// resource.
+func Metadata(res string, rid string) (map[string]string, error) {
+	k, ok := resource[res]
+	if !ok {
+		return nil, tracer.Maskf(invalidResourceError, "%s", res)
+	}
+
+	met := map[string]string{
+		k: rid,
+	}
+
+	return met, nil
+}
+
+// Restore writes all elements of the given archive back to their original
+// keys within a single transaction. The archive itself is kept until its
+// retention period ends.
+func (a *Archive) Restore(e *Entry) error {
+	var ops []transaction.Operation
+
+	for _, l := range e.Element {
+		switch l.Kind {
+		case tombstone.KindSimple:
+			ops = append(ops, transaction.SimpleCreate(l.Key, l.Value))
+		case tombstone.KindSorted:
+			ops = append(ops, transaction.SortedCreate(l.Key, l.Value, l.Score))
+		}
+	}
+
+	{
+		err := a.transaction.Execute(ops...)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	return nil
+}
+
+// Search returns all archives of which the metadata contains the given
+// metadata. Like with tombstones, searching for a venture ID returns the
+// archives of the venture and all of its timelines, updates and messages.
+func (a *Archive) Search(met map[string]string) ([]*Entry, error) {
+	nam, err := a.blob.Search(Prefix)
+	if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	var res []*Entry
+	for _, n := range nam {
+		byt, err := a.blob.Read(n)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		e := &Entry{}
+		err = json.Unmarshal(byt, e)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		e.Name = n
+
+		if metadata.Contains(e.Metadata, met) {
+			res = append(res, e)
+		}
+	}
+
+	return res, nil
+}
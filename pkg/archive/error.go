@@ -0,0 +1,23 @@
+package archive
+
+import (
+	"errors"
+
+	"github.com/xh3b4sd/tracer"
+)
+
+var invalidConfigError = &tracer.Error{
+	Kind: "invalidConfigError",
+}
+
+func IsInvalidConfig(err error) bool {
+	return errors.Is(err, invalidConfigError)
+}
+
+var invalidResourceError = &tracer.Error{
+	Kind: "invalidResourceError",
+}
+
+func IsInvalidResource(err error) bool {
+	return errors.Is(err, invalidResourceError)
+}
@@ -0,0 +1,106 @@
+package local
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/xh3b4sd/tracer"
+)
+
+type BlobConfig struct {
+	Directory string
+}
+
+type Blob struct {
+	directory string
+}
+
+func NewBlob(config BlobConfig) (*Blob, error) {
+	if config.Directory == "" {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Directory must not be empty", config)
+	}
+
+	b := &Blob{
+		directory: config.Directory,
+	}
+
+	return b, nil
+}
+
+func (b *Blob) Delete(name string) error {
+	err := os.Remove(b.path(name))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return tracer.Mask(err)
+	}
+
+	return nil
+}
+
+func (b *Blob) Read(name string) ([]byte, error) {
+	byt, err := os.ReadFile(b.path(name))
+	if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	return byt, nil
+}
+
+func (b *Blob) Search(prefix string) ([]string, error) {
+	var nam []string
+
+	err := filepath.WalkDir(b.path(prefix), func(p string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		r, err := filepath.Rel(b.directory, p)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		nam = append(nam, filepath.ToSlash(r))
+
+		return nil
+	})
+	if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	return nam, nil
+}
+
+func (b *Blob) Write(name string, byt []byte) (string, error) {
+	var p string
+	{
+		p = b.path(name)
+	}
+
+	{
+		err := os.MkdirAll(filepath.Dir(p), 0700)
+		if err != nil {
+			return "", tracer.Mask(err)
+		}
+	}
+
+	{
+		err := os.WriteFile(p, byt, 0600)
+		if err != nil {
+			return "", tracer.Mask(err)
+		}
+	}
+
+	return "file://" + filepath.ToSlash(p), nil
+}
+
+func (b *Blob) path(name string) string {
+	return filepath.Join(b.directory, filepath.FromSlash(name))
+}
@@ -0,0 +1,15 @@
+package blob
+
+type Interface interface {
+	// Delete removes the blob stored under the given name. Deleting a blob
+	// that does not exist is not an error.
+	Delete(name string) error
+	// Read returns the bytes stored under the given name.
+	Read(name string) ([]byte, error)
+	// Search returns the names of all blobs stored below the given prefix.
+	// Prefixes are slash separated paths, e.g. archive/venture.
+	Search(prefix string) ([]string, error)
+	// Write stores the given bytes under the given name and returns the
+	// location at which the blob can be retrieved again.
+	Write(name string, byt []byte) (string, error)
+}
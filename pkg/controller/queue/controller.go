@@ -3,6 +3,8 @@ package queue
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/venturemark/apicommon/pkg/metadata"
@@ -16,6 +18,7 @@ import (
 	"github.com/xh3b4sd/rescue/pkg/task"
 	"github.com/xh3b4sd/tracer"
 
+	"github.com/venturemark/apiworker/pkg/controller"
 	"github.com/venturemark/apiworker/pkg/handler"
 )
 
@@ -39,6 +42,8 @@ type Controller struct {
 	rescue  rescue.Interface
 
 	mutant mutant.Interface
+	mutex  sync.Mutex
+	state  controller.State
 
 	interval time.Duration
 }
@@ -81,6 +86,15 @@ func NewController(config ControllerConfig) (*Controller, error) {
 		}
 	}
 
+	var s controller.State
+	{
+		for _, h := range config.Handler {
+			s.Handler = append(s.Handler, fmt.Sprintf("%T", h))
+		}
+
+		s.Interval = config.Interval.String()
+	}
+
 	c := &Controller{
 		donCha:  config.DonCha,
 		errCha:  config.ErrCha,
@@ -90,6 +104,8 @@ func NewController(config ControllerConfig) (*Controller, error) {
 		rescue:  config.Rescue,
 
 		mutant: m,
+		mutex:  sync.Mutex{},
+		state:  s,
 
 		interval: config.Interval,
 	}
@@ -124,28 +140,164 @@ func (c *Controller) Boot() {
 	}
 }
 
+func (c *Controller) State() controller.State {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.state
+}
+
 func (c *Controller) createTasks() error {
-	o := func() error {
-		t := &task.Task{
-			Obj: task.TaskObj{
-				Metadata: map[string]string{
-					metadata.TaskAction:   "create",
-					metadata.TaskInterval: "weekly",
-					metadata.TaskResource: "reminder",
+	{
+		o := func() error {
+			t := &task.Task{
+				Obj: task.TaskObj{
+					Metadata: map[string]string{
+						metadata.TaskAction:   "create",
+						metadata.TaskInterval: "hourly",
+						metadata.TaskResource: "reminder",
+
+						handler.Scheduled: strconv.FormatInt(time.Now().UTC().Truncate(time.Hour).Unix(), 10),
+					},
 				},
-			},
+			}
+
+			err := c.rescue.Create(t)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			return nil
 		}
 
-		err := c.rescue.Create(t)
+		// Reminders are fanned out every hour, since users may choose the
+		// hour of the day at which they want to receive their reminders.
+		err := c.hourly("apiworker.venturemark.co:rem:hou", o)
 		if err != nil {
 			return tracer.Mask(err)
 		}
+	}
 
-		return nil
+	{
+		o := func() error {
+			t := &task.Task{
+				Obj: task.TaskObj{
+					Metadata: map[string]string{
+						metadata.TaskAction:   "delete",
+						metadata.TaskResource: "tombstone",
+					},
+				},
+			}
+
+			err := c.rescue.Create(t)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			return nil
+		}
+
+		err := c.hourly("apiworker.venturemark.co:tom:hou", o)
+		if err != nil {
+			return tracer.Mask(err)
+		}
 	}
 
 	{
-		err := c.weekly(o)
+		o := func() error {
+			t := &task.Task{
+				Obj: task.TaskObj{
+					Metadata: map[string]string{
+						metadata.TaskAction:   "delete",
+						metadata.TaskResource: "orphan",
+					},
+				},
+			}
+
+			err := c.rescue.Create(t)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			return nil
+		}
+
+		err := c.daily("apiworker.venturemark.co:orp:dai", o)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	{
+		o := func() error {
+			t := &task.Task{
+				Obj: task.TaskObj{
+					Metadata: map[string]string{
+						metadata.TaskAction:   "expire",
+						metadata.TaskResource: "invite",
+					},
+				},
+			}
+
+			err := c.rescue.Create(t)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			return nil
+		}
+
+		err := c.hourly("apiworker.venturemark.co:inv:hou", o)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	{
+		o := func() error {
+			t := &task.Task{
+				Obj: task.TaskObj{
+					Metadata: map[string]string{
+						metadata.TaskAction:   "index",
+						metadata.TaskResource: "subject",
+					},
+				},
+			}
+
+			err := c.rescue.Create(t)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			return nil
+		}
+
+		err := c.daily("apiworker.venturemark.co:sid:dai", o)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	{
+		o := func() error {
+			t := &task.Task{
+				Obj: task.TaskObj{
+					Metadata: map[string]string{
+						metadata.TaskAction:   "delete",
+						metadata.TaskResource: "archive",
+					},
+				},
+			}
+
+			err := c.rescue.Create(t)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			return nil
+		}
+
+		err := c.daily("apiworker.venturemark.co:arc:dai", o)
 		if err != nil {
 			return tracer.Mask(err)
 		}
@@ -193,6 +345,11 @@ func (c *Controller) searchTasks() error {
 			defer c.logger.Log(context.Background(), "level", "info", "message", "reconciled task", "resource", tsk.Obj.Metadata[metadata.TaskResource])
 
 			var inc bool
+			{
+				c.started(tsk)
+				defer func() { c.finished(inc, err) }()
+			}
+
 			for _, h := range c.handler {
 				if h.Filter(tsk) {
 					err = h.Ensure(tsk)
@@ -221,11 +378,105 @@ func (c *Controller) searchTasks() error {
 	return nil
 }
 
-func (c *Controller) weekly(o func() error) error {
+func (c *Controller) daily(k string, o func() error) error {
+	var t time.Time
+	{
+		t = time.Now().UTC()
+	}
+
+	var v string
+	{
+		v = fmt.Sprintf("%02d.%02d.%d", t.Day(), t.Month(), t.Year())
+	}
+
+	{
+		err := c.unique(k, v, o)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Controller) finished(inc bool, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// The current task may still be read by callers of State, so a new task
+	// is published instead of modifying the current one.
+	var t *controller.Task
+	{
+		fin := time.Now().UTC()
+
+		t = &controller.Task{
+			Metadata: c.state.Current.Metadata,
+			Finished: &fin,
+			Started:  c.state.Current.Started,
+		}
+	}
+
+	if err != nil && !IsIncompleteExecution(err) {
+		t.Error = err.Error()
+		c.state.Failed++
+	} else if inc {
+		c.state.Incomplete++
+	} else {
+		c.state.Reconciled++
+	}
+
+	c.state.Current = nil
+	c.state.Previous = t
+}
+
+func (c *Controller) hourly(k string, o func() error) error {
 	var t time.Time
 	{
 		t = time.Now().UTC()
-		if t.Hour() != 13 || t.Minute() != 30 {
+	}
+
+	var v string
+	{
+		v = fmt.Sprintf("%02d.%02d.%d.%02d", t.Day(), t.Month(), t.Year(), t.Hour())
+	}
+
+	{
+		err := c.unique(k, v, o)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Controller) started(tsk *task.Task) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	met := map[string]string{}
+	for k, v := range tsk.Obj.Metadata {
+		met[k] = v
+	}
+
+	c.state.Current = &controller.Task{
+		Metadata: met,
+		Started:  time.Now().UTC(),
+	}
+}
+
+// unique executes o at most once for the given value v. The value last
+// executed for is tracked in the simple key k.
+func (c *Controller) unique(k string, v string, o func() error) error {
+	// Checking the value before acquiring the lock prevents all worker
+	// processes from locking on every reconciliation loop.
+	{
+		val, err := c.redigo.Simple().Search().Value(k)
+		if err != nil && !simple.IsNotFound(err) {
+			return tracer.Mask(err)
+		}
+
+		if v == val {
 			return nil
 		}
 	}
@@ -246,13 +497,6 @@ func (c *Controller) weekly(o func() error) error {
 		}()
 	}
 
-	var k string
-	var v string
-	{
-		k = "apiworker.venturemark.co:rem:wee"
-		v = fmt.Sprintf("%02d.%02d.%d", t.Day(), t.Month(), t.Year())
-	}
-
 	{
 		val, err := c.redigo.Simple().Search().Value(k)
 		if err != nil && !simple.IsNotFound(err) {
@@ -0,0 +1,69 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/xh3b4sd/rescue/pkg/task"
+)
+
+// Test_Controller_finished ensures that finishing a task does not modify the
+// task previously returned by State, which may still be read concurrently,
+// e.g. when encoding the state for the debug endpoints.
+func Test_Controller_finished(t *testing.T) {
+	testCases := []struct {
+		err error
+		inc bool
+	}{
+		// Case 0 ensures that reconciled tasks are published as new tasks.
+		{},
+		// Case 1 ensures that incomplete tasks are published as new tasks.
+		{
+			inc: true,
+		},
+		// Case 2 ensures that failed tasks are published as new tasks.
+		{
+			err: errors.New("test error"),
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			c := &Controller{}
+
+			c.started(&task.Task{
+				Obj: task.TaskObj{
+					Metadata: map[string]string{
+						"task.venturemark.co/test": "true",
+					},
+				},
+			})
+
+			cur := c.State().Current
+			if cur.Finished != nil {
+				t.Fatal("expected current task not to be finished")
+			}
+
+			c.finished(tc.inc, tc.err)
+
+			if cur.Finished != nil {
+				t.Fatal("expected current task not to be modified")
+			}
+			if cur.Error != "" {
+				t.Fatal("expected current task not to be modified")
+			}
+
+			pre := c.State().Previous
+			if pre == cur {
+				t.Fatal("expected previous task to be a new task")
+			}
+			if pre.Finished == nil {
+				t.Fatal("expected previous task to be finished")
+			}
+			if (tc.err != nil) != (pre.Error != "") {
+				t.Fatalf("expected error %v, got %q", tc.err, pre.Error)
+			}
+		})
+	}
+}
@@ -5,12 +5,11 @@ import (
 	"strings"
 
 	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/handler"
 )
 
-var incompleteExecutionError = &tracer.Error{
-	Kind: "incompleteExecutionError",
-	Desc: "This error indicates that the execution of a task could not be completed successfully. Tasks should be rescheduled after incomplete execution in order to finish them accordingly.",
-}
+var incompleteExecutionError = handler.IncompleteExecutionError
 
 func IsIncompleteExecution(err error) bool {
 	return errors.Is(err, incompleteExecutionError)
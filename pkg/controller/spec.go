@@ -1,5 +1,33 @@
 package controller
 
+import "time"
+
 type Interface interface {
 	Boot()
+	State() State
+}
+
+// State describes what the controller is currently doing. It is meant for
+// debugging purposes, e.g. when inspecting a running worker process via its
+// debug endpoints.
+type State struct {
+	Handler  []string `json:"handler"`
+	Interval string   `json:"interval"`
+
+	Current  *Task `json:"current,omitempty"`
+	Previous *Task `json:"previous,omitempty"`
+
+	Failed     int `json:"failed"`
+	Incomplete int `json:"incomplete"`
+	Reconciled int `json:"reconciled"`
+}
+
+// Task describes a single task execution. Tasks are shared with readers of
+// State and must therefore never be modified once they got published.
+type Task struct {
+	Metadata map[string]string `json:"metadata"`
+
+	Error    string     `json:"error,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Started  time.Time  `json:"started"`
 }
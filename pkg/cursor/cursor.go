@@ -0,0 +1,299 @@
+package cursor
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/redigo/pkg/simple"
+	"github.com/xh3b4sd/tracer"
+)
+
+const (
+	// Prefix is the key prefix under which cursor positions are persisted.
+	// The full key of a cursor is the prefix followed by the key of the
+	// sorted set it iterates, see Key.
+	Prefix = "apiworker.venturemark.co:cur"
+)
+
+// Score returns the score of the given sorted set element. Cursors need to
+// know the scores of the elements they iterate in order to persist their
+// position.
+type Score func(s string) (float64, error)
+
+type Config struct {
+	Redigo redigo.Interface
+
+	// Size is the maximum number of elements returned per chunk.
+	Size int
+}
+
+// Cursor iterates large sorted sets chunk by chunk. Handlers processing a
+// chunk persist the score of the last element they processed, so that the
+// next execution of the same task resumes where the previous one left off.
+// Positions are tracked by score instead of index, because elements prior to
+// the cursor may get removed concurrently, e.g. by the child tasks created
+// for them.
+type Cursor struct {
+	redigo redigo.Interface
+
+	size int
+}
+
+func New(config Config) (*Cursor, error) {
+	if config.Redigo == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Redigo must not be empty", config)
+	}
+
+	if config.Size <= 0 {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Size must be greater than 0", config)
+	}
+
+	c := &Cursor{
+		redigo: config.Redigo,
+
+		size: config.Size,
+	}
+
+	return c, nil
+}
+
+func Key(k string) string {
+	return fmt.Sprintf("%s:%s", Prefix, k)
+}
+
+// Commit persists the given score as position of the cursor iterating the
+// sorted set k.
+func (c *Cursor) Commit(k string, s float64) error {
+	err := c.redigo.Simple().Create().Element(Key(k), strconv.FormatFloat(s, 'f', -1, 64))
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	return nil
+}
+
+// Next returns the chunk of elements of the sorted set k following the given
+// position p. The returned bool is true when the chunk is the last one.
+func (c *Cursor) Next(k string, p float64, sco Score) ([]string, bool, error) {
+	var lef int
+	{
+		i, err := c.start(k, p, sco)
+		if err != nil {
+			return nil, false, tracer.Mask(err)
+		}
+
+		lef = i
+	}
+
+	var str []string
+	{
+		l, err := c.redigo.Sorted().Search().Order(k, lef, lef+c.size-1)
+		if err != nil {
+			return nil, false, tracer.Mask(err)
+		}
+
+		str = l
+	}
+
+	return str, len(str) < c.size, nil
+}
+
+// Process hands the elements of the next chunk of the sorted set k to fun,
+// one by one, starting right after the persisted position of the cursor, and
+// persists the position of the last processed element afterwards. Processing
+// stops early once the given budget elapsed, so that handlers doing expensive
+// work per element, e.g. creating tasks, stay within their timeout. The
+// position of the elements processed successfully is persisted even if fun
+// fails, so that retries do not process them again. The returned bool is true
+// once all elements got processed. In dry runs no position is persisted, which
+// is why all remaining chunks are processed at once regardless of the budget,
+// so that dry runs report every element a real run would process.
+func (c *Cursor) Process(k string, dry bool, bud time.Duration, sco Score, fun func(s string) error) (bool, error) {
+	don, err := c.ProcessAs(k, k, dry, bud, sco, fun)
+	if err != nil {
+		return false, tracer.Mask(err)
+	}
+
+	return don, nil
+}
+
+// ProcessAs works like Process, but persists the position of the cursor under
+// the given name instead of the sorted set k, so that several iterations of
+// the same sorted set do not interfere with each other, e.g. an export of a
+// user and the deletion of the timeline the user's updates belong to.
+func (c *Cursor) ProcessAs(nam string, k string, dry bool, bud time.Duration, sco Score, fun func(s string) error) (bool, error) {
+	var p float64
+	{
+		s, err := c.Search(nam)
+		if err != nil {
+			return false, tracer.Mask(err)
+		}
+
+		p = s
+	}
+
+	if dry {
+		err := c.dry(k, p, sco, fun)
+		if err != nil {
+			return false, tracer.Mask(err)
+		}
+
+		return true, nil
+	}
+
+	str, don, err := c.Next(k, p, sco)
+	if err != nil {
+		return false, tracer.Mask(err)
+	}
+
+	var dea time.Time
+	{
+		dea = time.Now().Add(bud)
+	}
+
+	var cou int
+	for _, s := range str {
+		if cou != 0 && time.Now().After(dea) {
+			don = false
+			break
+		}
+
+		err = fun(s)
+		if err != nil {
+			break
+		}
+
+		p, err = sco(s)
+		if err != nil {
+			break
+		}
+
+		cou++
+	}
+
+	if cou != 0 {
+		err := c.Commit(nam, p)
+		if err != nil {
+			return false, tracer.Mask(err)
+		}
+	}
+
+	if err != nil {
+		return false, tracer.Mask(err)
+	}
+
+	return don, nil
+}
+
+// Search returns the persisted position of the cursor iterating the sorted
+// set k. The position is zero if the iteration has not started yet.
+func (c *Cursor) Search(k string) (float64, error) {
+	val, err := c.redigo.Simple().Search().Value(Key(k))
+	if simple.IsNotFound(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, tracer.Mask(err)
+	}
+
+	s, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return 0, tracer.Mask(err)
+	}
+
+	return s, nil
+}
+
+// after returns whether the element at index i of the sorted set k lies
+// beyond the position p, which is also the case if there is no element at
+// index i. This relies on Order returning elements in ascending order of
+// their scores, like ZRANGE does.
+func (c *Cursor) after(k string, i int, p float64, sco Score) (bool, error) {
+	str, err := c.redigo.Sorted().Search().Order(k, i, i)
+	if err != nil {
+		return false, tracer.Mask(err)
+	}
+
+	if len(str) == 0 {
+		return true, nil
+	}
+
+	s, err := sco(str[0])
+	if err != nil {
+		return false, tracer.Mask(err)
+	}
+
+	return s > p, nil
+}
+
+// dry hands all elements of the sorted set k following the position p to fun,
+// chunk by chunk, while tracking the position in memory only.
+func (c *Cursor) dry(k string, p float64, sco Score, fun func(s string) error) error {
+	for {
+		str, don, err := c.Next(k, p, sco)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		for _, s := range str {
+			err = fun(s)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			p, err = sco(s)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+		}
+
+		if don {
+			return nil
+		}
+	}
+}
+
+// start returns the index of the first element of the sorted set k lying
+// beyond the position p. Since the sorted set is ordered by score, the index
+// is found by probing exponentially growing indices first and bisecting the
+// last interval afterwards. That way only a logarithmic number of elements is
+// read, no matter how many elements got processed already.
+func (c *Cursor) start(k string, p float64, sco Score) (int, error) {
+	if p == 0 {
+		return 0, nil
+	}
+
+	var lef int
+	var rig int
+	for {
+		ok, err := c.after(k, rig, p, sco)
+		if err != nil {
+			return 0, tracer.Mask(err)
+		}
+
+		if ok {
+			break
+		}
+
+		lef = rig + 1
+		rig = 2*rig + 1
+	}
+
+	for lef < rig {
+		m := (lef + rig) / 2
+
+		ok, err := c.after(k, m, p, sco)
+		if err != nil {
+			return 0, tracer.Mask(err)
+		}
+
+		if ok {
+			rig = m
+		} else {
+			lef = m + 1
+		}
+	}
+
+	return lef, nil
+}
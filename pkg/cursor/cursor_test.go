@@ -0,0 +1,191 @@
+package cursor
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/venturemark/apiworker/pkg/redistest"
+	"github.com/venturemark/apiworker/pkg/transaction"
+)
+
+const (
+	// testKey is the sorted set the tests below iterate.
+	testKey = "apiworker.venturemark.co:test:cursor"
+)
+
+// seed adds the elements 1 to n to the test sorted set, scored by their own
+// value. Elements are added in descending order, so that the order of the
+// sorted set does not depend on the insertion order. The cursor relies on the
+// ordering guarantees of the sorted sets of the redigo client, which is why it
+// is tested against real sorted sets.
+func seed(t *testing.T, tra transaction.Interface, n int) {
+	var ops []transaction.Operation
+	for i := n; i > 0; i-- {
+		ops = append(ops, transaction.SortedCreate(testKey, strconv.Itoa(i), float64(i)))
+	}
+
+	err := tra.Execute(ops...)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func score(s string) (float64, error) {
+	return strconv.ParseFloat(s, 64)
+}
+
+func Test_Cursor_Next(t *testing.T) {
+	red, tra := redistest.New(t)
+
+	seed(t, tra, 25)
+
+	cur, err := New(Config{Redigo: red, Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name string
+		pos  float64
+		str  []string
+		don  bool
+	}{
+		{
+			name: "case 0: start from the beginning",
+			pos:  0,
+			str:  []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"},
+			don:  false,
+		},
+		{
+			name: "case 1: resume after an element",
+			pos:  12,
+			str:  []string{"13", "14", "15", "16", "17", "18", "19", "20", "21", "22"},
+			don:  false,
+		},
+		{
+			name: "case 2: resume between elements",
+			pos:  19.5,
+			str:  []string{"20", "21", "22", "23", "24", "25"},
+			don:  true,
+		},
+		{
+			name: "case 3: resume after the last element",
+			pos:  25,
+			str:  nil,
+			don:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			str, don, err := cur.Next(testKey, tc.pos, score)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(str) != 0 || len(tc.str) != 0 {
+				if !reflect.DeepEqual(str, tc.str) {
+					t.Fatalf("expected %v, got %v", tc.str, str)
+				}
+			}
+			if don != tc.don {
+				t.Fatalf("expected done %t, got %t", tc.don, don)
+			}
+		})
+	}
+}
+
+// Test_Cursor_Process verifies that all elements are processed exactly once
+// and in ascending order, even if processed elements get removed between
+// executions, like the children of deleted resources do.
+func Test_Cursor_Process(t *testing.T) {
+	red, tra := redistest.New(t)
+
+	seed(t, tra, 25)
+
+	cur, err := New(Config{Redigo: red, Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pro []string
+	for i := 0; ; i++ {
+		if i > 10 {
+			t.Fatal("expected processing to finish")
+		}
+
+		var chu []string
+		don, err := cur.Process(testKey, false, time.Minute, score, func(s string) error {
+			chu = append(chu, s)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pro = append(pro, chu...)
+
+		var ops []transaction.Operation
+		for _, s := range chu {
+			ops = append(ops, transaction.SortedRemove(testKey, s))
+		}
+
+		err = tra.Execute(ops...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if don {
+			break
+		}
+	}
+
+	var exp []string
+	for i := 1; i <= 25; i++ {
+		exp = append(exp, strconv.Itoa(i))
+	}
+
+	if !reflect.DeepEqual(pro, exp) {
+		t.Fatalf("expected %v, got %v", exp, pro)
+	}
+}
+
+// Test_Cursor_Process_DryRun verifies that dry runs process all chunks at
+// once without persisting any position.
+func Test_Cursor_Process_DryRun(t *testing.T) {
+	red, tra := redistest.New(t)
+
+	seed(t, tra, 25)
+
+	cur, err := New(Config{Redigo: red, Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cou int
+	don, err := cur.Process(testKey, true, time.Minute, score, func(s string) error {
+		cou++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !don {
+		t.Fatal("expected dry run to be done")
+	}
+	if cou != 25 {
+		t.Fatalf("expected 25 elements, got %d", cou)
+	}
+
+	p, err := cur.Search(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p != 0 {
+		t.Fatalf("expected no position, got %f", p)
+	}
+}
@@ -0,0 +1,495 @@
+package fsck
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/key"
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/venturemark/apicommon/pkg/schema"
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/redigo/pkg/simple"
+	"github.com/xh3b4sd/rescue"
+	"github.com/xh3b4sd/rescue/pkg/task"
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/user"
+)
+
+var (
+	// kind are the resource kinds of which role lists and subject
+	// associations are checked.
+	kind = []string{
+		"message",
+		"timeline",
+		"update",
+		"venture",
+	}
+)
+
+type Config struct {
+	Redigo redigo.Interface
+	Rescue rescue.Interface
+
+	Timeout time.Duration
+}
+
+// Checker verifies the referential integrity of the data stored in redis.
+type Checker struct {
+	redigo redigo.Interface
+	rescue rescue.Interface
+
+	timeout time.Duration
+}
+
+func New(config Config) (*Checker, error) {
+	if config.Redigo == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Redigo must not be empty", config)
+	}
+	if config.Rescue == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Rescue must not be empty", config)
+	}
+
+	if config.Timeout == 0 {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Timeout must not be empty", config)
+	}
+
+	c := &Checker{
+		redigo: config.Redigo,
+		rescue: config.Rescue,
+
+		timeout: config.Timeout,
+	}
+
+	return c, nil
+}
+
+func (c *Checker) Check() (*Report, error) {
+	rep := &Report{
+		Checked: time.Now().UTC(),
+		Problem: []*Problem{},
+	}
+
+	{
+		pro, err := c.checkRole()
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		rep.Problem = append(rep.Problem, pro...)
+	}
+
+	{
+		pro, err := c.checkSubject()
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		rep.Problem = append(rep.Problem, pro...)
+	}
+
+	{
+		pro, err := c.checkInvite()
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		rep.Problem = append(rep.Problem, pro...)
+	}
+
+	{
+		pro, err := c.checkSchema()
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		rep.Problem = append(rep.Problem, pro...)
+	}
+
+	return rep, nil
+}
+
+// Repair enqueues delete tasks for all problems of the given report which
+// can be repaired automatically.
+func (c *Checker) Repair(rep *Report) error {
+	for _, p := range rep.Problem {
+		if p.Resource == "" {
+			continue
+		}
+
+		t := &task.Task{
+			Obj: task.TaskObj{
+				Metadata: map[string]string{},
+			},
+		}
+
+		for k, v := range p.Metadata {
+			t.Obj.Metadata[k] = v
+		}
+
+		t.Obj.Metadata[metadata.TaskAction] = "delete"
+		t.Obj.Metadata[metadata.TaskResource] = p.Resource
+
+		err := c.rescue.Create(t)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		rep.Repaired++
+	}
+
+	return nil
+}
+
+func (c *Checker) checkInvite() ([]*Problem, error) {
+	var pro []*Problem
+
+	pat := key.Invite(map[string]string{
+		metadata.VentureID: "*",
+	}).List()
+
+	err := c.walk(pat, func(k string) error {
+		str, err := c.redigo.Sorted().Search().Order(k, 0, -1)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		for _, s := range str {
+			i := &schema.Invite{}
+			err = json.Unmarshal([]byte(s), i)
+			if err != nil {
+				pro = append(pro, &Problem{Kind: KindInvalidJSON, Key: k})
+				continue
+			}
+
+			exi, err := c.existsSimple(key.Venture(i.Obj.Metadata).Elem())
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			if !exi {
+				pro = append(pro, &Problem{Kind: KindDanglingInvite, Key: k, Resource: "invite", Metadata: i.Obj.Metadata})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	return pro, nil
+}
+
+// checkRole verifies that the resource of every role list exists. All roles
+// within a list belong to the same resource, so it is sufficient to check the
+// first role of each list.
+func (c *Checker) checkRole() ([]*Problem, error) {
+	var pro []*Problem
+
+	see := map[string]bool{}
+
+	for _, kin := range kind {
+		pat := key.Role(map[string]string{
+			metadata.ResourceKind: kin,
+			metadata.VentureID:    "*",
+			metadata.TimelineID:   "*",
+			metadata.UpdateID:     "*",
+			metadata.MessageID:    "*",
+		}).List()
+
+		err := c.walk(pat, func(k string) error {
+			if see[k] {
+				return nil
+			}
+			see[k] = true
+
+			str, err := c.redigo.Sorted().Search().Order(k, 0, -1)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			if len(str) == 0 {
+				return nil
+			}
+
+			var rol []*schema.Role
+			for _, s := range str {
+				r := &schema.Role{}
+				err = json.Unmarshal([]byte(s), r)
+				if err != nil {
+					pro = append(pro, &Problem{Kind: KindInvalidJSON, Key: k})
+					continue
+				}
+
+				rol = append(rol, r)
+			}
+
+			if len(rol) == 0 {
+				return nil
+			}
+
+			res := rol[0].Obj.Metadata[metadata.ResourceKind]
+			if res == "" {
+				res = kin
+			}
+
+			exi, err := c.existsResource(res, rol[0].Obj.Metadata)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			if !exi {
+				pro = append(pro, &Problem{Kind: KindDanglingRole, Key: k, Resource: res, Metadata: rol[0].Obj.Metadata})
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+	}
+
+	return pro, nil
+}
+
+// checkSchema verifies that the timelines, updates and messages stored in
+// their lists can be unmarshalled, as well as all users.
+func (c *Checker) checkSchema() ([]*Problem, error) {
+	var pro []*Problem
+
+	var tik string
+	var upk string
+	var mek string
+	{
+		met := map[string]string{
+			metadata.VentureID:  "*",
+			metadata.TimelineID: "*",
+			metadata.UpdateID:   "*",
+		}
+
+		tik = key.Timeline(met).List()
+		upk = key.Update(met).List()
+		mek = key.Message(met).List()
+	}
+
+	lis := map[string]func() interface{}{
+		tik: func() interface{} { return &schema.Timeline{} },
+		upk: func() interface{} { return &schema.Update{} },
+		mek: func() interface{} { return &schema.Message{} },
+	}
+
+	for pat, fun := range lis {
+		err := c.walk(pat, func(k string) error {
+			str, err := c.redigo.Sorted().Search().Order(k, 0, -1)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			for _, s := range str {
+				err = json.Unmarshal([]byte(s), fun())
+				if err != nil {
+					pro = append(pro, &Problem{Kind: KindInvalidJSON, Key: k})
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+	}
+
+	{
+		err := c.walk("use:[0-9]*[0-9][^:]", func(k string) error {
+			val, err := c.redigo.Simple().Search().Value(k)
+			if simple.IsNotFound(err) {
+				return nil
+			} else if err != nil {
+				return tracer.Mask(err)
+			}
+
+			err = json.Unmarshal([]byte(val), &schema.User{})
+			if err != nil {
+				pro = append(pro, &Problem{Kind: KindInvalidJSON, Key: k})
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+	}
+
+	return pro, nil
+}
+
+// checkSubject verifies that the roles referenced by subject associations
+// exist, and that the subjects of these roles are existing users.
+func (c *Checker) checkSubject() ([]*Problem, error) {
+	var pro []*Problem
+
+	see := map[string]bool{}
+
+	for _, kin := range kind {
+		pat := key.Subject(map[string]string{
+			metadata.ResourceKind: kin,
+			metadata.SubjectID:    "*",
+		}).Elem()
+
+		err := c.walk(pat, func(k string) error {
+			if see[k] {
+				return nil
+			}
+			see[k] = true
+
+			str, err := c.redigo.Sorted().Search().Order(k, 0, -1)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			for _, s := range str {
+				i := strings.LastIndex(s, ":")
+				if i == -1 {
+					pro = append(pro, &Problem{Kind: KindDanglingSubject, Key: k})
+					continue
+				}
+
+				roi, err := strconv.ParseFloat(s[i+1:], 64)
+				if err != nil {
+					pro = append(pro, &Problem{Kind: KindDanglingSubject, Key: k})
+					continue
+				}
+
+				val, err := c.redigo.Sorted().Search().Score(s[:i], roi, roi)
+				if err != nil {
+					return tracer.Mask(err)
+				}
+
+				if len(val) == 0 {
+					pro = append(pro, &Problem{Kind: KindDanglingSubject, Key: k})
+					continue
+				}
+
+				r := &schema.Role{}
+				err = json.Unmarshal([]byte(val[0]), r)
+				if err != nil {
+					pro = append(pro, &Problem{Kind: KindInvalidJSON, Key: s[:i]})
+					continue
+				}
+
+				sui := r.Obj.Metadata[metadata.SubjectID]
+
+				exi, err := c.existsSimple(user.Key(sui))
+				if err != nil {
+					return tracer.Mask(err)
+				}
+
+				if !exi {
+					met := map[string]string{
+						metadata.SubjectID: sui,
+						metadata.UserID:    sui,
+					}
+
+					pro = append(pro, &Problem{Kind: KindDanglingSubject, Key: k, Resource: "user", Metadata: met})
+
+					// All elements of a subject association belong to the
+					// same subject, so there is no need to check the rest.
+					break
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+	}
+
+	return pro, nil
+}
+
+func (c *Checker) existsResource(res string, met map[string]string) (bool, error) {
+	switch res {
+	case "venture":
+		return c.existsSimple(key.Venture(met).Elem())
+	case "timeline":
+		k := key.Timeline(met)
+		return c.existsSorted(k.List(), k.ID().F())
+	case "update":
+		k := key.Update(met)
+		return c.existsSorted(k.List(), k.ID().F())
+	case "message":
+		k := key.Message(met)
+		return c.existsSorted(k.List(), k.ID().F())
+	}
+
+	return true, nil
+}
+
+func (c *Checker) existsSimple(k string) (bool, error) {
+	_, err := c.redigo.Simple().Search().Value(k)
+	if simple.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, tracer.Mask(err)
+	}
+
+	return true, nil
+}
+
+func (c *Checker) existsSorted(k string, s float64) (bool, error) {
+	val, err := c.redigo.Sorted().Search().Score(k, s, s)
+	if err != nil {
+		return false, tracer.Mask(err)
+	}
+
+	return len(val) != 0, nil
+}
+
+// walk executes fun for every key matching the given pattern.
+func (c *Checker) walk(pat string, fun func(k string) error) error {
+	var don chan struct{}
+	var erc chan error
+	var res chan string
+	{
+		don = make(chan struct{}, 1)
+		erc = make(chan error, 1)
+		res = make(chan string, 1)
+	}
+
+	go func() {
+		defer close(don)
+
+		for k := range res {
+			err := fun(k)
+			if err != nil {
+				erc <- tracer.Mask(err)
+			}
+		}
+	}()
+
+	go func() {
+		defer close(res)
+
+		err := c.redigo.Walker().Simple(pat, don, res)
+		if err != nil {
+			erc <- tracer.Mask(err)
+		}
+	}()
+
+	{
+		select {
+		case <-don:
+			return nil
+
+		case err := <-erc:
+			return tracer.Mask(err)
+
+		case <-time.After(c.timeout):
+			return tracer.Mask(timeoutError)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package fsck
+
+import "time"
+
+const (
+	// KindDanglingInvite is reported for invites of ventures that do not
+	// exist anymore.
+	KindDanglingInvite = "danglingInvite"
+	// KindDanglingRole is reported for role lists of resources that do not
+	// exist anymore.
+	KindDanglingRole = "danglingRole"
+	// KindDanglingSubject is reported for subject associations of users that
+	// do not exist anymore, or which reference roles that do not exist
+	// anymore.
+	KindDanglingSubject = "danglingSubject"
+	// KindInvalidJSON is reported for values that cannot be unmarshalled
+	// into their schema type.
+	KindInvalidJSON = "invalidJSON"
+)
+
+type Problem struct {
+	Kind string `json:"kind"`
+	Key  string `json:"key"`
+
+	// Resource and Metadata describe the delete task repairing the problem.
+	// Problems which cannot be repaired automatically have no resource.
+	Resource string            `json:"resource,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type Report struct {
+	Checked  time.Time  `json:"checked"`
+	Problem  []*Problem `json:"problem"`
+	Repaired int        `json:"repaired"`
+}
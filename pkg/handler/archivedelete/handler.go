@@ -0,0 +1,88 @@
+package archivedelete
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/rescue/pkg/task"
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/archive"
+)
+
+type HandlerConfig struct {
+	Archive *archive.Archive
+	Logger  logger.Interface
+
+	Timeout time.Duration
+}
+
+type Handler struct {
+	archive *archive.Archive
+	logger  logger.Interface
+
+	timeout time.Duration
+}
+
+func NewHandler(c HandlerConfig) (*Handler, error) {
+	if c.Archive == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Archive must not be empty", c)
+	}
+	if c.Logger == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", c)
+	}
+
+	if c.Timeout == 0 {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Timeout must not be empty", c)
+	}
+
+	h := &Handler{
+		archive: c.Archive,
+		logger:  c.Logger,
+
+		timeout: c.Timeout,
+	}
+
+	return h, nil
+}
+
+func (h *Handler) Ensure(tsk *task.Task) error {
+	var err error
+
+	if !h.archive.Enabled() {
+		return nil
+	}
+
+	h.logger.Log(context.Background(), "level", "info", "message", "deleting expired archives")
+
+	var nam []string
+	{
+		nam, err = h.archive.Expired()
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	for _, n := range nam {
+		err = h.archive.Delete(n)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	h.logger.Log(context.Background(), "level", "info", "message", "deleted expired archives", "count", strconv.Itoa(len(nam)))
+
+	return nil
+}
+
+func (h *Handler) Filter(tsk *task.Task) bool {
+	met := map[string]string{
+		metadata.TaskAction:   "delete",
+		metadata.TaskResource: "archive",
+	}
+
+	return metadata.Contains(tsk.Obj.Metadata, met)
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/xh3b4sd/rescue/pkg/task"
+)
+
+const (
+	// Cascaded is the task metadata key holding the unix timestamp, in
+	// nanoseconds, at which a delete task got enqueued by the deletion of its
+	// parent resource. Restoring the parent resource invalidates all such
+	// tasks enqueued before the restore.
+	Cascaded = "task.venturemark.co/cascaded"
+)
+
+// Cascade marks the given task as enqueued by the deletion of its parent
+// resource at the current time.
+func Cascade(tsk *task.Task) {
+	tsk.Obj.Metadata[Cascaded] = strconv.FormatInt(time.Now().UTC().UnixNano(), 10)
+}
+
+// CascadeTime returns the time at which the given task got enqueued by the
+// deletion of its parent resource. False is returned for tasks which did not
+// get enqueued this way.
+func CascadeTime(tsk *task.Task) (time.Time, bool) {
+	i, err := strconv.ParseInt(tsk.Obj.Metadata[Cascaded], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, i).UTC(), true
+}
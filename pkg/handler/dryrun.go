@@ -0,0 +1,16 @@
+package handler
+
+import (
+	"github.com/xh3b4sd/rescue/pkg/task"
+)
+
+const (
+	// DryRun is the task metadata key used to request a dry run for a single
+	// task. Handlers supporting dry runs log the operations they would perform
+	// instead of mutating any state, when the key is set to "true".
+	DryRun = "task.venturemark.co/dry-run"
+)
+
+func IsDryRun(tsk *task.Task) bool {
+	return tsk.Obj.Metadata[DryRun] == "true"
+}
@@ -0,0 +1,20 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/xh3b4sd/tracer"
+)
+
+// IncompleteExecutionError is returned by handlers which processed only a part
+// of their work, e.g. a single chunk of a large cascade. The controller keeps
+// the task around upon incomplete execution, so that it gets rescheduled and
+// the handler can resume its work eventually.
+var IncompleteExecutionError = &tracer.Error{
+	Kind: "incompleteExecutionError",
+	Desc: "This error indicates that the execution of a task could not be completed successfully. Tasks should be rescheduled after incomplete execution in order to finish them accordingly.",
+}
+
+func IsIncompleteExecution(err error) bool {
+	return errors.Is(err, IncompleteExecutionError)
+}
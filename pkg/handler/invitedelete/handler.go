@@ -2,29 +2,61 @@ package invitedelete
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
 	"github.com/venturemark/apicommon/pkg/key"
 	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/venturemark/apicommon/pkg/schema"
 	"github.com/xh3b4sd/logger"
 	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/redigo/pkg/simple"
 	"github.com/xh3b4sd/rescue"
 	"github.com/xh3b4sd/rescue/pkg/task"
 	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/mailer"
+	"github.com/venturemark/apiworker/pkg/preference"
+	"github.com/venturemark/apiworker/pkg/render"
+	"github.com/venturemark/apiworker/pkg/transaction"
+	"github.com/venturemark/apiworker/pkg/user"
+)
+
+const (
+	// Notify is the task metadata key expressing whether the inviter should
+	// be informed via email that their invite expired, when set to "true".
+	// The inviter is only informed if the invite still exists, so that
+	// deleting the same invite multiple times does not cause multiple
+	// emails.
+	Notify = "task.venturemark.co/notify"
+)
+
+const (
+	// templateName is the name of the template expiry notifications are
+	// rendered with.
+	templateName = "invite-expired"
 )
 
 type HandlerConfig struct {
-	Logger logger.Interface
-	Redigo redigo.Interface
-	Rescue rescue.Interface
+	Logger      logger.Interface
+	Mailer      mailer.Interface
+	Preference  *preference.Store
+	Redigo      redigo.Interface
+	Render      *render.Render
+	Rescue      rescue.Interface
+	Transaction transaction.Interface
 
 	Timeout time.Duration
 }
 
 type Handler struct {
-	logger logger.Interface
-	redigo redigo.Interface
-	rescue rescue.Interface
+	logger      logger.Interface
+	mailer      mailer.Interface
+	preference  *preference.Store
+	redigo      redigo.Interface
+	render      *render.Render
+	rescue      rescue.Interface
+	transaction transaction.Interface
 
 	timeout time.Duration
 }
@@ -33,21 +65,37 @@ func NewHandler(c HandlerConfig) (*Handler, error) {
 	if c.Logger == nil {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", c)
 	}
+	if c.Mailer == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Mailer must not be empty", c)
+	}
+	if c.Preference == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Preference must not be empty", c)
+	}
 	if c.Redigo == nil {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Redigo must not be empty", c)
 	}
+	if c.Render == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Render must not be empty", c)
+	}
 	if c.Rescue == nil {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Rescue must not be empty", c)
 	}
+	if c.Transaction == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Transaction must not be empty", c)
+	}
 
 	if c.Timeout == 0 {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Timeout must not be empty", c)
 	}
 
 	h := &Handler{
-		logger: c.Logger,
-		redigo: c.Redigo,
-		rescue: c.Rescue,
+		logger:      c.Logger,
+		mailer:      c.Mailer,
+		preference:  c.Preference,
+		redigo:      c.Redigo,
+		render:      c.Render,
+		rescue:      c.Rescue,
+		transaction: c.Transaction,
 
 		timeout: c.Timeout,
 	}
@@ -60,6 +108,17 @@ func (h *Handler) Ensure(tsk *task.Task) error {
 
 	h.logger.Log(context.Background(), "level", "info", "message", "deleting invite resource")
 
+	// The inviter is informed before the invite gets deleted. Should the
+	// deletion fail, the task is retried and the inviter might be informed
+	// twice. Informing the inviter afterwards instead would lose the email
+	// for good, since the retry could not find the invite anymore.
+	if tsk.Obj.Metadata[Notify] == "true" {
+		err = h.notifyInviter(tsk)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
 	err = h.deleteInvite(tsk)
 	if err != nil {
 		return tracer.Mask(err)
@@ -91,11 +150,141 @@ func (h *Handler) deleteInvite(tsk *task.Task) error {
 		k := ink.List()
 		s := ink.ID().F()
 
-		err = h.redigo.Sorted().Delete().Score(k, s)
+		err = h.transaction.Execute(transaction.SortedDelete(k, s))
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	return nil
+}
+
+func (h *Handler) notifyInviter(tsk *task.Task) error {
+	var err error
+
+	var inv *schema.Invite
+	{
+		inv, err = h.searchInvite(tsk.Obj.Metadata)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		// The invite got already deleted by an earlier task, which informed
+		// the inviter already.
+		if inv == nil {
+			return nil
+		}
+	}
+
+	var use *schema.User
+	{
+		use, err = user.Search(h.redigo, inv.Obj.Metadata[metadata.UserID])
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		// The inviter might have been deleted in the meantime.
+		if use == nil {
+			return nil
+		}
+	}
+
+	var ven *schema.Venture
+	{
+		ven, err = h.searchVenture(inv.Obj.Metadata)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if ven == nil {
+			return nil
+		}
+	}
+
+	var pre *preference.Preference
+	{
+		pre, err = h.preference.Search(inv.Obj.Metadata[metadata.UserID])
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var data templateInvite
+	var content render.Content
+	{
+		data = templateInvite{
+			Mail:    inv.Obj.Property.Mail,
+			Venture: ven.Obj.Property.Name,
+		}
+
+		content, err = h.render.Execute(templateName, pre.Locale, data)
 		if err != nil {
 			return tracer.Mask(err)
 		}
 	}
 
+	email := mailer.Message{
+		From:    "notifications@venturemark.co",
+		To:      use.Obj.Property.Mail,
+		Subject: content.Subject,
+		HTML:    content.HTML,
+		Text:    content.Text,
+		Data:    data,
+	}
+
+	_, err = h.mailer.Send(email)
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
 	return nil
 }
+
+func (h *Handler) searchInvite(met map[string]string) (*schema.Invite, error) {
+	var ink *key.Key
+	{
+		ink = key.Invite(met)
+	}
+
+	str, err := h.redigo.Sorted().Search().Score(ink.List(), ink.ID().F(), ink.ID().F())
+	if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	if len(str) == 0 {
+		return nil, nil
+	}
+
+	i := &schema.Invite{}
+	err = json.Unmarshal([]byte(str[0]), i)
+	if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	return i, nil
+}
+
+func (h *Handler) searchVenture(met map[string]string) (*schema.Venture, error) {
+	val, err := h.redigo.Simple().Search().Value(key.Venture(met).Elem())
+	if simple.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	v := &schema.Venture{}
+	err = json.Unmarshal([]byte(val), v)
+	if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	return v, nil
+}
+
+// templateInvite is the data expiry notifications are rendered with.
+type templateInvite struct {
+	// Mail is the email address of the invitee.
+	Mail string
+	// Venture is the name of the venture the invitee got invited to.
+	Venture string
+}
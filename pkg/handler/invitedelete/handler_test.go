@@ -0,0 +1,178 @@
+package invitedelete
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/key"
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/venturemark/apicommon/pkg/schema"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/rescue/pkg/engine"
+	"github.com/xh3b4sd/rescue/pkg/metric"
+	"github.com/xh3b4sd/rescue/pkg/task"
+
+	"github.com/venturemark/apiworker/pkg/mailer/capture"
+	"github.com/venturemark/apiworker/pkg/preference"
+	"github.com/venturemark/apiworker/pkg/redistest"
+	"github.com/venturemark/apiworker/pkg/render"
+	"github.com/venturemark/apiworker/pkg/transaction"
+)
+
+// Test_Handler_Ensure_Notify verifies that the inviter of an expired invite
+// is informed exactly once, even if the invite expiry enqueued several
+// deletions for the same invite.
+func Test_Handler_Ensure_Notify(t *testing.T) {
+	red, tra := redistest.New(t)
+
+	var mai *capture.Mailer
+	var han *Handler
+	{
+		log, err := logger.New(logger.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := engine.New(engine.Config{Logger: log, Metric: metric.New(), Redigo: red})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mai, err = capture.NewMailer(capture.MailerConfig{Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pre, err := preference.New(preference.Config{Redigo: red})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = pre.Update("2", &preference.Preference{Frequency: preference.FrequencyDaily, Locale: "de"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ren, err := render.New(render.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := HandlerConfig{
+			Logger:      log,
+			Mailer:      mai,
+			Preference:  pre,
+			Redigo:      red,
+			Render:      ren,
+			Rescue:      res,
+			Transaction: tra,
+
+			Timeout: time.Minute,
+		}
+
+		han, err = NewHandler(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	met := map[string]string{
+		metadata.InviteID:  "1",
+		metadata.UserID:    "2",
+		metadata.VentureID: "3",
+	}
+
+	{
+		u := &schema.User{
+			Obj: schema.UserObj{
+				Metadata: map[string]string{metadata.UserID: "2"},
+				Property: schema.UserObjProperty{Mail: "inviter@example.com"},
+			},
+		}
+
+		v := &schema.Venture{
+			Obj: schema.VentureObj{
+				Metadata: map[string]string{metadata.VentureID: "3"},
+				Property: schema.VentureObjProperty{Name: "Acme"},
+			},
+		}
+
+		i := &schema.Invite{
+			Obj: schema.InviteObj{
+				Metadata: met,
+				Property: schema.InviteObjProperty{Mail: "invitee@example.com"},
+			},
+		}
+
+		var val []string
+		for _, x := range []interface{}{u, v, i} {
+			byt, err := json.Marshal(x)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			val = append(val, string(byt))
+		}
+
+		err := tra.Execute(
+			transaction.SimpleCreate("use:2", val[0]),
+			transaction.SimpleCreate(key.Venture(met).Elem(), val[1]),
+			transaction.SortedCreate(key.Invite(met).List(), val[2], key.Invite(met).ID().F()),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for j := 0; j < 2; j++ {
+		tsk := &task.Task{
+			Obj: task.TaskObj{
+				Metadata: map[string]string{
+					metadata.TaskAction:   "delete",
+					metadata.TaskResource: "invite",
+					Notify:                "true",
+				},
+			},
+		}
+
+		for k, v := range met {
+			tsk.Obj.Metadata[k] = v
+		}
+
+		err := han.Ensure(tsk)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	{
+		str, err := red.Sorted().Search().Order(key.Invite(met).List(), 0, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(str) != 0 {
+			t.Fatalf("expected no invites, got %d", len(str))
+		}
+	}
+
+	{
+		ent, err := mai.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(ent) != 1 {
+			t.Fatalf("expected 1 email, got %d", len(ent))
+		}
+
+		m := ent[0].Message
+		if m.To != "inviter@example.com" {
+			t.Fatalf("expected email to inviter, got %q", m.To)
+		}
+		if m.Subject != "Deine Einladung zu Acme ist abgelaufen" {
+			t.Fatalf("expected German subject, got %q", m.Subject)
+		}
+	}
+}
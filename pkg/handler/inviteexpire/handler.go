@@ -0,0 +1,222 @@
+package inviteexpire
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/key"
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/venturemark/apicommon/pkg/schema"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/rescue"
+	"github.com/xh3b4sd/rescue/pkg/task"
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/handler/invitedelete"
+)
+
+type HandlerConfig struct {
+	Logger logger.Interface
+	Redigo redigo.Interface
+	Rescue rescue.Interface
+
+	// Notify expresses whether the inviter should be informed via email
+	// once their invite expired. The inviter is informed by the invite
+	// deletion, so that invites found expired on several runs before getting
+	// deleted cause a single email only.
+	Notify  bool
+	Timeout time.Duration
+	// TTL is the period of time after which pending invites expire.
+	TTL time.Duration
+}
+
+type Handler struct {
+	logger logger.Interface
+	redigo redigo.Interface
+	rescue rescue.Interface
+
+	notify  bool
+	timeout time.Duration
+	ttl     time.Duration
+}
+
+func NewHandler(c HandlerConfig) (*Handler, error) {
+	if c.Logger == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", c)
+	}
+	if c.Redigo == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Redigo must not be empty", c)
+	}
+	if c.Rescue == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Rescue must not be empty", c)
+	}
+
+	if c.Timeout == 0 {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Timeout must not be empty", c)
+	}
+	if c.TTL == 0 {
+		return nil, tracer.Maskf(invalidConfigError, "%T.TTL must not be empty", c)
+	}
+
+	h := &Handler{
+		logger: c.Logger,
+		redigo: c.Redigo,
+		rescue: c.Rescue,
+
+		notify:  c.Notify,
+		timeout: c.Timeout,
+		ttl:     c.TTL,
+	}
+
+	return h, nil
+}
+
+func (h *Handler) Ensure(tsk *task.Task) error {
+	var err error
+
+	h.logger.Log(context.Background(), "level", "info", "message", "expiring invite resources")
+
+	var inv []*schema.Invite
+	{
+		inv, err = h.searchExpired()
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	for _, i := range inv {
+		err = h.deleteInvite(i)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	h.logger.Log(context.Background(), "level", "info", "message", "expired invite resources", "count", strconv.Itoa(len(inv)))
+
+	return nil
+}
+
+func (h *Handler) Filter(tsk *task.Task) bool {
+	met := map[string]string{
+		metadata.TaskAction:   "expire",
+		metadata.TaskResource: "invite",
+	}
+
+	return metadata.Contains(tsk.Obj.Metadata, met)
+}
+
+func (h *Handler) deleteInvite(inv *schema.Invite) error {
+	t := &task.Task{
+		Obj: task.TaskObj{
+			Metadata: map[string]string{},
+		},
+	}
+
+	for k, v := range inv.Obj.Metadata {
+		t.Obj.Metadata[k] = v
+	}
+
+	t.Obj.Metadata[metadata.TaskAction] = "delete"
+	t.Obj.Metadata[metadata.TaskResource] = "invite"
+
+	if h.notify {
+		t.Obj.Metadata[invitedelete.Notify] = "true"
+	}
+
+	err := h.rescue.Create(t)
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	return nil
+}
+
+func (h *Handler) searchExpired() ([]*schema.Invite, error) {
+	var err error
+
+	var don chan struct{}
+	var erc chan error
+	var res chan string
+	{
+		don = make(chan struct{}, 1)
+		erc = make(chan error, 1)
+		res = make(chan string, 1)
+	}
+
+	var inv []*schema.Invite
+	var lis error
+
+	// Errors of the consumer are tracked separately, so that the consumer
+	// keeps draining the results of the walker until it is done.
+	go func() {
+		defer close(don)
+
+		for k := range res {
+			if lis != nil {
+				continue
+			}
+
+			str, err := h.redigo.Sorted().Search().Order(k, 0, -1)
+			if err != nil {
+				lis = tracer.Mask(err)
+				continue
+			}
+
+			for _, s := range str {
+				i := &schema.Invite{}
+				err = json.Unmarshal([]byte(s), i)
+				if err != nil {
+					lis = tracer.Mask(err)
+					break
+				}
+
+				if expired(i.Obj.Metadata[metadata.InviteID], h.ttl) {
+					inv = append(inv, i)
+				}
+			}
+		}
+	}()
+
+	go func() {
+		defer close(res)
+
+		k := key.Invite(map[string]string{metadata.VentureID: "*"}).List()
+
+		err = h.redigo.Walker().Simple(k, don, res)
+		if err != nil {
+			erc <- tracer.Mask(err)
+		}
+	}()
+
+	{
+		select {
+		case <-don:
+			if lis != nil {
+				return nil, tracer.Mask(lis)
+			}
+
+			return inv, nil
+
+		case err := <-erc:
+			return nil, tracer.Mask(err)
+
+		case <-time.After(h.timeout):
+			return nil, tracer.Mask(timeoutError)
+		}
+	}
+}
+
+// expired expresses whether the given ID, which is a unix nano timestamp, is
+// older than the given TTL. IDs which cannot be parsed are never considered
+// expired.
+func expired(iid string, ttl time.Duration) bool {
+	i, err := strconv.ParseInt(iid, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	return time.Now().After(time.Unix(i/1e9, 0).Add(ttl))
+}
@@ -11,25 +11,38 @@ import (
 	"github.com/xh3b4sd/rescue"
 	"github.com/xh3b4sd/rescue/pkg/task"
 	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/archive"
+	"github.com/venturemark/apiworker/pkg/tombstone"
+	"github.com/venturemark/apiworker/pkg/transaction"
 )
 
 type HandlerConfig struct {
-	Logger logger.Interface
-	Redigo redigo.Interface
-	Rescue rescue.Interface
+	Archive     *archive.Archive
+	Logger      logger.Interface
+	Redigo      redigo.Interface
+	Rescue      rescue.Interface
+	Tombstone   *tombstone.Tombstone
+	Transaction transaction.Interface
 
 	Timeout time.Duration
 }
 
 type Handler struct {
-	logger logger.Interface
-	redigo redigo.Interface
-	rescue rescue.Interface
+	archive     *archive.Archive
+	logger      logger.Interface
+	redigo      redigo.Interface
+	rescue      rescue.Interface
+	tombstone   *tombstone.Tombstone
+	transaction transaction.Interface
 
 	timeout time.Duration
 }
 
 func NewHandler(c HandlerConfig) (*Handler, error) {
+	if c.Archive == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Archive must not be empty", c)
+	}
 	if c.Logger == nil {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", c)
 	}
@@ -39,15 +52,24 @@ func NewHandler(c HandlerConfig) (*Handler, error) {
 	if c.Rescue == nil {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Rescue must not be empty", c)
 	}
+	if c.Tombstone == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Tombstone must not be empty", c)
+	}
+	if c.Transaction == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Transaction must not be empty", c)
+	}
 
 	if c.Timeout == 0 {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Timeout must not be empty", c)
 	}
 
 	h := &Handler{
-		logger: c.Logger,
-		redigo: c.Redigo,
-		rescue: c.Rescue,
+		archive:     c.Archive,
+		logger:      c.Logger,
+		redigo:      c.Redigo,
+		rescue:      c.Rescue,
+		tombstone:   c.Tombstone,
+		transaction: c.Transaction,
 
 		timeout: c.Timeout,
 	}
@@ -60,6 +82,20 @@ func (h *Handler) Ensure(tsk *task.Task) error {
 
 	h.logger.Log(context.Background(), "level", "info", "message", "deleting message resource")
 
+	// Tasks enqueued by the deletion of a parent resource are dropped once
+	// the parent got restored, since they would delete restored data again.
+	{
+		res, err := h.tombstone.IsRestored(tsk)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if res {
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping deletion of restored message resource", "message", tsk.Obj.Metadata[metadata.MessageID])
+			return nil
+		}
+	}
+
 	err = h.deleteElement(tsk)
 	if err != nil {
 		return tracer.Mask(err)
@@ -89,7 +125,38 @@ func (h *Handler) deleteElement(tsk *task.Task) error {
 		k := mek.List()
 		s := mek.ID().F()
 
-		err := h.redigo.Sorted().Delete().Score(k, s)
+		// The message is watched, so that the tombstone and the archive never
+		// hold a version older than the one being deleted.
+		err := h.transaction.Watch([]string{k}, func() ([]transaction.Operation, error) {
+			var ops []transaction.Operation
+
+			if h.archive.Enabled() || h.tombstone.Enabled() {
+				e, err := h.tombstone.Sorted(k, s)
+				if err != nil {
+					return nil, tracer.Mask(err)
+				}
+
+				if h.archive.Enabled() {
+					err = h.archive.Create(tsk.Obj.Metadata, e)
+					if err != nil {
+						return nil, tracer.Mask(err)
+					}
+				}
+
+				if h.tombstone.Enabled() {
+					o, err := h.tombstone.Bury(tsk.Obj.Metadata, e)
+					if err != nil {
+						return nil, tracer.Mask(err)
+					}
+
+					ops = append(ops, o...)
+				}
+			}
+
+			ops = append(ops, transaction.SortedDelete(k, s))
+
+			return ops, nil
+		})
 		if err != nil {
 			return tracer.Mask(err)
 		}
@@ -0,0 +1,229 @@
+package messagedelete
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/key"
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/venturemark/apicommon/pkg/schema"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/rescue/pkg/engine"
+	"github.com/xh3b4sd/rescue/pkg/metric"
+	"github.com/xh3b4sd/rescue/pkg/task"
+
+	"github.com/venturemark/apiworker/pkg/archive"
+	"github.com/venturemark/apiworker/pkg/blob/local"
+	"github.com/venturemark/apiworker/pkg/redistest"
+	"github.com/venturemark/apiworker/pkg/tombstone"
+	"github.com/venturemark/apiworker/pkg/transaction"
+)
+
+var (
+	testMetadata = map[string]string{
+		metadata.MessageID:  "1",
+		metadata.TimelineID: "1",
+		metadata.UpdateID:   "1",
+		metadata.VentureID:  "1",
+	}
+)
+
+// Test_Handler_Ensure_Fail verifies that a message deletion failing midway
+// neither deletes the message nor buries it, and that retrying it afterwards
+// does both.
+func Test_Handler_Ensure_Fail(t *testing.T) {
+	red, tra := redistest.New(t)
+
+	fau := &redistest.Fault{Fail: true, Transaction: tra}
+
+	han, tom := testHandler(t, red, tra, fau)
+
+	seed(t, tra, "foo")
+
+	{
+		err := han.Ensure(testTask())
+		if err == nil {
+			t.Fatal("expected error")
+		}
+
+		exi, err := red.Sorted().Exists().Score(key.Message(testMetadata).List(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !exi {
+			t.Fatal("expected message to be kept")
+		}
+
+		ent, err := tom.Search(testMetadata)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(ent) != 0 {
+			t.Fatalf("expected no tombstone got %d", len(ent))
+		}
+	}
+
+	{
+		fau.Fail = false
+
+		err := han.Ensure(testTask())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exi, err := red.Sorted().Exists().Score(key.Message(testMetadata).List(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if exi {
+			t.Fatal("expected message to be deleted")
+		}
+
+		ent, err := tom.Search(testMetadata)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(ent) != 1 {
+			t.Fatalf("expected 1 tombstone got %d", len(ent))
+		}
+	}
+}
+
+// Test_Handler_Ensure_Race verifies that a message edited while it gets
+// deleted is buried in its edited version.
+func Test_Handler_Ensure_Race(t *testing.T) {
+	red, tra := redistest.New(t)
+
+	fau := &redistest.Fault{Transaction: tra}
+
+	han, tom := testHandler(t, red, tra, fau)
+
+	seed(t, tra, "foo")
+
+	fau.Race = func() {
+		seed(t, tra, "bar")
+	}
+
+	err := han.Ensure(testTask())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ent, err := tom.Search(testMetadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ent) != 1 || len(ent[0].Element) != 1 {
+		t.Fatalf("expected 1 tombstone with 1 element got %#v", ent)
+	}
+
+	m := &schema.Message{}
+	err = json.Unmarshal([]byte(ent[0].Element[0].Value), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Obj.Property.Text != "bar" {
+		t.Fatalf("expected %q got %q", "bar", m.Obj.Property.Text)
+	}
+}
+
+// seed writes the test message with the given text, replacing any former
+// version of it.
+func seed(t *testing.T, tra transaction.Interface, txt string) {
+	t.Helper()
+
+	m := &schema.Message{}
+	m.Obj.Metadata = map[string]string{}
+	m.Obj.Property.Text = txt
+
+	for k, v := range testMetadata {
+		m.Obj.Metadata[k] = v
+	}
+
+	byt, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := key.Message(testMetadata).List()
+
+	err = tra.Execute(
+		transaction.SortedDelete(k, 1),
+		transaction.SortedCreate(k, string(byt), 1),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testTask() *task.Task {
+	tsk := &task.Task{
+		Obj: task.TaskObj{
+			Metadata: map[string]string{
+				metadata.TaskAction:   "delete",
+				metadata.TaskResource: "message",
+			},
+		},
+	}
+
+	for k, v := range testMetadata {
+		tsk.Obj.Metadata[k] = v
+	}
+
+	return tsk
+}
+
+// testHandler returns a message delete handler keeping tombstones, which
+// executes its transactions via the given fault.
+func testHandler(t *testing.T, red redigo.Interface, tra transaction.Interface, fau *redistest.Fault) (*Handler, *tombstone.Tombstone) {
+	log, err := logger.New(logger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := engine.New(engine.Config{Logger: log, Metric: metric.New(), Redigo: red})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blo, err := local.NewBlob(local.BlobConfig{Directory: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	arc, err := archive.New(archive.Config{Blob: blo, Transaction: tra})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tom, err := tombstone.New(tombstone.Config{Redigo: red, Transaction: tra, Grace: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := HandlerConfig{
+		Archive:     arc,
+		Logger:      log,
+		Redigo:      red,
+		Rescue:      res,
+		Tombstone:   tom,
+		Transaction: fau,
+
+		Timeout: time.Minute,
+	}
+
+	han, err := NewHandler(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return han, tom
+}
@@ -0,0 +1,18 @@
+package handler
+
+import "fmt"
+
+const (
+	// MovePrefix is the key prefix under which timelines being moved between
+	// ventures are marked. While a timeline is being moved, some of its
+	// updates, messages and roles already live in the target venture, while
+	// the timeline itself still lives in the source venture. Handlers looking
+	// for orphans must skip timelines marked this way.
+	MovePrefix = "apiworker.venturemark.co:mov"
+)
+
+// MoveKey returns the simple key marking the timeline with the given ID as
+// being moved.
+func MoveKey(tii string) string {
+	return fmt.Sprintf("%s:%s", MovePrefix, tii)
+}
@@ -0,0 +1,589 @@
+package orphandelete
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/key"
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/venturemark/apicommon/pkg/schema"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/redigo/pkg/simple"
+	"github.com/xh3b4sd/rescue"
+	"github.com/xh3b4sd/rescue/pkg/task"
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/handler"
+	"github.com/venturemark/apiworker/pkg/readmarker"
+	"github.com/venturemark/apiworker/pkg/transaction"
+	"github.com/venturemark/apiworker/pkg/user"
+)
+
+var (
+	// kind are the resource kinds of which role lists are checked for
+	// orphans.
+	kind = []string{
+		"message",
+		"timeline",
+		"update",
+		"venture",
+	}
+)
+
+type HandlerConfig struct {
+	Logger      logger.Interface
+	Redigo      redigo.Interface
+	Rescue      rescue.Interface
+	Transaction transaction.Interface
+
+	DryRun  bool
+	Timeout time.Duration
+}
+
+type Handler struct {
+	logger      logger.Interface
+	redigo      redigo.Interface
+	rescue      rescue.Interface
+	transaction transaction.Interface
+
+	dryRun  bool
+	timeout time.Duration
+}
+
+func NewHandler(c HandlerConfig) (*Handler, error) {
+	if c.Logger == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", c)
+	}
+	if c.Redigo == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Redigo must not be empty", c)
+	}
+	if c.Rescue == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Rescue must not be empty", c)
+	}
+	if c.Transaction == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Transaction must not be empty", c)
+	}
+
+	if c.Timeout == 0 {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Timeout must not be empty", c)
+	}
+
+	h := &Handler{
+		logger:      c.Logger,
+		redigo:      c.Redigo,
+		rescue:      c.Rescue,
+		transaction: c.Transaction,
+
+		dryRun:  c.DryRun,
+		timeout: c.Timeout,
+	}
+
+	return h, nil
+}
+
+// Ensure walks the keyspace along the venture, timeline, update and message
+// hierarchy and enqueues delete tasks for all resources of which the parent
+// resource does not exist anymore. Deleting orphans cascades down the
+// hierarchy, so that orphaned children of orphans get cleaned up eventually.
+// Read markers of which the user or the venture does not exist anymore are
+// deleted right away, since there is nothing to cascade.
+func (h *Handler) Ensure(tsk *task.Task) error {
+	var err error
+
+	h.logger.Log(context.Background(), "level", "info", "message", "deleting orphan resources")
+
+	rep := &report{
+		Created: time.Now().UTC(),
+		DryRun:  h.isDryRun(tsk),
+	}
+
+	{
+		rep.Timeline, err = h.deleteTimeline(tsk)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	{
+		rep.Update, err = h.deleteUpdate(tsk)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	{
+		rep.Message, err = h.deleteMessage(tsk)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	{
+		rep.Role, err = h.deleteRole(tsk)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	{
+		rep.ReadMarker, err = h.deleteReadMarker(tsk)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	{
+		byt, err := json.Marshal(rep)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		err = h.redigo.Simple().Create().Element(Key, string(byt))
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	h.logger.Log(
+		context.Background(),
+		"level", "info",
+		"message", "deleted orphan resources",
+		"timeline", strconv.Itoa(rep.Timeline),
+		"update", strconv.Itoa(rep.Update),
+		"message", strconv.Itoa(rep.Message),
+		"role", strconv.Itoa(rep.Role),
+		"readMarker", strconv.Itoa(rep.ReadMarker),
+	)
+
+	return nil
+}
+
+func (h *Handler) Filter(tsk *task.Task) bool {
+	met := map[string]string{
+		metadata.TaskAction:   "delete",
+		metadata.TaskResource: "orphan",
+	}
+
+	return metadata.Contains(tsk.Obj.Metadata, met)
+}
+
+func (h *Handler) createTask(tsk *task.Task, res string, met map[string]string) error {
+	t := &task.Task{
+		Obj: task.TaskObj{
+			Metadata: map[string]string{},
+		},
+	}
+
+	for k, v := range met {
+		t.Obj.Metadata[k] = v
+	}
+
+	t.Obj.Metadata[metadata.TaskAction] = "delete"
+	t.Obj.Metadata[metadata.TaskResource] = res
+
+	if h.isDryRun(tsk) {
+		h.logger.Log(context.Background(), "level", "info", "message", "skipping task creation in dry run", "resource", res)
+		return nil
+	}
+
+	err := h.rescue.Create(t)
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	return nil
+}
+
+func (h *Handler) deleteMessage(tsk *task.Task) (int, error) {
+	var cou int
+
+	pat := key.Message(map[string]string{
+		metadata.VentureID:  "*",
+		metadata.TimelineID: "*",
+		metadata.UpdateID:   "*",
+	}).List()
+
+	err := h.walk(pat, func(k string) error {
+		str, err := h.redigo.Sorted().Search().Order(k, 0, -1)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		for _, s := range str {
+			m := &schema.Message{}
+			err = json.Unmarshal([]byte(s), m)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			mov, err := h.moving(m.Obj.Metadata)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			if mov {
+				continue
+			}
+
+			upk := key.Update(m.Obj.Metadata)
+
+			exi, err := h.existsSorted(upk.List(), upk.ID().F())
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			if exi {
+				continue
+			}
+
+			err = h.createTask(tsk, "message", m.Obj.Metadata)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			cou++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, tracer.Mask(err)
+	}
+
+	return cou, nil
+}
+
+// deleteReadMarker deletes the read markers of users and ventures that do not
+// exist anymore. Markers of former memberships are not reachable from either
+// side, so this walk is the only place cleaning them up.
+func (h *Handler) deleteReadMarker(tsk *task.Task) (int, error) {
+	var cou int
+
+	err := h.walk(readmarker.Key("*", "*"), func(k string) error {
+		uid, vei, ok := readmarker.Split(k)
+		if !ok {
+			return nil
+		}
+
+		usk := user.Key(uid)
+		vek := key.Venture(map[string]string{metadata.VentureID: vei}).Elem()
+
+		// The user and the venture are watched, so that markers are never
+		// deleted for a user or venture created concurrently.
+		var del bool
+		err := h.transaction.Watch([]string{usk, vek}, func() ([]transaction.Operation, error) {
+			del = false
+
+			use, err := h.existsSimple(usk)
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			ven, err := h.existsSimple(vek)
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			if use && ven {
+				return nil, nil
+			}
+
+			del = true
+
+			if h.isDryRun(tsk) {
+				h.logger.Log(context.Background(), "level", "info", "message", "skipping key deletion in dry run", "key", k)
+				return nil, nil
+			}
+
+			return []transaction.Operation{transaction.Delete(k)}, nil
+		})
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if del {
+			cou++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, tracer.Mask(err)
+	}
+
+	return cou, nil
+}
+
+// deleteRole checks the role lists of all resource kinds. All roles within a
+// list belong to the same resource, so it is sufficient to check the first
+// role of each list.
+func (h *Handler) deleteRole(tsk *task.Task) (int, error) {
+	var cou int
+
+	for _, kin := range kind {
+		pat := key.Role(map[string]string{
+			metadata.ResourceKind: kin,
+			metadata.VentureID:    "*",
+			metadata.TimelineID:   "*",
+			metadata.UpdateID:     "*",
+			metadata.MessageID:    "*",
+		}).List()
+
+		err := h.walk(pat, func(k string) error {
+			str, err := h.redigo.Sorted().Search().Order(k, 0, 0)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			if len(str) == 0 {
+				return nil
+			}
+
+			r := &schema.Role{}
+			err = json.Unmarshal([]byte(str[0]), r)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			mov, err := h.moving(r.Obj.Metadata)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			if mov {
+				return nil
+			}
+
+			var exi bool
+			switch kin {
+			case "venture":
+				exi, err = h.existsSimple(key.Venture(r.Obj.Metadata).Elem())
+			case "timeline":
+				tik := key.Timeline(r.Obj.Metadata)
+				exi, err = h.existsSorted(tik.List(), tik.ID().F())
+			case "update":
+				upk := key.Update(r.Obj.Metadata)
+				exi, err = h.existsSorted(upk.List(), upk.ID().F())
+			case "message":
+				mek := key.Message(r.Obj.Metadata)
+				exi, err = h.existsSorted(mek.List(), mek.ID().F())
+			}
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			if exi {
+				return nil
+			}
+
+			// Deleting the resource the role list belongs to causes
+			// roledelete to clean up the role list.
+			err = h.createTask(tsk, kin, r.Obj.Metadata)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			cou++
+
+			return nil
+		})
+		if err != nil {
+			return 0, tracer.Mask(err)
+		}
+	}
+
+	return cou, nil
+}
+
+func (h *Handler) deleteTimeline(tsk *task.Task) (int, error) {
+	var cou int
+
+	pat := key.Timeline(map[string]string{
+		metadata.VentureID: "*",
+	}).List()
+
+	err := h.walk(pat, func(k string) error {
+		str, err := h.redigo.Sorted().Search().Order(k, 0, -1)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		for _, s := range str {
+			t := &schema.Timeline{}
+			err = json.Unmarshal([]byte(s), t)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			exi, err := h.existsSimple(key.Venture(t.Obj.Metadata).Elem())
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			if exi {
+				continue
+			}
+
+			err = h.createTask(tsk, "timeline", t.Obj.Metadata)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			cou++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, tracer.Mask(err)
+	}
+
+	return cou, nil
+}
+
+func (h *Handler) deleteUpdate(tsk *task.Task) (int, error) {
+	var cou int
+
+	pat := key.Update(map[string]string{
+		metadata.VentureID:  "*",
+		metadata.TimelineID: "*",
+	}).List()
+
+	err := h.walk(pat, func(k string) error {
+		str, err := h.redigo.Sorted().Search().Order(k, 0, -1)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		for _, s := range str {
+			u := &schema.Update{}
+			err = json.Unmarshal([]byte(s), u)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			mov, err := h.moving(u.Obj.Metadata)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			if mov {
+				continue
+			}
+
+			tik := key.Timeline(u.Obj.Metadata)
+
+			exi, err := h.existsSorted(tik.List(), tik.ID().F())
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			if exi {
+				continue
+			}
+
+			err = h.createTask(tsk, "update", u.Obj.Metadata)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			cou++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, tracer.Mask(err)
+	}
+
+	return cou, nil
+}
+
+func (h *Handler) existsSimple(k string) (bool, error) {
+	_, err := h.redigo.Simple().Search().Value(k)
+	if simple.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, tracer.Mask(err)
+	}
+
+	return true, nil
+}
+
+func (h *Handler) existsSorted(k string, s float64) (bool, error) {
+	val, err := h.redigo.Sorted().Search().Score(k, s, s)
+	if err != nil {
+		return false, tracer.Mask(err)
+	}
+
+	return len(val) != 0, nil
+}
+
+// moving returns whether the timeline of the resource described by the given
+// metadata is being moved between ventures. Resources of such timelines are
+// not considered orphans, because their parents may not have been moved yet.
+func (h *Handler) moving(met map[string]string) (bool, error) {
+	tii := met[metadata.TimelineID]
+	if tii == "" {
+		return false, nil
+	}
+
+	exi, err := h.existsSimple(handler.MoveKey(tii))
+	if err != nil {
+		return false, tracer.Mask(err)
+	}
+
+	return exi, nil
+}
+
+func (h *Handler) isDryRun(tsk *task.Task) bool {
+	return h.dryRun || handler.IsDryRun(tsk)
+}
+
+// walk executes fun for every key matching the given pattern.
+func (h *Handler) walk(pat string, fun func(k string) error) error {
+	var don chan struct{}
+	var erc chan error
+	var res chan string
+	{
+		don = make(chan struct{}, 1)
+		erc = make(chan error, 1)
+		res = make(chan string, 1)
+	}
+
+	go func() {
+		defer close(don)
+
+		for k := range res {
+			err := fun(k)
+			if err != nil {
+				erc <- tracer.Mask(err)
+			}
+		}
+	}()
+
+	go func() {
+		defer close(res)
+
+		err := h.redigo.Walker().Simple(pat, don, res)
+		if err != nil {
+			erc <- tracer.Mask(err)
+		}
+	}()
+
+	{
+		select {
+		case <-don:
+			return nil
+
+		case err := <-erc:
+			return tracer.Mask(err)
+
+		case <-time.After(h.timeout):
+			return tracer.Mask(timeoutError)
+		}
+	}
+}
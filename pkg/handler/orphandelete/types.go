@@ -0,0 +1,20 @@
+package orphandelete
+
+import "time"
+
+const (
+	// Key is the simple key holding the report of the latest garbage
+	// collection run.
+	Key = "apiworker.venturemark.co:orp"
+)
+
+type report struct {
+	Created time.Time `json:"created"`
+	DryRun  bool      `json:"dryRun"`
+
+	Message    int `json:"message"`
+	ReadMarker int `json:"readMarker"`
+	Role       int `json:"role"`
+	Timeline   int `json:"timeline"`
+	Update     int `json:"update"`
+}
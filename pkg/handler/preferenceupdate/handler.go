@@ -0,0 +1,102 @@
+package preferenceupdate
+
+import (
+	"context"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/rescue/pkg/task"
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/preference"
+)
+
+type HandlerConfig struct {
+	Logger     logger.Interface
+	Preference *preference.Store
+
+	Timeout time.Duration
+}
+
+type Handler struct {
+	logger     logger.Interface
+	preference *preference.Store
+
+	timeout time.Duration
+}
+
+func NewHandler(c HandlerConfig) (*Handler, error) {
+	if c.Logger == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", c)
+	}
+	if c.Preference == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Preference must not be empty", c)
+	}
+
+	if c.Timeout == 0 {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Timeout must not be empty", c)
+	}
+
+	h := &Handler{
+		logger:     c.Logger,
+		preference: c.Preference,
+
+		timeout: c.Timeout,
+	}
+
+	return h, nil
+}
+
+// Ensure updates the notification preferences of a user. Only the preferences
+// given by the task metadata are changed, see the preference package for the
+// supported metadata keys. Tasks carrying invalid preferences are dropped,
+// since executing them again would not make them any more valid.
+func (h *Handler) Ensure(tsk *task.Task) error {
+	var err error
+
+	var uid string
+	{
+		uid = tsk.Obj.Metadata[metadata.UserID]
+	}
+
+	h.logger.Log(context.Background(), "level", "info", "message", "updating preference resource", "user", uid)
+
+	var pre *preference.Preference
+	{
+		pre, err = h.preference.Search(uid)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	{
+		err = pre.Apply(tsk.Obj.Metadata)
+		if preference.IsInvalidPreference(err) {
+			h.logger.Log(context.Background(), "level", "warning", "message", "skipping preference update", "reason", err.Error(), "user", uid)
+			return nil
+		} else if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	{
+		err = h.preference.Update(uid, pre)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	h.logger.Log(context.Background(), "level", "info", "message", "updated preference resource", "user", uid)
+
+	return nil
+}
+
+func (h *Handler) Filter(tsk *task.Task) bool {
+	met := map[string]string{
+		metadata.TaskAction:   "update",
+		metadata.TaskResource: "preference",
+	}
+
+	return metadata.Contains(tsk.Obj.Metadata, met)
+}
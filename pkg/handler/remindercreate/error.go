@@ -21,11 +21,3 @@ var timeoutError = &tracer.Error{
 func IsTimeout(err error) bool {
 	return errors.Is(err, timeoutError)
 }
-
-var mailDeliveryError = &tracer.Error{
-	Kind: "mailDeliveryError",
-}
-
-func IsMailDelivery(err error) bool {
-	return errors.Is(err, mailDeliveryError)
-}
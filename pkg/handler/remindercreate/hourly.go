@@ -0,0 +1,194 @@
+package remindercreate
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/rescue"
+	"github.com/xh3b4sd/rescue/pkg/task"
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/handler"
+	"github.com/venturemark/apiworker/pkg/preference"
+)
+
+type HourlyConfig struct {
+	Logger     logger.Interface
+	Preference *preference.Store
+	Redigo     redigo.Interface
+	Rescue     rescue.Interface
+
+	// Timeout is the time given to walk all users. It should be
+	// considerably larger than the timeout of ordinary handlers.
+	Timeout time.Duration
+}
+
+type Hourly struct {
+	logger     logger.Interface
+	preference *preference.Store
+	redigo     redigo.Interface
+	rescue     rescue.Interface
+
+	timeout time.Duration
+}
+
+func NewHourly(c HourlyConfig) (*Hourly, error) {
+	if c.Logger == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", c)
+	}
+	if c.Preference == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Preference must not be empty", c)
+	}
+	if c.Redigo == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Redigo must not be empty", c)
+	}
+	if c.Rescue == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Rescue must not be empty", c)
+	}
+
+	if c.Timeout == 0 {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Timeout must not be empty", c)
+	}
+
+	h := &Hourly{
+		logger:     c.Logger,
+		preference: c.Preference,
+		redigo:     c.Redigo,
+		rescue:     c.Rescue,
+
+		timeout: c.Timeout,
+	}
+
+	return h, nil
+}
+
+func (h *Hourly) Ensure(tsk *task.Task) error {
+	var err error
+
+	// Reminders used to be fanned out once a day by tasks labelled weekly,
+	// which do not carry a schedule. Such tasks may still be queued at
+	// rollout. They are dropped, since the hourly fan-out covers the same
+	// users and executing them would send reminders twice.
+	if !handler.IsScheduled(tsk) {
+		h.logger.Log(context.Background(), "level", "warning", "message", "dropping legacy reminder task", "interval", tsk.Obj.Metadata[metadata.TaskInterval])
+		return nil
+	}
+
+	h.logger.Log(context.Background(), "level", "info", "message", "creating hourly reminder")
+
+	err = h.createReminder(tsk)
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	h.logger.Log(context.Background(), "level", "info", "message", "created hourly reminder")
+
+	return nil
+}
+
+func (h *Hourly) Filter(tsk *task.Task) bool {
+	met := map[string]string{
+		metadata.TaskAction:   "create",
+		metadata.TaskResource: "reminder",
+	}
+
+	// Reminders used to be fanned out once a day by tasks labelled weekly.
+	// Such tasks may still be queued and are accepted in order to be dropped
+	// by Ensure.
+	if tsk.Obj.Metadata[metadata.TaskInterval] != "hourly" && tsk.Obj.Metadata[metadata.TaskInterval] != "weekly" {
+		return false
+	}
+
+	return metadata.Contains(tsk.Obj.Metadata, met)
+}
+
+// createReminder creates a reminder task for every user whose notification
+// preferences say that a reminder is due at the time the given task got
+// scheduled for.
+func (h *Hourly) createReminder(tsk *task.Task) error {
+	var err error
+
+	var sch time.Time
+	{
+		sch = handler.Schedule(tsk)
+	}
+
+	var don chan struct{}
+	var erc chan error
+	var res chan string
+	{
+		don = make(chan struct{}, 1)
+		erc = make(chan error, 1)
+		res = make(chan string, 1)
+	}
+
+	go func() {
+		defer close(don)
+
+		for k := range res {
+			var uid string
+			{
+				uid = strings.TrimPrefix(k, "use:")
+			}
+
+			{
+				p, err := h.preference.Search(uid)
+				if err != nil {
+					erc <- tracer.Mask(err)
+					continue
+				}
+
+				if !p.Due(sch) {
+					continue
+				}
+			}
+
+			t := &task.Task{
+				Obj: task.TaskObj{
+					Metadata: map[string]string{
+						metadata.TaskAction:   "create",
+						metadata.TaskAudience: "user",
+						metadata.TaskResource: "reminder",
+
+						"user.venturemark.co/id": uid,
+
+						handler.Scheduled: tsk.Obj.Metadata[handler.Scheduled],
+					},
+				},
+			}
+
+			err := h.rescue.Create(t)
+			if err != nil {
+				erc <- tracer.Mask(err)
+			}
+		}
+	}()
+
+	go func() {
+		defer close(res)
+
+		k := "use:[0-9]*[0-9][^:]"
+
+		err = h.redigo.Walker().Simple(k, don, res)
+		if err != nil {
+			erc <- tracer.Mask(err)
+		}
+	}()
+
+	{
+		select {
+		case <-don:
+			return nil
+
+		case err := <-erc:
+			return tracer.Mask(err)
+
+		case <-time.After(h.timeout):
+			return tracer.Mask(timeoutError)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package remindercreate
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/rescue/pkg/task"
+)
+
+func Test_Hourly_Filter(t *testing.T) {
+	testCases := []struct {
+		met map[string]string
+		fil bool
+	}{
+		// Case 0 ensures that hourly reminder tasks are accepted.
+		{
+			met: map[string]string{
+				metadata.TaskAction:   "create",
+				metadata.TaskInterval: "hourly",
+				metadata.TaskResource: "reminder",
+			},
+			fil: true,
+		},
+		// Case 1 ensures that legacy reminder tasks labelled weekly are
+		// still accepted, so that Ensure can drop them.
+		{
+			met: map[string]string{
+				metadata.TaskAction:   "create",
+				metadata.TaskInterval: "weekly",
+				metadata.TaskResource: "reminder",
+			},
+			fil: true,
+		},
+		// Case 2 ensures that reminder tasks of other intervals are not
+		// accepted.
+		{
+			met: map[string]string{
+				metadata.TaskAction:   "create",
+				metadata.TaskInterval: "daily",
+				metadata.TaskResource: "reminder",
+			},
+			fil: false,
+		},
+		// Case 3 ensures that reminder tasks of single users are not
+		// accepted.
+		{
+			met: map[string]string{
+				metadata.TaskAction:   "create",
+				metadata.TaskAudience: "user",
+				metadata.TaskResource: "reminder",
+			},
+			fil: false,
+		},
+		// Case 4 ensures that other tasks are not accepted.
+		{
+			met: map[string]string{
+				metadata.TaskAction:   "delete",
+				metadata.TaskInterval: "hourly",
+				metadata.TaskResource: "reminder",
+			},
+			fil: false,
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			h := &Hourly{}
+
+			tsk := &task.Task{
+				Obj: task.TaskObj{
+					Metadata: tc.met,
+				},
+			}
+
+			fil := h.Filter(tsk)
+			if fil != tc.fil {
+				t.Fatalf("expected %t, got %t", tc.fil, fil)
+			}
+		})
+	}
+}
+
+// Test_Hourly_Ensure_Legacy ensures that legacy reminder tasks are dropped
+// without walking any users. The handler is set up without redis and rescue,
+// so that touching either would panic.
+func Test_Hourly_Ensure_Legacy(t *testing.T) {
+	testCases := []struct {
+		met map[string]string
+	}{
+		// Case 0 ensures that legacy tasks labelled weekly are dropped.
+		{
+			met: map[string]string{
+				metadata.TaskAction:   "create",
+				metadata.TaskInterval: "weekly",
+				metadata.TaskResource: "reminder",
+			},
+		},
+		// Case 1 ensures that hourly tasks without schedule are dropped.
+		{
+			met: map[string]string{
+				metadata.TaskAction:   "create",
+				metadata.TaskInterval: "hourly",
+				metadata.TaskResource: "reminder",
+			},
+		},
+	}
+
+	log, err := logger.New(logger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			h := &Hourly{
+				logger: log,
+			}
+
+			tsk := &task.Task{
+				Obj: task.TaskObj{
+					Metadata: tc.met,
+				},
+			}
+
+			err := h.Ensure(tsk)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// Test_User_Ensure_Legacy ensures that user reminder tasks created by the
+// legacy daily fan-out are dropped without sending any reminder. The handler
+// is set up without any dependency, so that touching one would panic.
+func Test_User_Ensure_Legacy(t *testing.T) {
+	log, err := logger.New(logger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &User{
+		logger: log,
+	}
+
+	tsk := &task.Task{
+		Obj: task.TaskObj{
+			Metadata: map[string]string{
+				metadata.TaskAction:   "create",
+				metadata.TaskAudience: "user",
+				metadata.TaskResource: "reminder",
+				metadata.UserID:       "1",
+			},
+		},
+	}
+
+	err = u.Ensure(tsk)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,5 +1,10 @@
 package remindercreate
 
+import (
+	"html/template"
+	"time"
+)
+
 type templateVenture struct {
 	Name string `json:"name,omitempty"`
 	Path string `json:"path,omitempty"`
@@ -10,13 +15,60 @@ type templateTimeline struct {
 	Path string `json:"path,omitempty"`
 }
 
+// templateReminder is the data the reminder templates are rendered with.
+type templateReminder struct {
+	BaseURL string
+	Count   int
+	// Start and End describe the period of time the reminder covers.
+	Start time.Time
+	End   time.Time
+	// More lists the number of updates per venture which got left out of
+	// the reminder in order to keep it short.
+	More []*templateMore
+	// Unsubscribe is the link for opting out of reminders, if enabled.
+	Unsubscribe string
+	Updates     []*templateUpdate
+}
+
+type templateMore struct {
+	Count   int
+	Venture templateVenture
+}
+
 type templateUpdate struct {
-	IDNumeric    int64              `json:"-"`
-	Title        string             `json:"title,omitempty"`
-	Body         string             `json:"body,omitempty"`
-	AuthorName   string             `json:"authorName,omitempty"`
-	RelativeTime string             `json:"relativeTime,omitempty"`
-	Path         string             `json:"path,omitempty"`
-	Venture      templateVenture    `json:"venture"`
-	Timelines    []templateTimeline `json:"timelines,omitempty"`
+	IDNumeric int64 `json:"-"`
+	// Title and Body are the HTML representations of the update, as rendered
+	// from its slate or plain content. TitleText and BodyText are the plain
+	// text representations used in the text part of the email. AuthorName
+	// is empty for updates of deleted users.
+	Title      template.HTML      `json:"title,omitempty"`
+	TitleText  string             `json:"titleText,omitempty"`
+	Body       template.HTML      `json:"body,omitempty"`
+	BodyText   string             `json:"bodyText,omitempty"`
+	AuthorName string             `json:"authorName,omitempty"`
+	Created    time.Time          `json:"created"`
+	Path       string             `json:"path,omitempty"`
+	Venture    templateVenture    `json:"venture"`
+	VentureID  string             `json:"-"`
+	Timelines  []templateTimeline `json:"timelines,omitempty"`
+}
+
+// window is the period of time a reminder covers. Updates are covered if they
+// got created at or after Start and before End, so that the windows of
+// consecutive reminders do not overlap.
+type window struct {
+	Start time.Time
+	End   time.Time
+}
+
+func newWindow(end time.Time, per time.Duration) window {
+	return window{
+		Start: end.Add(-per),
+		End:   end,
+	}
+}
+
+// contains returns whether the given time lies within the window.
+func (w window) contains(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
 }
@@ -0,0 +1,94 @@
+package remindercreate
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/venturemark/apiworker/pkg/preference"
+)
+
+func Test_window_contains(t *testing.T) {
+	end := time.Date(2021, time.March, 1, 13, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		pre *preference.Preference
+		tim time.Time
+		con bool
+	}{
+		// Case 0 ensures that the start of a daily window is covered.
+		{
+			pre: &preference.Preference{Frequency: preference.FrequencyDaily},
+			tim: end.Add(-24 * time.Hour),
+			con: true,
+		},
+		// Case 1 ensures that the end of a daily window is not covered, so
+		// that it is covered by the next window only.
+		{
+			pre: &preference.Preference{Frequency: preference.FrequencyDaily},
+			tim: end,
+			con: false,
+		},
+		// Case 2 ensures that times just before the end of a daily window
+		// are covered.
+		{
+			pre: &preference.Preference{Frequency: preference.FrequencyDaily},
+			tim: end.Add(-time.Nanosecond),
+			con: true,
+		},
+		// Case 3 ensures that times just before the start of a daily window
+		// are not covered.
+		{
+			pre: &preference.Preference{Frequency: preference.FrequencyDaily},
+			tim: end.Add(-24*time.Hour - time.Nanosecond),
+			con: false,
+		},
+		// Case 4 ensures that weekly windows cover the whole week.
+		{
+			pre: &preference.Preference{Frequency: preference.FrequencyWeekly},
+			tim: end.Add(-7 * 24 * time.Hour),
+			con: true,
+		},
+		// Case 5 ensures that weekly windows do not cover more than a week.
+		{
+			pre: &preference.Preference{Frequency: preference.FrequencyWeekly},
+			tim: end.Add(-7*24*time.Hour - time.Nanosecond),
+			con: false,
+		},
+		// Case 6 ensures that custom windows take precedence over the
+		// frequency.
+		{
+			pre: &preference.Preference{Frequency: preference.FrequencyDaily, Window: 72 * time.Hour},
+			tim: end.Add(-48 * time.Hour),
+			con: true,
+		},
+		// Case 7 ensures that custom windows do not cover more than the
+		// custom period.
+		{
+			pre: &preference.Preference{Frequency: preference.FrequencyWeekly, Window: 72 * time.Hour},
+			tim: end.Add(-72*time.Hour - time.Nanosecond),
+			con: false,
+		},
+		// Case 8 ensures that times after the end are not covered.
+		{
+			pre: &preference.Preference{Frequency: preference.FrequencyDaily},
+			tim: end.Add(time.Hour),
+			con: false,
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			w := newWindow(end, tc.pre.Period())
+
+			if !w.End.Equal(end) {
+				t.Fatalf("expected window to end at %s, got %s", end, w.End)
+			}
+
+			con := w.contains(tc.tim)
+			if con != tc.con {
+				t.Fatalf("expected %t, got %t", tc.con, con)
+			}
+		})
+	}
+}
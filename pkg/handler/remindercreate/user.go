@@ -5,13 +5,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/keighl/postmark"
-	"github.com/nleeper/goment"
 	"github.com/venturemark/apicommon/pkg/key"
 	"github.com/venturemark/apicommon/pkg/metadata"
 	"github.com/venturemark/apicommon/pkg/schema"
@@ -24,57 +23,101 @@ import (
 	"github.com/xh3b4sd/rescue"
 	"github.com/xh3b4sd/rescue/pkg/task"
 	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/handler"
+	"github.com/venturemark/apiworker/pkg/mailer"
+	"github.com/venturemark/apiworker/pkg/preference"
+	"github.com/venturemark/apiworker/pkg/readmarker"
+	"github.com/venturemark/apiworker/pkg/render"
+	"github.com/venturemark/apiworker/pkg/unsubscribe"
+	"github.com/venturemark/apiworker/pkg/user"
 )
 
-type UserConfig struct {
-	Logger logger.Interface
-	Redigo redigo.Interface
-	Rescue rescue.Interface
+const (
+	// baseURL is the URL the relative update paths of the reminder emails
+	// are linked to.
+	baseURL = "https://venturemark.co"
+	// templateName is the name of the template the reminder emails are
+	// rendered with.
+	templateName = "daily-update"
+)
 
-	PostmarkTokenAccount string
-	PostmarkTokenServer  string
-	Timeout              time.Duration
+type UserConfig struct {
+	Logger     logger.Interface
+	Mailer     mailer.Interface
+	Preference *preference.Store
+	ReadMarker *readmarker.Store
+	Redigo     redigo.Interface
+	Render     *render.Render
+	Rescue     rescue.Interface
+	// Unsubscribe is used to add unsubscribe links to reminders, if enabled.
+	Unsubscribe *unsubscribe.Unsubscribe
+
+	// Limit is the maximum number of updates per venture shown in a single
+	// reminder. Further updates are only summarised by their number.
+	Limit   int
+	Timeout time.Duration
 }
 
 type User struct {
-	logger logger.Interface
-	redigo redigo.Interface
-	rescue rescue.Interface
-
-	postmarkTokenAccount string
-	postmarkTokenServer  string
-	timeout              time.Duration
+	logger     logger.Interface
+	mailer     mailer.Interface
+	preference *preference.Store
+	readMarker *readmarker.Store
+	redigo     redigo.Interface
+	render     *render.Render
+	rescue     rescue.Interface
+	unsub      *unsubscribe.Unsubscribe
+
+	limit   int
+	timeout time.Duration
 }
 
 func NewUser(c UserConfig) (*User, error) {
 	if c.Logger == nil {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", c)
 	}
+	if c.Mailer == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Mailer must not be empty", c)
+	}
+	if c.Preference == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Preference must not be empty", c)
+	}
+	if c.ReadMarker == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.ReadMarker must not be empty", c)
+	}
 	if c.Redigo == nil {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Redigo must not be empty", c)
 	}
+	if c.Render == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Render must not be empty", c)
+	}
 	if c.Rescue == nil {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Rescue must not be empty", c)
 	}
-
-	if c.PostmarkTokenAccount == "" {
-		return nil, tracer.Maskf(invalidConfigError, "%T.PostmarkTokenAccount must not be empty", c)
+	if c.Unsubscribe == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Unsubscribe must not be empty", c)
 	}
-	if c.PostmarkTokenServer == "" {
-		return nil, tracer.Maskf(invalidConfigError, "%T.PostmarkTokenServer must not be empty", c)
+
+	if c.Limit <= 0 {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Limit must be greater than 0", c)
 	}
 	if c.Timeout == 0 {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Timeout must not be empty", c)
 	}
 
 	u := &User{
-		logger: c.Logger,
-		redigo: c.Redigo,
-		rescue: c.Rescue,
+		logger:     c.Logger,
+		mailer:     c.Mailer,
+		preference: c.Preference,
+		readMarker: c.ReadMarker,
+		redigo:     c.Redigo,
+		render:     c.Render,
+		rescue:     c.Rescue,
+		unsub:      c.Unsubscribe,
 
-		postmarkTokenAccount: c.PostmarkTokenAccount,
-		postmarkTokenServer:  c.PostmarkTokenServer,
-		timeout:              c.Timeout,
+		limit:   c.Limit,
+		timeout: c.Timeout,
 	}
 
 	return u, nil
@@ -88,6 +131,14 @@ func (u *User) Ensure(tsk *task.Task) error {
 		uid = tsk.Obj.Metadata[metadata.UserID]
 	}
 
+	// User reminders created by the legacy daily fan-out do not carry a
+	// schedule. Their window cannot be told and the hourly fan-out creates
+	// the reminder of the user again, so they are dropped.
+	if !handler.IsScheduled(tsk) {
+		u.logger.Log(context.Background(), "level", "warning", "message", "dropping legacy reminder task", "user", uid)
+		return nil
+	}
+
 	u.logger.Log(context.Background(), "level", "info", "message", "creating user reminder", "user", uid)
 
 	err = u.createReminder(tsk)
@@ -110,13 +161,27 @@ func (u *User) Filter(tsk *task.Task) bool {
 	return metadata.Contains(tsk.Obj.Metadata, met)
 }
 
-func (u *User) calculateUserUpdates(tsk *task.Task) ([]*templateUpdate, error) {
+// calculateUserUpdates returns the updates the reminder of the user consists
+// of, as well as the number of updates per venture which got left out due to
+// the limit of updates per venture. Updates authored by the user themselves
+// are never included. Updates the user viewed already are left out if the
+// user prefers so.
+func (u *User) calculateUserUpdates(tsk *task.Task, pre *preference.Preference, win window) ([]*templateUpdate, []*templateMore, error) {
+	userID := tsk.Obj.Metadata[metadata.UserID]
+
 	var ventures []*schema.Venture
 	{
-		var err error
-		ventures, err = u.searchVentures(tsk)
+		all, err := u.searchVentures(tsk)
 		if err != nil {
-			return nil, tracer.Mask(err)
+			return nil, nil, tracer.Mask(err)
+		}
+
+		for _, v := range all {
+			if pre.Muted(v.Obj.Metadata[metadata.VentureID]) {
+				continue
+			}
+
+			ventures = append(ventures, v)
 		}
 	}
 
@@ -126,12 +191,26 @@ func (u *User) calculateUserUpdates(tsk *task.Task) ([]*templateUpdate, error) {
 	for _, currentVenture := range ventures {
 		ventureTimelines, err := u.searchTimelines(currentVenture)
 		if err != nil {
-			return nil, tracer.Mask(err)
+			return nil, nil, tracer.Mask(err)
 		}
 
 		timelines = append(timelines, ventureTimelines...)
 	}
 
+	readMarkers := map[string]int64{}
+	if pre.Unread {
+		for _, currentVenture := range ventures {
+			ventureID := currentVenture.Obj.Metadata[metadata.VentureID]
+
+			readMarker, err := u.readMarker.Search(userID, ventureID)
+			if err != nil {
+				return nil, nil, tracer.Mask(err)
+			}
+
+			readMarkers[ventureID] = readMarker
+		}
+	}
+
 	ventureUpdates := map[string]map[int64]*templateUpdate{}
 	var templateUpdates []*templateUpdate
 
@@ -157,27 +236,35 @@ func (u *User) calculateUserUpdates(tsk *task.Task) ([]*templateUpdate, error) {
 		}
 
 		if timelineVenture.Name == "" {
-			return nil, tracer.Mask(errors.New("venture not found"))
+			return nil, nil, tracer.Mask(errors.New("venture not found"))
 		}
 
 		timelinePath := fmt.Sprintf("%s/%s", timelineVenture.Path, timelineSlug)
 
 		timelineUpdates, err := u.searchUpdates(currentTimeline)
 		if err != nil {
-			return nil, tracer.Mask(err)
+			return nil, nil, tracer.Mask(err)
 		}
 
 		for _, currentUpdate := range timelineUpdates {
 			updateID := currentUpdate.Obj.Metadata[metadata.UpdateID]
-			dur := 24 * time.Hour
 
-			if !within(updateID, dur) {
+			updateIDNumeric, err := strconv.ParseInt(updateID, 10, 64)
+			if err != nil {
+				return nil, nil, tracer.Mask(err)
+			}
+
+			if !win.contains(time.Unix(0, updateIDNumeric)) {
 				continue
 			}
 
-			updateIDNumeric, err := strconv.ParseInt(updateID, 10, 64)
-			if err != nil {
-				return nil, tracer.Mask(err)
+			authorID := currentUpdate.Obj.Metadata[metadata.UserID]
+			if authorID == userID {
+				continue
+			}
+
+			if updateIDNumeric <= readMarkers[ventureID] {
+				continue
 			}
 			updateIDRounded := updateIDNumeric / 1e9 // truncate from nanoseconds to seconds
 
@@ -191,33 +278,42 @@ func (u *User) calculateUserUpdates(tsk *task.Task) ([]*templateUpdate, error) {
 				continue // Already counted this update for this venture.
 			}
 
-			updateTime, err := goment.Unix(updateIDRounded)
+			title, body, err := formatUpdateContent(currentUpdate)
 			if err != nil {
-				return nil, tracer.Mask(err)
+				return nil, nil, tracer.Mask(err)
 			}
 
-			title, body, err := formatUpdateContent(currentUpdate)
+			bodyText, err := formatUpdateText(currentUpdate)
 			if err != nil {
-				return nil, tracer.Mask(err)
+				return nil, nil, tracer.Mask(err)
 			}
 
-			authorID := currentUpdate.Obj.Metadata[metadata.UserID]
-			if _, ok := users[authorID]; !ok {
-				users[authorID], err = u.searchUser(authorID)
+			if _, ok := users[authorID]; !ok && authorID != "" {
+				users[authorID], err = user.Search(u.redigo, authorID)
 				if err != nil {
-					return nil, tracer.Mask(err)
+					return nil, nil, tracer.Mask(err)
 				}
 			}
-			authorName := users[authorID].Obj.Property.Name
+			// Updates of deleted users may either be anonymised or still
+			// reference the user ID of the deleted user. Their author name
+			// is left empty, so that the templates render a localised
+			// placeholder.
+			var authorName string
+			if users[authorID] != nil {
+				authorName = users[authorID].Obj.Property.Name
+			}
 
 			ventureUpdates[ventureID][updateIDRounded] = &templateUpdate{
-				IDNumeric:    updateIDNumeric,
-				Title:        title,
-				Body:         body,
-				AuthorName:   authorName,
-				RelativeTime: updateTime.FromNow(),
-				Path:         timelineVenture.Path,
-				Venture:      timelineVenture,
+				IDNumeric:  updateIDNumeric,
+				Title:      template.HTML(title),
+				TitleText:  currentUpdate.Obj.Property.Head,
+				Body:       template.HTML(body),
+				BodyText:   bodyText,
+				AuthorName: authorName,
+				Created:    time.Unix(0, updateIDNumeric).UTC(),
+				Path:       timelineVenture.Path,
+				Venture:    timelineVenture,
+				VentureID:  ventureID,
 				Timelines: []templateTimeline{
 					{
 						Name: timelineName,
@@ -233,7 +329,33 @@ func (u *User) calculateUserUpdates(tsk *task.Task) ([]*templateUpdate, error) {
 		return templateUpdates[i].IDNumeric > templateUpdates[j].IDNumeric
 	})
 
-	return templateUpdates, nil
+	// Only the most recent updates of every venture are shown. All others
+	// are summarised per venture.
+	var limitedUpdates []*templateUpdate
+	var templateMores []*templateMore
+	{
+		shown := map[string]int{}
+		mores := map[string]*templateMore{}
+
+		for _, currentUpdate := range templateUpdates {
+			if shown[currentUpdate.VentureID] < u.limit {
+				shown[currentUpdate.VentureID]++
+				limitedUpdates = append(limitedUpdates, currentUpdate)
+				continue
+			}
+
+			if _, ok := mores[currentUpdate.VentureID]; !ok {
+				mores[currentUpdate.VentureID] = &templateMore{
+					Venture: currentUpdate.Venture,
+				}
+				templateMores = append(templateMores, mores[currentUpdate.VentureID])
+			}
+
+			mores[currentUpdate.VentureID].Count++
+		}
+	}
+
+	return limitedUpdates, templateMores, nil
 }
 
 var slateStyles = map[string]string{
@@ -270,6 +392,21 @@ func formatUpdateContent(upd *schema.Update) (string, string, error) {
 	return formatUpdateContentPlain(title, body)
 }
 
+// formatUpdateText returns the plain text representation of the update body,
+// which is used for the text part of reminder emails.
+func formatUpdateText(upd *schema.Update) (string, error) {
+	if upd.Obj.Metadata[metadata.UpdateFormat] == "slate" {
+		txt, err := render.Slate(upd.Obj.Property.Text)
+		if err != nil {
+			return "", tracer.Mask(err)
+		}
+
+		return txt, nil
+	}
+
+	return strings.TrimSpace(upd.Obj.Property.Text), nil
+}
+
 func formatUpdateContentPlain(title string, body string) (string, string, error) {
 	titleNode := slate.Node{
 		Children: slate.Nodes{
@@ -298,45 +435,93 @@ func (u *User) createReminder(tsk *task.Task) error {
 	var userEmail string
 	userID := tsk.Obj.Metadata[metadata.UserID]
 	{
-		user, err := u.searchUser(userID)
+		use, err := user.Search(u.redigo, userID)
 		if err != nil {
 			return tracer.Mask(err)
 		}
-		userEmail = user.Obj.Property.Mail
+		// The user might have been deleted after the reminder task got
+		// created.
+		if use == nil {
+			return nil
+		}
+		userEmail = use.Obj.Property.Mail
+	}
+
+	var pre *preference.Preference
+	{
+		var err error
+		pre, err = u.preference.Search(userID)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		// The user might have opted out after the reminder task got created.
+		if pre.Frequency == preference.FrequencyOff {
+			return nil
+		}
 	}
 
-	templateUpdates, err := u.calculateUserUpdates(tsk)
+	// The window ends at the time the reminder got scheduled for, instead of
+	// the time the task happens to be executed at, so that consecutive
+	// reminders cover adjacent periods of time.
+	var win window
+	{
+		win = newWindow(handler.Schedule(tsk), pre.Period())
+	}
+
+	templateUpdates, templateMores, err := u.calculateUserUpdates(tsk, pre, win)
 	if err != nil {
 		return tracer.Mask(err)
 	}
 
-	// In case there have not been any updates posted within the past week,
-	// we do not intend to send reminders.
+	// In case there have not been any updates posted within the covered
+	// period, we do not intend to send reminders.
 	if len(templateUpdates) == 0 {
 		return nil
 	}
 
-	client := postmark.NewClient(u.postmarkTokenServer, u.postmarkTokenAccount)
-	templateEmail := postmark.TemplatedEmail{
-		TemplateAlias: "daily-update-notification-slate",
-		TemplateModel: map[string]interface{}{
-			// Mustache templates aren't powerful enough to choose "is" or "are" depending on the count in an array. Instead, we
-			// pass the string value in as a template variable.
-			"singular": len(templateUpdates) == 1,
-			"count":    len(templateUpdates),
-			"updates":  templateUpdates,
-		},
+	var header map[string]string
+	var link string
+	if u.unsub.Enabled() {
+		header = u.unsub.Header(userID, unsubscribe.KindReminder)
+		link = u.unsub.Link(userID, unsubscribe.KindReminder)
+	}
+
+	var data templateReminder
+	var content render.Content
+	{
+		data = templateReminder{
+			BaseURL:     baseURL,
+			Count:       len(templateUpdates) + more(templateMores),
+			Start:       win.Start,
+			End:         win.End,
+			More:        templateMores,
+			Unsubscribe: link,
+			Updates:     templateUpdates,
+		}
+
+		content, err = u.render.Execute(templateName, pre.Locale, data)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	templateEmail := mailer.Message{
 		From:       "notifications@venturemark.co",
+		Header:     header,
 		To:         userEmail,
+		Subject:    content.Subject,
+		HTML:       content.HTML,
+		Text:       content.Text,
+		Data:       data,
 		TrackOpens: true,
 	}
 
-	response, err := client.SendTemplatedEmail(templateEmail)
+	// Inactive recipients are suppressed by the mailer and not considered an
+	// error, so the result is of no interest here.
+	_, err = u.mailer.Send(templateEmail)
 	if err != nil {
-		return err
-	} else if response.Message != "OK" && response.ErrorCode != 406 {
-		// Error if not OK except on "Inactive recipient" response
-		return tracer.Maskf(mailDeliveryError, response.Message)
+		return tracer.Mask(err)
 	}
 
 	return nil
@@ -593,25 +778,6 @@ func (u *User) searchVen(req *venture.SearchI) ([]string, error) {
 	return str, nil
 }
 
-func (u *User) searchUser(uid string) (*schema.User, error) {
-	val, err := u.redigo.Simple().Search().Value(fmt.Sprintf("use:%s", uid))
-	if err != nil {
-		return nil, tracer.Mask(err)
-	}
-
-	if len(val) == 0 {
-		return nil, nil
-	}
-
-	r := schema.User{}
-	err = json.Unmarshal([]byte(val), &r)
-	if err != nil {
-		return nil, tracer.Mask(err)
-	}
-
-	return &r, nil
-}
-
 func split(s string) (string, float64) {
 	var err error
 
@@ -633,19 +799,13 @@ func split(s string) (string, float64) {
 	return rei, roi
 }
 
-func within(uid string, dur time.Duration) bool {
-	{
-		i, err := strconv.ParseInt(uid, 10, 64)
-		if err != nil {
-			panic(err)
-		}
-
-		uni := time.Unix(i/1e9, 0).Add(dur)
+// more returns the number of updates the given summaries account for.
+func more(mor []*templateMore) int {
+	var c int
 
-		if time.Now().After(uni) {
-			return false
-		}
+	for _, m := range mor {
+		c += m.Count
 	}
 
-	return true
+	return c
 }
@@ -2,15 +2,22 @@ package roledelete
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
 	"github.com/venturemark/apicommon/pkg/key"
 	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/venturemark/apicommon/pkg/schema"
 	"github.com/xh3b4sd/logger"
 	"github.com/xh3b4sd/redigo"
 	"github.com/xh3b4sd/rescue"
 	"github.com/xh3b4sd/rescue/pkg/task"
 	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/handler"
+	"github.com/venturemark/apiworker/pkg/tombstone"
+	"github.com/venturemark/apiworker/pkg/transaction"
+	"github.com/venturemark/apiworker/pkg/user"
 )
 
 var (
@@ -22,21 +29,36 @@ var (
 		"user",
 		"venture",
 	}
+	// restorable are the resources of which the roles are kept in tombstones
+	// upon deletion, so that they can be restored together with the resource
+	// they belong to.
+	restorable = []string{
+		"message",
+		"timeline",
+		"update",
+		"venture",
+	}
 )
 
 type HandlerConfig struct {
-	Logger logger.Interface
-	Redigo redigo.Interface
-	Rescue rescue.Interface
+	Logger      logger.Interface
+	Redigo      redigo.Interface
+	Rescue      rescue.Interface
+	Tombstone   *tombstone.Tombstone
+	Transaction transaction.Interface
 
+	DryRun  bool
 	Timeout time.Duration
 }
 
 type Handler struct {
-	logger logger.Interface
-	redigo redigo.Interface
-	rescue rescue.Interface
+	logger      logger.Interface
+	redigo      redigo.Interface
+	rescue      rescue.Interface
+	tombstone   *tombstone.Tombstone
+	transaction transaction.Interface
 
+	dryRun  bool
 	timeout time.Duration
 }
 
@@ -50,16 +72,25 @@ func NewHandler(c HandlerConfig) (*Handler, error) {
 	if c.Rescue == nil {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Rescue must not be empty", c)
 	}
+	if c.Tombstone == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Tombstone must not be empty", c)
+	}
+	if c.Transaction == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Transaction must not be empty", c)
+	}
 
 	if c.Timeout == 0 {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Timeout must not be empty", c)
 	}
 
 	h := &Handler{
-		logger: c.Logger,
-		redigo: c.Redigo,
-		rescue: c.Rescue,
+		logger:      c.Logger,
+		redigo:      c.Redigo,
+		rescue:      c.Rescue,
+		tombstone:   c.Tombstone,
+		transaction: c.Transaction,
 
+		dryRun:  c.DryRun,
 		timeout: c.Timeout,
 	}
 
@@ -71,6 +102,35 @@ func (h *Handler) Ensure(tsk *task.Task) error {
 
 	h.logger.Log(context.Background(), "level", "info", "message", "deleting role resource")
 
+	// Tasks enqueued by the deletion of a parent resource are dropped once
+	// the parent got restored, since they would delete restored data again.
+	{
+		res, err := h.tombstone.IsRestored(tsk)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if res {
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping role deletion of restored resource", "resource", tsk.Obj.Metadata[metadata.TaskResource])
+			return nil
+		}
+	}
+
+	// Roles of deleted users are only removed once userdelete erased the
+	// content of the user and deleted the user, since the erasure looks up
+	// the memberships of the user via its roles.
+	if tsk.Obj.Metadata[metadata.TaskResource] == "user" && !h.isDryRun(tsk) {
+		usr, err := user.Search(h.redigo, tsk.Obj.Metadata[metadata.UserID])
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if usr != nil {
+			h.logger.Log(context.Background(), "level", "info", "message", "postponing role deletion until user content got erased", "user", tsk.Obj.Metadata[metadata.UserID])
+			return tracer.Mask(handler.IncompleteExecutionError)
+		}
+	}
+
 	err = h.deleteRole(tsk)
 	if err != nil {
 		return tracer.Mask(err)
@@ -107,7 +167,29 @@ func (h *Handler) deleteRole(tsk *task.Task) error {
 	{
 		k := rok.List()
 
-		err = h.redigo.Sorted().Delete().Clean(k)
+		if h.isDryRun(tsk) {
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping key deletion in dry run", "key", k)
+			return nil
+		}
+
+		// The roles are watched, so that roles created while the tombstone
+		// gets assembled are not deleted without being kept in it.
+		err = h.transaction.Watch([]string{k}, func() ([]transaction.Operation, error) {
+			var ops []transaction.Operation
+
+			if h.tombstone.Enabled() && h.isRestorable(tsk) {
+				o, err := h.createTombstone(tsk, k)
+				if err != nil {
+					return nil, tracer.Mask(err)
+				}
+
+				ops = append(ops, o...)
+			}
+
+			ops = append(ops, transaction.Delete(k))
+
+			return ops, nil
+		})
 		if err != nil {
 			return tracer.Mask(err)
 		}
@@ -115,3 +197,49 @@ func (h *Handler) deleteRole(tsk *task.Task) error {
 
 	return nil
 }
+
+func (h *Handler) createTombstone(tsk *task.Task, k string) ([]transaction.Operation, error) {
+	str, err := h.redigo.Sorted().Search().Order(k, 0, -1)
+	if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	var ele []*tombstone.Element
+	for _, s := range str {
+		r := &schema.Role{}
+		err = json.Unmarshal([]byte(s), r)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		e := &tombstone.Element{
+			Key:   k,
+			Kind:  tombstone.KindSorted,
+			Score: key.Role(r.Obj.Metadata).ID().F(),
+			Value: s,
+		}
+
+		ele = append(ele, e)
+	}
+
+	ops, err := h.tombstone.Bury(tsk.Obj.Metadata, ele...)
+	if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	return ops, nil
+}
+
+func (h *Handler) isDryRun(tsk *task.Task) bool {
+	return h.dryRun || handler.IsDryRun(tsk)
+}
+
+func (h *Handler) isRestorable(tsk *task.Task) bool {
+	for _, r := range restorable {
+		if tsk.Obj.Metadata[metadata.TaskResource] == r {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/xh3b4sd/rescue/pkg/task"
+)
+
+const (
+	// Scheduled is the task metadata key holding the unix timestamp, in
+	// seconds, of the time a scheduled task was created for. Tasks may be
+	// executed a while after they got created, so handlers which depend on
+	// the time they are executed at should rely on Schedule instead.
+	Scheduled = "task.venturemark.co/scheduled"
+)
+
+// Schedule returns the time the given task got scheduled for. The current
+// time is returned for tasks which are not scheduled or carry an invalid
+// timestamp.
+func Schedule(tsk *task.Task) time.Time {
+	i, err := strconv.ParseInt(tsk.Obj.Metadata[Scheduled], 10, 64)
+	if err != nil {
+		return time.Now().UTC()
+	}
+
+	return time.Unix(i, 0).UTC()
+}
+
+// IsScheduled returns whether the given task carries a valid schedule. Tasks
+// created before scheduling got introduced do not, and handlers for which the
+// schedule matters should drop them, instead of falling back to the current
+// time.
+func IsScheduled(tsk *task.Task) bool {
+	_, err := strconv.ParseInt(tsk.Obj.Metadata[Scheduled], 10, 64)
+	return err == nil
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/xh3b4sd/rescue/pkg/task"
+)
+
+func Test_Schedule(t *testing.T) {
+	testCases := []struct {
+		met map[string]string
+		sch time.Time
+	}{
+		// Case 0 ensures that scheduled tasks return the time they got
+		// scheduled for.
+		{
+			met: map[string]string{
+				Scheduled: "1614603600",
+			},
+			sch: time.Date(2021, time.March, 1, 13, 0, 0, 0, time.UTC),
+		},
+		// Case 1 ensures that legacy tasks without schedule return the
+		// current time.
+		{
+			met: map[string]string{},
+		},
+		// Case 2 ensures that tasks with an invalid schedule return the
+		// current time.
+		{
+			met: map[string]string{
+				Scheduled: "foo",
+			},
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			tsk := &task.Task{
+				Obj: task.TaskObj{
+					Metadata: tc.met,
+				},
+			}
+
+			bef := time.Now().UTC()
+			sch := Schedule(tsk)
+			aft := time.Now().UTC()
+
+			if sch.Location() != time.UTC {
+				t.Fatalf("expected UTC, got %s", sch.Location())
+			}
+
+			if tc.sch.IsZero() {
+				if sch.Before(bef.Truncate(time.Second)) || sch.After(aft) {
+					t.Fatalf("expected current time, got %s", sch)
+				}
+			} else if !sch.Equal(tc.sch) {
+				t.Fatalf("expected %s, got %s", tc.sch, sch)
+			}
+		})
+	}
+}
+
+func Test_IsScheduled(t *testing.T) {
+	testCases := []struct {
+		met map[string]string
+		sch bool
+	}{
+		// Case 0 ensures that scheduled tasks are detected.
+		{
+			met: map[string]string{
+				Scheduled: "1614603600",
+			},
+			sch: true,
+		},
+		// Case 1 ensures that legacy tasks without schedule are detected.
+		{
+			met: map[string]string{},
+			sch: false,
+		},
+		// Case 2 ensures that tasks with an invalid schedule are not
+		// considered scheduled.
+		{
+			met: map[string]string{
+				Scheduled: "foo",
+			},
+			sch: false,
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			tsk := &task.Task{
+				Obj: task.TaskObj{
+					Metadata: tc.met,
+				},
+			}
+
+			sch := IsScheduled(tsk)
+			if sch != tc.sch {
+				t.Fatalf("expected %t, got %t", tc.sch, sch)
+			}
+		})
+	}
+}
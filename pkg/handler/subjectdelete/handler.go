@@ -5,27 +5,51 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/venturemark/apicommon/pkg/key"
 	"github.com/venturemark/apicommon/pkg/metadata"
 	"github.com/xh3b4sd/logger"
 	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/redigo/pkg/simple"
 	"github.com/xh3b4sd/rescue"
 	"github.com/xh3b4sd/rescue/pkg/task"
 	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/handler"
+	"github.com/venturemark/apiworker/pkg/index"
+	"github.com/venturemark/apiworker/pkg/transaction"
+	"github.com/venturemark/apiworker/pkg/user"
+)
+
+var (
+	// kind are the resource kinds of which subject associations are derived
+	// from the subject ID directly, regardless of the subject index.
+	kind = []string{
+		"message",
+		"timeline",
+		"update",
+		"venture",
+	}
 )
 
 type HandlerConfig struct {
-	Logger logger.Interface
-	Redigo redigo.Interface
-	Rescue rescue.Interface
+	Logger      logger.Interface
+	Redigo      redigo.Interface
+	Rescue      rescue.Interface
+	Transaction transaction.Interface
 
+	DryRun bool
+	// Timeout is the time given to walk the whole keyspace. It should be
+	// considerably larger than the timeout of ordinary handlers.
 	Timeout time.Duration
 }
 
 type Handler struct {
-	logger logger.Interface
-	redigo redigo.Interface
-	rescue rescue.Interface
+	logger      logger.Interface
+	redigo      redigo.Interface
+	rescue      rescue.Interface
+	transaction transaction.Interface
 
+	dryRun  bool
 	timeout time.Duration
 }
 
@@ -39,16 +63,21 @@ func NewHandler(c HandlerConfig) (*Handler, error) {
 	if c.Rescue == nil {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Rescue must not be empty", c)
 	}
+	if c.Transaction == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Transaction must not be empty", c)
+	}
 
 	if c.Timeout == 0 {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Timeout must not be empty", c)
 	}
 
 	h := &Handler{
-		logger: c.Logger,
-		redigo: c.Redigo,
-		rescue: c.Rescue,
+		logger:      c.Logger,
+		redigo:      c.Redigo,
+		rescue:      c.Rescue,
+		transaction: c.Transaction,
 
+		dryRun:  c.DryRun,
 		timeout: c.Timeout,
 	}
 
@@ -60,6 +89,21 @@ func (h *Handler) Ensure(tsk *task.Task) error {
 
 	h.logger.Log(context.Background(), "level", "info", "message", "deleting subject associations")
 
+	// Subject associations are only removed once userdelete erased the
+	// content of the user and deleted the user, since the erasure looks up
+	// the memberships of the user via its subject associations.
+	if !h.isDryRun(tsk) {
+		usr, err := user.Search(h.redigo, tsk.Obj.Metadata[metadata.SubjectID])
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if usr != nil {
+			h.logger.Log(context.Background(), "level", "info", "message", "postponing subject deletion until user content got erased", "user", tsk.Obj.Metadata[metadata.SubjectID])
+			return tracer.Mask(handler.IncompleteExecutionError)
+		}
+	}
+
 	err = h.deleteSubject(tsk)
 	if err != nil {
 		return tracer.Mask(err)
@@ -79,7 +123,89 @@ func (h *Handler) Filter(tsk *task.Task) bool {
 	return metadata.Contains(tsk.Obj.Metadata, met)
 }
 
+// deleteSubject removes all subject associations of the deleted subject. Once
+// the subject index got backfilled, only the keys known to the index are
+// deleted, together with the subject associations of all known resource kinds.
+// Handlers creating other keys referencing subjects add them to the index
+// within the same transaction. Before the first backfill the index
+// cannot be relied on, which is why the keyspace is walked instead.
 func (h *Handler) deleteSubject(tsk *task.Task) error {
+	_, err := h.redigo.Simple().Search().Value(index.Marker)
+	if simple.IsNotFound(err) {
+		err = h.walkSubject(tsk)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	} else if err != nil {
+		return tracer.Mask(err)
+	} else {
+		err = h.indexSubject(tsk)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	return nil
+}
+
+// indexSubject deletes the keys of the subject index, together with the
+// subject associations of all known resource kinds. The latter covers
+// associations created outside of apiworker, which only get indexed with the
+// next backfill.
+func (h *Handler) indexSubject(tsk *task.Task) error {
+	var sui string
+	{
+		sui = tsk.Obj.Metadata[metadata.SubjectID]
+	}
+
+	// The index is watched, so that keys indexed concurrently are deleted
+	// as well.
+	err := h.transaction.Watch([]string{index.Key(sui)}, func() ([]transaction.Operation, error) {
+		var lis []string
+		{
+			str, err := h.redigo.Sorted().Search().Order(index.Key(sui), 0, -1)
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			lis = append(lis, str...)
+		}
+
+		for _, kin := range kind {
+			met := map[string]string{
+				metadata.ResourceKind: kin,
+				metadata.SubjectID:    sui,
+			}
+
+			lis = append(lis, key.Subject(met).Elem())
+		}
+
+		var ops []transaction.Operation
+		for _, k := range lis {
+			if h.isDryRun(tsk) {
+				h.logger.Log(context.Background(), "level", "info", "message", "skipping key deletion in dry run", "key", k)
+				continue
+			}
+
+			ops = append(ops, transaction.Delete(k))
+		}
+
+		if h.isDryRun(tsk) {
+			return nil, nil
+		}
+
+		ops = append(ops, transaction.Delete(index.Key(sui)))
+
+		return ops, nil
+	})
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	return nil
+}
+
+func (h *Handler) walkSubject(tsk *task.Task) error {
 	var err error
 
 	var sui string
@@ -100,7 +226,18 @@ func (h *Handler) deleteSubject(tsk *task.Task) error {
 		defer close(don)
 
 		for k := range res {
-			err = h.redigo.Sorted().Delete().Clean(k)
+			// The pattern matches subjects having the deleted subject ID as
+			// prefix too, e.g. sub:12 for sub:1.
+			if index.Subject(k) != sui {
+				continue
+			}
+
+			if h.isDryRun(tsk) {
+				h.logger.Log(context.Background(), "level", "info", "message", "skipping key deletion in dry run", "key", k)
+				continue
+			}
+
+			err = h.transaction.Execute(transaction.Delete(k))
 			if err != nil {
 				erc <- tracer.Mask(err)
 			}
@@ -131,3 +268,7 @@ func (h *Handler) deleteSubject(tsk *task.Task) error {
 		}
 	}
 }
+
+func (h *Handler) isDryRun(tsk *task.Task) bool {
+	return h.dryRun || handler.IsDryRun(tsk)
+}
@@ -0,0 +1,200 @@
+package subjectdelete
+
+import (
+	"testing"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/rescue/pkg/engine"
+	"github.com/xh3b4sd/rescue/pkg/metric"
+	"github.com/xh3b4sd/rescue/pkg/task"
+
+	"github.com/venturemark/apiworker/pkg/handler"
+	"github.com/venturemark/apiworker/pkg/index"
+	"github.com/venturemark/apiworker/pkg/redistest"
+	"github.com/venturemark/apiworker/pkg/transaction"
+	"github.com/venturemark/apiworker/pkg/user"
+)
+
+// Test_Handler_Ensure_Index verifies that deleting a subject removes the keys
+// known to the subject index, including the keys indexed by handlers after the
+// index got backfilled the last time, without walking the keyspace for keys
+// not being indexed.
+func Test_Handler_Ensure_Index(t *testing.T) {
+	red, tra := redistest.New(t)
+
+	han := testHandler(t, red, tra)
+
+	var ind string
+	var new string
+	var unk string
+	var oth string
+	{
+		ind = "ven:1:sub:2"
+		new = "ven:3:sub:2"
+		unk = "ven:4:sub:2"
+		oth = "ven:3:sub:23"
+	}
+
+	{
+		err := tra.Execute(
+			transaction.SimpleCreate(ind, "indexed"),
+			transaction.SimpleCreate(unk, "not indexed"),
+			transaction.SimpleCreate(oth, "other subject"),
+			transaction.SortedCreate(index.Key("2"), ind, 0),
+			transaction.SimpleCreate(index.Marker, time.Now().UTC().Format(time.RFC3339)),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = tra.Execute(
+			transaction.SimpleCreate(new, "indexed after backfill"),
+			index.Create(new),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	{
+		tsk := &task.Task{
+			Obj: task.TaskObj{
+				Metadata: map[string]string{
+					metadata.TaskAction:   "delete",
+					metadata.TaskResource: "user",
+					metadata.SubjectID:    "2",
+				},
+			},
+		}
+
+		err := han.Ensure(tsk)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, k := range []string{ind, new, index.Key("2")} {
+		exi, err := red.Simple().Exists().Element(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if exi {
+			t.Fatalf("expected key %q to be deleted", k)
+		}
+	}
+
+	for _, k := range []string{unk, oth} {
+		exi, err := red.Simple().Exists().Element(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !exi {
+			t.Fatalf("expected key %q to be kept", k)
+		}
+	}
+}
+
+// Test_Handler_Ensure_User verifies that the subject associations of a deleted
+// user are kept until userdelete deleted the user, since the erasure of the
+// user's content depends on them.
+func Test_Handler_Ensure_User(t *testing.T) {
+	red, tra := redistest.New(t)
+
+	han := testHandler(t, red, tra)
+
+	var sub string
+	{
+		sub = "ven:1:sub:2"
+	}
+
+	{
+		err := tra.Execute(
+			transaction.SimpleCreate(sub, "member"),
+			transaction.SimpleCreate(user.Key("2"), "{}"),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tsk := &task.Task{
+		Obj: task.TaskObj{
+			Metadata: map[string]string{
+				metadata.TaskAction:   "delete",
+				metadata.TaskResource: "user",
+				metadata.SubjectID:    "2",
+				metadata.UserID:       "2",
+			},
+		},
+	}
+
+	{
+		err := han.Ensure(tsk)
+		if !handler.IsIncompleteExecution(err) {
+			t.Fatalf("expected incomplete execution got %v", err)
+		}
+
+		exi, err := red.Simple().Exists().Element(sub)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !exi {
+			t.Fatalf("expected key %q to be kept", sub)
+		}
+	}
+
+	{
+		err := red.Simple().Delete().Element(user.Key("2"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = han.Ensure(tsk)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exi, err := red.Simple().Exists().Element(sub)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if exi {
+			t.Fatalf("expected key %q to be deleted", sub)
+		}
+	}
+}
+
+func testHandler(t *testing.T, red redigo.Interface, tra transaction.Interface) *Handler {
+	log, err := logger.New(logger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := engine.New(engine.Config{Logger: log, Metric: metric.New(), Redigo: red})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := HandlerConfig{
+		Logger:      log,
+		Redigo:      red,
+		Rescue:      res,
+		Transaction: tra,
+
+		Timeout: time.Minute,
+	}
+
+	han, err := NewHandler(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return han
+}
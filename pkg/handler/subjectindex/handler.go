@@ -0,0 +1,185 @@
+package subjectindex
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/rescue/pkg/task"
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/handler"
+	"github.com/venturemark/apiworker/pkg/index"
+	"github.com/venturemark/apiworker/pkg/transaction"
+)
+
+type HandlerConfig struct {
+	Logger      logger.Interface
+	Redigo      redigo.Interface
+	Transaction transaction.Interface
+
+	DryRun bool
+	// Timeout is the time given to walk the whole keyspace. It should be
+	// considerably larger than the timeout of ordinary handlers.
+	Timeout time.Duration
+}
+
+type Handler struct {
+	logger      logger.Interface
+	redigo      redigo.Interface
+	transaction transaction.Interface
+
+	dryRun  bool
+	timeout time.Duration
+}
+
+func NewHandler(c HandlerConfig) (*Handler, error) {
+	if c.Logger == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", c)
+	}
+	if c.Redigo == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Redigo must not be empty", c)
+	}
+	if c.Transaction == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Transaction must not be empty", c)
+	}
+
+	if c.Timeout == 0 {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Timeout must not be empty", c)
+	}
+
+	h := &Handler{
+		logger:      c.Logger,
+		redigo:      c.Redigo,
+		transaction: c.Transaction,
+
+		dryRun:  c.DryRun,
+		timeout: c.Timeout,
+	}
+
+	return h, nil
+}
+
+// Ensure backfills the subject index by walking the keyspace once for all
+// keys referencing any subject. Deleting the associations of a subject is then
+// proportional to the amount of memberships of the subject, instead of the
+// total amount of keys.
+func (h *Handler) Ensure(tsk *task.Task) error {
+	var err error
+
+	h.logger.Log(context.Background(), "level", "info", "message", "indexing subject associations")
+
+	var cou int
+	{
+		cou, err = h.createIndex(tsk)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	if !h.isDryRun(tsk) {
+		err = h.redigo.Simple().Create().Element(index.Marker, time.Now().UTC().Format(time.RFC3339))
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	h.logger.Log(context.Background(), "level", "info", "message", "indexed subject associations", "key", strconv.Itoa(cou))
+
+	return nil
+}
+
+func (h *Handler) Filter(tsk *task.Task) bool {
+	met := map[string]string{
+		metadata.TaskAction:   "index",
+		metadata.TaskResource: "subject",
+	}
+
+	return metadata.Contains(tsk.Obj.Metadata, met)
+}
+
+func (h *Handler) createIndex(tsk *task.Task) (int, error) {
+	var cou int
+
+	err := h.walk("*sub:*", func(k string) error {
+		if strings.HasPrefix(k, "apiworker.venturemark.co:") {
+			return nil
+		}
+
+		sui := index.Subject(k)
+		if sui == "" {
+			return nil
+		}
+
+		cou++
+
+		if h.isDryRun(tsk) {
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping index creation in dry run", "key", k, "subject", sui)
+			return nil
+		}
+
+		err := h.transaction.Execute(transaction.SortedCreate(index.Key(sui), k, 0))
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, tracer.Mask(err)
+	}
+
+	return cou, nil
+}
+
+func (h *Handler) isDryRun(tsk *task.Task) bool {
+	return h.dryRun || handler.IsDryRun(tsk)
+}
+
+func (h *Handler) walk(pat string, fun func(k string) error) error {
+	var don chan struct{}
+	var erc chan error
+	var res chan string
+	{
+		don = make(chan struct{}, 1)
+		erc = make(chan error, 1)
+		res = make(chan string, 1)
+	}
+
+	go func() {
+		defer close(don)
+
+		for k := range res {
+			err := fun(k)
+			if err != nil {
+				erc <- tracer.Mask(err)
+			}
+		}
+	}()
+
+	go func() {
+		defer close(res)
+
+		err := h.redigo.Walker().Simple(pat, don, res)
+		if err != nil {
+			erc <- tracer.Mask(err)
+		}
+	}()
+
+	{
+		select {
+		case <-don:
+			return nil
+
+		case err := <-erc:
+			return tracer.Mask(err)
+
+		case <-time.After(h.timeout):
+			return tracer.Mask(timeoutError)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package timelinedelete
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/venturemark/apicommon/pkg/key"
@@ -13,25 +14,47 @@ import (
 	"github.com/xh3b4sd/rescue"
 	"github.com/xh3b4sd/rescue/pkg/task"
 	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/archive"
+	"github.com/venturemark/apiworker/pkg/cursor"
+	"github.com/venturemark/apiworker/pkg/handler"
+	"github.com/venturemark/apiworker/pkg/tombstone"
+	"github.com/venturemark/apiworker/pkg/transaction"
 )
 
 type HandlerConfig struct {
-	Logger logger.Interface
-	Redigo redigo.Interface
-	Rescue rescue.Interface
-
+	Archive     *archive.Archive
+	Cursor      *cursor.Cursor
+	Logger      logger.Interface
+	Redigo      redigo.Interface
+	Rescue      rescue.Interface
+	Tombstone   *tombstone.Tombstone
+	Transaction transaction.Interface
+
+	DryRun  bool
 	Timeout time.Duration
 }
 
 type Handler struct {
-	logger logger.Interface
-	redigo redigo.Interface
-	rescue rescue.Interface
-
+	archive     *archive.Archive
+	cursor      *cursor.Cursor
+	logger      logger.Interface
+	redigo      redigo.Interface
+	rescue      rescue.Interface
+	tombstone   *tombstone.Tombstone
+	transaction transaction.Interface
+
+	dryRun  bool
 	timeout time.Duration
 }
 
 func NewHandler(c HandlerConfig) (*Handler, error) {
+	if c.Archive == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Archive must not be empty", c)
+	}
+	if c.Cursor == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Cursor must not be empty", c)
+	}
 	if c.Logger == nil {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", c)
 	}
@@ -41,16 +64,27 @@ func NewHandler(c HandlerConfig) (*Handler, error) {
 	if c.Rescue == nil {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Rescue must not be empty", c)
 	}
+	if c.Tombstone == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Tombstone must not be empty", c)
+	}
+	if c.Transaction == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Transaction must not be empty", c)
+	}
 
 	if c.Timeout == 0 {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Timeout must not be empty", c)
 	}
 
 	h := &Handler{
-		logger: c.Logger,
-		redigo: c.Redigo,
-		rescue: c.Rescue,
-
+		archive:     c.Archive,
+		cursor:      c.Cursor,
+		logger:      c.Logger,
+		redigo:      c.Redigo,
+		rescue:      c.Rescue,
+		tombstone:   c.Tombstone,
+		transaction: c.Transaction,
+
+		dryRun:  c.DryRun,
 		timeout: c.Timeout,
 	}
 
@@ -62,12 +96,68 @@ func (h *Handler) Ensure(tsk *task.Task) error {
 
 	h.logger.Log(context.Background(), "level", "info", "message", "deleting timeline resource")
 
-	err = h.deleteTimeline(tsk)
-	if err != nil {
-		return tracer.Mask(err)
+	// Tasks enqueued by the deletion of a parent resource are dropped once
+	// the parent got restored, since they would delete restored data again.
+	{
+		res, err := h.tombstone.IsRestored(tsk)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if res {
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping deletion of restored timeline resource", "timeline", tsk.Obj.Metadata[metadata.TimelineID])
+
+			c := cursor.Key(key.Update(tsk.Obj.Metadata).List())
+			i := cursor.Key(inviteCursor(tsk))
+
+			if h.isDryRun(tsk) {
+				h.logger.Log(context.Background(), "level", "info", "message", "skipping key deletion in dry run", "key", c)
+				h.logger.Log(context.Background(), "level", "info", "message", "skipping key deletion in dry run", "key", i)
+				return nil
+			}
+
+			err = h.transaction.Execute(transaction.Delete(c), transaction.Delete(i))
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			return nil
+		}
 	}
 
-	err = h.deleteUpdate(tsk)
+	// Child deletions are enqueued before the timeline itself is removed. Should
+	// the worker crash in between, the task gets retried and the timeline is
+	// still around to derive the children from. Updates are enqueued one chunk
+	// per execution, so that timelines with lots of updates neither exceed the
+	// memory limit nor the handler timeout.
+	var don bool
+	{
+		don, err = h.deleteUpdate(tsk)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if !don {
+			h.logger.Log(context.Background(), "level", "info", "message", "deleting timeline resource incompletely", "timeline", tsk.Obj.Metadata[metadata.TimelineID])
+			return tracer.Mask(handler.IncompleteExecutionError)
+		}
+	}
+
+	// Invites of timelines deleted along with their venture are enqueued by
+	// venturedelete already, which iterates all invites of the venture.
+	if _, ok := handler.CascadeTime(tsk); !ok {
+		don, err = h.deleteInvite(tsk)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if !don {
+			h.logger.Log(context.Background(), "level", "info", "message", "deleting timeline resource incompletely", "timeline", tsk.Obj.Metadata[metadata.TimelineID])
+			return tracer.Mask(handler.IncompleteExecutionError)
+		}
+	}
+
+	err = h.deleteTimeline(tsk)
 	if err != nil {
 		return tracer.Mask(err)
 	}
@@ -86,6 +176,59 @@ func (h *Handler) Filter(tsk *task.Task) bool {
 	return metadata.Contains(tsk.Obj.Metadata, met)
 }
 
+// deleteInvite enqueues the deletion of the invites of the venture which were
+// issued for the deleted timeline, one chunk of invites per execution, and
+// returns whether all invites got processed.
+func (h *Handler) deleteInvite(tsk *task.Task) (bool, error) {
+	var tii string
+	{
+		tii = tsk.Obj.Metadata[metadata.TimelineID]
+	}
+
+	var k string
+	{
+		k = key.Invite(tsk.Obj.Metadata).List()
+	}
+
+	don, err := h.cursor.ProcessAs(inviteCursor(tsk), k, h.isDryRun(tsk), h.timeout/2, inviteScore, func(s string) error {
+		i := &schema.Invite{}
+		err := json.Unmarshal([]byte(s), i)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if i.Obj.Metadata[metadata.TimelineID] != tii {
+			return nil
+		}
+
+		t := &task.Task{
+			Obj: task.TaskObj{
+				Metadata: i.Obj.Metadata,
+			},
+		}
+
+		t.Obj.Metadata[metadata.TaskAction] = "delete"
+		t.Obj.Metadata[metadata.TaskResource] = "invite"
+
+		if h.isDryRun(tsk) {
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping task creation in dry run", "resource", "invite", "invite", t.Obj.Metadata[metadata.InviteID])
+			return nil
+		}
+
+		err = h.rescue.Create(t)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return false, tracer.Mask(err)
+	}
+
+	return don, nil
+}
+
 func (h *Handler) deleteTimeline(tsk *task.Task) error {
 	var err error
 
@@ -98,7 +241,50 @@ func (h *Handler) deleteTimeline(tsk *task.Task) error {
 		k := tik.List()
 		s := tik.ID().F()
 
-		err = h.redigo.Sorted().Delete().Score(k, s)
+		c := cursor.Key(key.Update(tsk.Obj.Metadata).List())
+		i := cursor.Key(inviteCursor(tsk))
+
+		if h.isDryRun(tsk) {
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping element deletion in dry run", "key", k, "timeline", tsk.Obj.Metadata[metadata.TimelineID])
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping key deletion in dry run", "key", c)
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping key deletion in dry run", "key", i)
+			return nil
+		}
+
+		// The timeline is watched, so that the tombstone and the archive never
+		// hold a version older than the one being deleted.
+		err = h.transaction.Watch([]string{k}, func() ([]transaction.Operation, error) {
+			var ops []transaction.Operation
+
+			if h.archive.Enabled() || h.tombstone.Enabled() {
+				e, err := h.tombstone.Sorted(k, s)
+				if err != nil {
+					return nil, tracer.Mask(err)
+				}
+
+				if h.archive.Enabled() {
+					err = h.archive.Create(tsk.Obj.Metadata, e)
+					if err != nil {
+						return nil, tracer.Mask(err)
+					}
+				}
+
+				if h.tombstone.Enabled() {
+					o, err := h.tombstone.Bury(tsk.Obj.Metadata, e)
+					if err != nil {
+						return nil, tracer.Mask(err)
+					}
+
+					ops = append(ops, o...)
+				}
+			}
+
+			ops = append(ops, transaction.SortedDelete(k, s))
+			ops = append(ops, transaction.Delete(c))
+			ops = append(ops, transaction.Delete(i))
+
+			return ops, nil
+		})
 		if err != nil {
 			return tracer.Mask(err)
 		}
@@ -107,32 +293,22 @@ func (h *Handler) deleteTimeline(tsk *task.Task) error {
 	return nil
 }
 
-func (h *Handler) deleteUpdate(tsk *task.Task) error {
-	var upk *key.Key
+// deleteUpdate enqueues the deletion of the next chunk of updates of the
+// deleted timeline and returns whether all updates got enqueued. In dry runs
+// all chunks are processed at once, since there is no cursor being persisted.
+func (h *Handler) deleteUpdate(tsk *task.Task) (bool, error) {
+	var k string
 	{
-		upk = key.Update(tsk.Obj.Metadata)
+		k = key.Update(tsk.Obj.Metadata).List()
 	}
 
-	var upd []*schema.Update
-	{
-		k := upk.List()
-		str, err := h.redigo.Sorted().Search().Order(k, 0, -1)
+	don, err := h.cursor.Process(k, h.isDryRun(tsk), h.timeout/2, score, func(s string) error {
+		u := &schema.Update{}
+		err := json.Unmarshal([]byte(s), u)
 		if err != nil {
 			return tracer.Mask(err)
 		}
 
-		for _, s := range str {
-			u := &schema.Update{}
-			err = json.Unmarshal([]byte(s), u)
-			if err != nil {
-				return tracer.Mask(err)
-			}
-
-			upd = append(upd, u)
-		}
-	}
-
-	for _, u := range upd {
 		t := &task.Task{
 			Obj: task.TaskObj{
 				Metadata: u.Obj.Metadata,
@@ -142,11 +318,54 @@ func (h *Handler) deleteUpdate(tsk *task.Task) error {
 		t.Obj.Metadata[metadata.TaskAction] = "delete"
 		t.Obj.Metadata[metadata.TaskResource] = "update"
 
-		err := h.rescue.Create(t)
+		handler.Cascade(t)
+
+		if h.isDryRun(tsk) {
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping task creation in dry run", "resource", "update", "update", t.Obj.Metadata[metadata.UpdateID])
+			return nil
+		}
+
+		err = h.rescue.Create(t)
 		if err != nil {
 			return tracer.Mask(err)
 		}
+
+		return nil
+	})
+	if err != nil {
+		return false, tracer.Mask(err)
 	}
 
-	return nil
+	return don, nil
+}
+
+func (h *Handler) isDryRun(tsk *task.Task) bool {
+	return h.dryRun || handler.IsDryRun(tsk)
+}
+
+// inviteCursor returns the name of the cursor iterating the invites of the
+// venture for the deleted timeline, which must not interfere with the
+// iteration of the same invites by venturedelete.
+func inviteCursor(tsk *task.Task) string {
+	return fmt.Sprintf("tim:%s:%s", tsk.Obj.Metadata[metadata.TimelineID], key.Invite(tsk.Obj.Metadata).List())
+}
+
+func inviteScore(s string) (float64, error) {
+	i := &schema.Invite{}
+	err := json.Unmarshal([]byte(s), i)
+	if err != nil {
+		return 0, tracer.Mask(err)
+	}
+
+	return key.Invite(i.Obj.Metadata).ID().F(), nil
+}
+
+func score(s string) (float64, error) {
+	u := &schema.Update{}
+	err := json.Unmarshal([]byte(s), u)
+	if err != nil {
+		return 0, tracer.Mask(err)
+	}
+
+	return key.Update(u.Obj.Metadata).ID().F(), nil
 }
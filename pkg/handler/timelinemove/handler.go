@@ -0,0 +1,539 @@
+package timelinemove
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/key"
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/venturemark/apicommon/pkg/schema"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/redigo/pkg/simple"
+	"github.com/xh3b4sd/rescue/pkg/task"
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/handler"
+	"github.com/venturemark/apiworker/pkg/index"
+	"github.com/venturemark/apiworker/pkg/transaction"
+)
+
+const (
+	// moveTTL is the time after which the mark of a timeline being moved
+	// expires, unless the move gets resumed in the meantime. Moves abandoned
+	// midway do therefore not shield their resources from orphandelete
+	// forever.
+	moveTTL = 24 * time.Hour
+)
+
+const (
+	roleOwner = "owner"
+)
+
+const (
+	// Target is the task metadata key holding the ID of the venture the
+	// timeline is moved to. The venture the timeline is moved from is given
+	// by the ordinary venture ID of the task metadata.
+	Target = "task.venturemark.co/target"
+)
+
+type HandlerConfig struct {
+	Logger      logger.Interface
+	Redigo      redigo.Interface
+	Transaction transaction.Interface
+
+	// ChunkSize is the maximum number of updates moved per execution.
+	ChunkSize int
+	DryRun    bool
+	Timeout   time.Duration
+}
+
+type Handler struct {
+	logger      logger.Interface
+	redigo      redigo.Interface
+	transaction transaction.Interface
+
+	chunkSize int
+	dryRun    bool
+	timeout   time.Duration
+}
+
+func NewHandler(c HandlerConfig) (*Handler, error) {
+	if c.Logger == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", c)
+	}
+	if c.Redigo == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Redigo must not be empty", c)
+	}
+	if c.Transaction == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Transaction must not be empty", c)
+	}
+
+	if c.ChunkSize <= 0 {
+		return nil, tracer.Maskf(invalidConfigError, "%T.ChunkSize must be greater than 0", c)
+	}
+	if c.Timeout == 0 {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Timeout must not be empty", c)
+	}
+
+	h := &Handler{
+		logger:      c.Logger,
+		redigo:      c.Redigo,
+		transaction: c.Transaction,
+
+		chunkSize: c.ChunkSize,
+		dryRun:    c.DryRun,
+		timeout:   c.Timeout,
+	}
+
+	return h, nil
+}
+
+// Ensure moves a timeline together with its updates, messages and roles from
+// one venture to another. Resources are moved bottom up, messages before their
+// update and updates before their timeline, each within its own transaction.
+// A resource is only removed from the source venture once it got written to
+// the target venture, so that interrupted moves can be resumed by simply
+// executing the task again. Since moved resources vanish from the source
+// venture, every execution picks up where the previous one left off. The
+// subject of the task must be the owner of both the source and the target
+// venture.
+func (h *Handler) Ensure(tsk *task.Task) error {
+	var err error
+
+	h.logger.Log(context.Background(), "level", "info", "message", "moving timeline resource")
+
+	var mok string
+	{
+		mok = handler.MoveKey(tsk.Obj.Metadata[metadata.TimelineID])
+	}
+
+	var tar string
+	{
+		tar = tsk.Obj.Metadata[Target]
+
+		if tar == "" || tar == tsk.Obj.Metadata[metadata.VentureID] {
+			h.logger.Log(context.Background(), "level", "warning", "message", "skipping timeline move", "reason", "invalid target venture", "target", tar)
+			return h.deleteMark(tsk, mok)
+		}
+	}
+
+	{
+		met := map[string]string{
+			metadata.VentureID: tar,
+		}
+
+		_, err = h.redigo.Simple().Search().Value(key.Venture(met).Elem())
+		if simple.IsNotFound(err) {
+			h.logger.Log(context.Background(), "level", "warning", "message", "skipping timeline move", "reason", "target venture does not exist", "target", tar)
+			return h.deleteMark(tsk, mok)
+		} else if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	// Only owners of both ventures are allowed to move timelines between
+	// them, since moving a timeline removes it from the source venture and
+	// exposes it to all members of the target venture.
+	for _, vei := range []string{tsk.Obj.Metadata[metadata.VentureID], tar} {
+		var own bool
+		{
+			own, err = h.searchOwner(vei, tsk.Obj.Metadata[metadata.SubjectID])
+			if err != nil {
+				return tracer.Mask(err)
+			}
+		}
+
+		if !own {
+			h.logger.Log(context.Background(), "level", "warning", "message", "skipping timeline move", "reason", "subject is no owner of venture", "subject", tsk.Obj.Metadata[metadata.SubjectID], "venture", vei)
+			return h.deleteMark(tsk, mok)
+		}
+	}
+
+	var tim *schema.Timeline
+	{
+		tik := key.Timeline(tsk.Obj.Metadata)
+
+		str, err := h.redigo.Sorted().Search().Score(tik.List(), tik.ID().F(), tik.ID().F())
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if len(str) == 0 {
+			// The timeline does not exist in the source venture, which is
+			// the case if it got moved already.
+			return h.deleteMark(tsk, mok)
+		}
+
+		tim = &schema.Timeline{}
+		err = json.Unmarshal([]byte(str[0]), tim)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	// The timeline is marked as being moved before anything gets moved, so
+	// that orphandelete does not consider the resources already moved to the
+	// target venture as orphans. The mark is removed within the transaction
+	// moving the timeline itself, or once the move got abandoned. Its expiry
+	// is extended with every execution.
+	if !h.isDryRun(tsk) {
+		err = h.transaction.Execute(transaction.SimpleExpire(mok, tar, moveTTL))
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var don bool
+	{
+		don, err = h.moveUpdate(tsk, tim, tar)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if !don {
+			h.logger.Log(context.Background(), "level", "info", "message", "moving timeline resource incompletely", "timeline", tsk.Obj.Metadata[metadata.TimelineID])
+			return tracer.Mask(handler.IncompleteExecutionError)
+		}
+	}
+
+	err = h.moveElement(tsk, "timeline", tar, tim.Obj.Metadata, key.Timeline, decodeTimeline, transaction.Delete(mok))
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	h.logger.Log(context.Background(), "level", "info", "message", "moved timeline resource")
+
+	return nil
+}
+
+func (h *Handler) Filter(tsk *task.Task) bool {
+	met := map[string]string{
+		metadata.TaskAction:   "move",
+		metadata.TaskResource: "timeline",
+	}
+
+	return metadata.Contains(tsk.Obj.Metadata, met)
+}
+
+// deleteMark removes the mark of the timeline being moved, in case the move
+// got abandoned, so that orphandelete considers the resources moved to the
+// target venture so far.
+func (h *Handler) deleteMark(tsk *task.Task, mok string) error {
+	if h.isDryRun(tsk) {
+		h.logger.Log(context.Background(), "level", "info", "message", "skipping key deletion in dry run", "key", mok)
+		return nil
+	}
+
+	err := h.transaction.Execute(transaction.Delete(mok))
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	return nil
+}
+
+func (h *Handler) isDryRun(tsk *task.Task) bool {
+	return h.dryRun || handler.IsDryRun(tsk)
+}
+
+// moveElement moves a single timeline, update or message, described by the
+// given metadata, to the target venture. The element is read again while
+// being watched, together with its roles, and decoded using dec, so that
+// changes made since it got read first are moved as well instead of being
+// lost. The roles of the resource and the subject associations pointing to
+// them are moved within the same transaction, along with the given extra
+// operations.
+func (h *Handler) moveElement(tsk *task.Task, res string, tar string, met map[string]string, fun func(map[string]string) *key.Key, dec decoder, ext ...transaction.Operation) error {
+	var k string
+	var s float64
+	{
+		o := fun(met)
+
+		k = o.List()
+		s = o.ID().F()
+	}
+
+	if h.isDryRun(tsk) {
+		h.logger.Log(context.Background(), "level", "info", "message", "skipping element move in dry run", "resource", res, "key", k)
+		return nil
+	}
+
+	err := h.transaction.Watch([]string{k, roleKey(res, met)}, func() ([]transaction.Operation, error) {
+		val, err := h.redigo.Sorted().Search().Score(k, s, s)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		// The element got moved already, so only the extra operations are
+		// left to be applied.
+		if len(val) == 0 {
+			return ext, nil
+		}
+
+		met, obj, err := dec(val[0])
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		var ops []transaction.Operation
+		{
+			o, err := h.moveRole(res, tar, met)
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			ops = append(ops, o...)
+		}
+
+		{
+			met[metadata.VentureID] = tar
+
+			byt, err := json.Marshal(obj)
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			ops = append(ops, transaction.SortedCreate(fun(met).List(), string(byt), s))
+			ops = append(ops, transaction.SortedDelete(k, s))
+			ops = append(ops, ext...)
+		}
+
+		return ops, nil
+	})
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	return nil
+}
+
+// moveMessage moves all messages of the given update to the target venture.
+func (h *Handler) moveMessage(tsk *task.Task, upd *schema.Update, tar string) error {
+	var mes []*schema.Message
+	{
+		str, err := h.redigo.Sorted().Search().Order(key.Message(upd.Obj.Metadata).List(), 0, -1)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		for _, s := range str {
+			m := &schema.Message{}
+			err = json.Unmarshal([]byte(s), m)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			mes = append(mes, m)
+		}
+	}
+
+	for _, m := range mes {
+		err := h.moveElement(tsk, "message", tar, m.Obj.Metadata, key.Message, decodeMessage)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	return nil
+}
+
+// moveRole returns the operations moving the roles of the resource described
+// by the given metadata to the target venture. Subject associations reference
+// roles by their role list key, which contains the venture ID, so they have to
+// be rewritten as well. The rewritten associations are added to the subject
+// index right away, so that deleting the subject does not miss them.
+func (h *Handler) moveRole(res string, tar string, met map[string]string) ([]transaction.Operation, error) {
+	var rok string
+	{
+		rok = roleKey(res, met)
+	}
+
+	var rol []*schema.Role
+	{
+		str, err := h.redigo.Sorted().Search().Order(rok, 0, -1)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		for _, s := range str {
+			r := &schema.Role{}
+			err = json.Unmarshal([]byte(s), r)
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			rol = append(rol, r)
+		}
+	}
+
+	var ops []transaction.Operation
+	for _, r := range rol {
+		var s float64
+		var suk string
+		{
+			s = key.Role(r.Obj.Metadata).ID().F()
+
+			m := map[string]string{
+				metadata.ResourceKind: res,
+				metadata.SubjectID:    r.Obj.Metadata[metadata.SubjectID],
+			}
+
+			suk = key.Subject(m).Elem()
+		}
+
+		ops = append(ops, transaction.SortedRemove(suk, fmt.Sprintf("%s:%s", rok, r.Obj.Metadata[metadata.RoleID])))
+
+		r.Obj.Metadata[metadata.VentureID] = tar
+
+		byt, err := json.Marshal(r)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		nrk := key.Role(r.Obj.Metadata).List()
+
+		ops = append(ops, transaction.SortedCreate(nrk, string(byt), s))
+		ops = append(ops, transaction.SortedCreate(suk, fmt.Sprintf("%s:%s", nrk, r.Obj.Metadata[metadata.RoleID]), s))
+		ops = append(ops, index.Create(suk))
+	}
+
+	ops = append(ops, transaction.Delete(rok))
+
+	return ops, nil
+}
+
+// moveUpdate moves the next chunk of updates of the given timeline, including
+// their messages, to the target venture and returns whether all updates got
+// moved. In dry runs all chunks are processed at once, paging through the
+// updates, since nothing gets removed from the source venture.
+func (h *Handler) moveUpdate(tsk *task.Task, tim *schema.Timeline, tar string) (bool, error) {
+	var lef int
+	for {
+		var upd []*schema.Update
+		{
+			str, err := h.redigo.Sorted().Search().Order(key.Update(tim.Obj.Metadata).List(), lef, lef+h.chunkSize-1)
+			if err != nil {
+				return false, tracer.Mask(err)
+			}
+
+			for _, s := range str {
+				u := &schema.Update{}
+				err = json.Unmarshal([]byte(s), u)
+				if err != nil {
+					return false, tracer.Mask(err)
+				}
+
+				upd = append(upd, u)
+			}
+		}
+
+		for _, u := range upd {
+			err := h.moveMessage(tsk, u, tar)
+			if err != nil {
+				return false, tracer.Mask(err)
+			}
+
+			err = h.moveElement(tsk, "update", tar, u.Obj.Metadata, key.Update, decodeUpdate)
+			if err != nil {
+				return false, tracer.Mask(err)
+			}
+		}
+
+		if len(upd) < h.chunkSize {
+			return true, nil
+		}
+
+		if !h.isDryRun(tsk) {
+			return false, nil
+		}
+
+		lef += h.chunkSize
+	}
+}
+
+// searchOwner returns whether the subject with the given ID is the owner of
+// the venture with the given ID.
+func (h *Handler) searchOwner(vei string, sui string) (bool, error) {
+	if sui == "" {
+		return false, nil
+	}
+
+	var k string
+	{
+		m := map[string]string{
+			metadata.ResourceKind: "venture",
+			metadata.VentureID:    vei,
+		}
+
+		k = key.Role(m).List()
+	}
+
+	str, err := h.redigo.Sorted().Search().Order(k, 0, -1)
+	if err != nil {
+		return false, tracer.Mask(err)
+	}
+
+	for _, s := range str {
+		r := &schema.Role{}
+		err = json.Unmarshal([]byte(s), r)
+		if err != nil {
+			return false, tracer.Mask(err)
+		}
+
+		if r.Obj.Metadata[metadata.SubjectID] == sui && r.Obj.Metadata[metadata.RoleKind] == roleOwner {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// decoder returns the given timeline, update or message along with its
+// metadata.
+type decoder func(s string) (map[string]string, interface{}, error)
+
+func decodeMessage(s string) (map[string]string, interface{}, error) {
+	m := &schema.Message{}
+	err := json.Unmarshal([]byte(s), m)
+	if err != nil {
+		return nil, nil, tracer.Mask(err)
+	}
+
+	return m.Obj.Metadata, m, nil
+}
+
+func decodeTimeline(s string) (map[string]string, interface{}, error) {
+	t := &schema.Timeline{}
+	err := json.Unmarshal([]byte(s), t)
+	if err != nil {
+		return nil, nil, tracer.Mask(err)
+	}
+
+	return t.Obj.Metadata, t, nil
+}
+
+func decodeUpdate(s string) (map[string]string, interface{}, error) {
+	u := &schema.Update{}
+	err := json.Unmarshal([]byte(s), u)
+	if err != nil {
+		return nil, nil, tracer.Mask(err)
+	}
+
+	return u.Obj.Metadata, u, nil
+}
+
+// roleKey returns the key of the roles of the resource of the given kind,
+// described by the given metadata.
+func roleKey(res string, met map[string]string) string {
+	m := map[string]string{}
+	for k, v := range met {
+		m[k] = v
+	}
+
+	m[metadata.ResourceKind] = res
+
+	return key.Role(m).List()
+}
@@ -0,0 +1,228 @@
+package timelinemove
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/key"
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/venturemark/apicommon/pkg/schema"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/rescue/pkg/task"
+
+	"github.com/venturemark/apiworker/pkg/handler"
+	"github.com/venturemark/apiworker/pkg/index"
+	"github.com/venturemark/apiworker/pkg/redistest"
+	"github.com/venturemark/apiworker/pkg/transaction"
+)
+
+// Test_Handler_Ensure_Target verifies that timelines are only moved by owners
+// of both the source and the target venture, that the subject associations of
+// moved roles are added to the subject index, and that no move mark is left
+// behind.
+func Test_Handler_Ensure_Target(t *testing.T) {
+	testCases := []struct {
+		sub   string
+		moved bool
+	}{
+		// Case 0 ensures that the owner of both ventures can move timelines
+		// between them.
+		{
+			sub:   "10",
+			moved: true,
+		},
+		// Case 1 ensures that users without any role within the target
+		// venture cannot move timelines to it.
+		{
+			sub:   "11",
+			moved: false,
+		},
+		// Case 2 ensures that owners of the target venture cannot move
+		// timelines out of ventures they are no owner of.
+		{
+			sub:   "12",
+			moved: false,
+		},
+		// Case 3 ensures that members of both ventures cannot move timelines
+		// between them.
+		{
+			sub:   "13",
+			moved: false,
+		},
+		// Case 4 ensures that tasks without subject are not executed.
+		{
+			sub:   "",
+			moved: false,
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			red, tra := redistest.New(t)
+
+			var han *Handler
+			{
+				log, err := logger.New(logger.Config{})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				c := HandlerConfig{
+					Logger:      log,
+					Redigo:      red,
+					Transaction: tra,
+
+					ChunkSize: 10,
+					Timeout:   time.Minute,
+				}
+
+				han, err = NewHandler(c)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			src := map[string]string{
+				metadata.TimelineID: "3",
+				metadata.VentureID:  "1",
+			}
+
+			tar := map[string]string{
+				metadata.VentureID: "2",
+			}
+
+			var ops []transaction.Operation
+			{
+				ops = append(ops, value(t, key.Venture(tar).Elem(), &schema.Venture{Obj: schema.VentureObj{Metadata: tar}}))
+				ops = append(ops, sorted(t, key.Timeline(src), &schema.Timeline{Obj: schema.TimelineObj{Metadata: src}}))
+
+				for _, r := range []struct {
+					rid string
+					kin string
+					sui string
+					vei string
+				}{
+					{rid: "4", kin: "owner", sui: "10", vei: "2"},
+					{rid: "6", kin: "owner", sui: "10", vei: "1"},
+					{rid: "7", kin: "owner", sui: "12", vei: "2"},
+					{rid: "8", kin: "member", sui: "13", vei: "1"},
+					{rid: "9", kin: "member", sui: "13", vei: "2"},
+				} {
+					own := map[string]string{
+						metadata.ResourceKind: "venture",
+						metadata.RoleID:       r.rid,
+						metadata.RoleKind:     r.kin,
+						metadata.SubjectID:    r.sui,
+						metadata.VentureID:    r.vei,
+					}
+
+					ops = append(ops, sorted(t, key.Role(own), &schema.Role{Obj: schema.RoleObj{Metadata: own}}))
+				}
+
+				rol := map[string]string{
+					metadata.ResourceKind: "timeline",
+					metadata.RoleID:       "5",
+					metadata.RoleKind:     "owner",
+					metadata.SubjectID:    "10",
+					metadata.TimelineID:   "3",
+					metadata.VentureID:    "1",
+				}
+
+				ops = append(ops, sorted(t, key.Role(rol), &schema.Role{Obj: schema.RoleObj{Metadata: rol}}))
+
+				err := tra.Execute(ops...)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			{
+				tsk := &task.Task{
+					Obj: task.TaskObj{
+						Metadata: map[string]string{
+							metadata.TaskAction:   "move",
+							metadata.TaskResource: "timeline",
+							metadata.SubjectID:    tc.sub,
+							metadata.TimelineID:   "3",
+							metadata.VentureID:    "1",
+							Target:                "2",
+						},
+					},
+				}
+
+				err := han.Ensure(tsk)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			{
+				m := map[string]string{
+					metadata.TimelineID: "3",
+					metadata.VentureID:  "2",
+				}
+
+				str, err := red.Sorted().Search().Order(key.Timeline(m).List(), 0, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if (len(str) == 1) != tc.moved {
+					t.Fatalf("expected timeline moved to be %t", tc.moved)
+				}
+			}
+
+			{
+				m := map[string]string{
+					metadata.ResourceKind: "timeline",
+					metadata.SubjectID:    "10",
+				}
+
+				str, err := red.Sorted().Search().Order(index.Key("10"), 0, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				ind := len(str) == 1 && str[0] == key.Subject(m).Elem()
+				if ind != tc.moved {
+					t.Fatalf("expected subject association indexed to be %t", tc.moved)
+				}
+			}
+
+			{
+				exi, err := red.Simple().Exists().Element(handler.MoveKey("3"))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if exi {
+					t.Fatal("expected move mark to be deleted")
+				}
+			}
+		})
+	}
+}
+
+func value(t *testing.T, k string, v interface{}) transaction.Operation {
+	t.Helper()
+
+	byt, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return transaction.SimpleCreate(k, string(byt))
+}
+
+func sorted(t *testing.T, k *key.Key, v interface{}) transaction.Operation {
+	t.Helper()
+
+	byt, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return transaction.SortedCreate(k.List(), string(byt), k.ID().F())
+}
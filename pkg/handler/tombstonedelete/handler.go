@@ -0,0 +1,92 @@
+package tombstonedelete
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/rescue/pkg/task"
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/tombstone"
+)
+
+type HandlerConfig struct {
+	Logger    logger.Interface
+	Tombstone *tombstone.Tombstone
+
+	Timeout time.Duration
+}
+
+type Handler struct {
+	logger    logger.Interface
+	tombstone *tombstone.Tombstone
+
+	timeout time.Duration
+}
+
+func NewHandler(c HandlerConfig) (*Handler, error) {
+	if c.Logger == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", c)
+	}
+	if c.Tombstone == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Tombstone must not be empty", c)
+	}
+
+	if c.Timeout == 0 {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Timeout must not be empty", c)
+	}
+
+	h := &Handler{
+		logger:    c.Logger,
+		tombstone: c.Tombstone,
+
+		timeout: c.Timeout,
+	}
+
+	return h, nil
+}
+
+func (h *Handler) Ensure(tsk *task.Task) error {
+	var err error
+
+	h.logger.Log(context.Background(), "level", "info", "message", "deleting expired tombstones")
+
+	var ent []*tombstone.Entry
+	{
+		ent, err = h.tombstone.Expired()
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	for _, e := range ent {
+		err = h.tombstone.Delete(e)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var cou int
+	{
+		cou, err = h.tombstone.Prune()
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	h.logger.Log(context.Background(), "level", "info", "message", "deleted expired tombstones", "count", strconv.Itoa(len(ent)), "marks", strconv.Itoa(cou))
+
+	return nil
+}
+
+func (h *Handler) Filter(tsk *task.Task) bool {
+	met := map[string]string{
+		metadata.TaskAction:   "delete",
+		metadata.TaskResource: "tombstone",
+	}
+
+	return metadata.Contains(tsk.Obj.Metadata, met)
+}
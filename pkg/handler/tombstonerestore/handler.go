@@ -0,0 +1,146 @@
+package tombstonerestore
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/rescue/pkg/task"
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/tombstone"
+)
+
+var (
+	// resource maps the restorable resources to the metadata identifying
+	// them. Restoring a resource restores all of its children too, since
+	// their tombstones carry the same IDs.
+	resource = map[string][]string{
+		"venture": {
+			metadata.VentureID,
+		},
+		"timeline": {
+			metadata.VentureID,
+			metadata.TimelineID,
+		},
+		"update": {
+			metadata.VentureID,
+			metadata.TimelineID,
+			metadata.UpdateID,
+		},
+		"message": {
+			metadata.VentureID,
+			metadata.TimelineID,
+			metadata.UpdateID,
+			metadata.MessageID,
+		},
+	}
+)
+
+type HandlerConfig struct {
+	Logger    logger.Interface
+	Tombstone *tombstone.Tombstone
+
+	Timeout time.Duration
+}
+
+type Handler struct {
+	logger    logger.Interface
+	tombstone *tombstone.Tombstone
+
+	timeout time.Duration
+}
+
+func NewHandler(c HandlerConfig) (*Handler, error) {
+	if c.Logger == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", c)
+	}
+	if c.Tombstone == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Tombstone must not be empty", c)
+	}
+
+	if c.Timeout == 0 {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Timeout must not be empty", c)
+	}
+
+	h := &Handler{
+		logger:    c.Logger,
+		tombstone: c.Tombstone,
+
+		timeout: c.Timeout,
+	}
+
+	return h, nil
+}
+
+func (h *Handler) Ensure(tsk *task.Task) error {
+	var err error
+
+	var res string
+	{
+		res = tsk.Obj.Metadata[metadata.TaskResource]
+	}
+
+	h.logger.Log(context.Background(), "level", "info", "message", "restoring resource", "resource", res)
+
+	var met map[string]string
+	{
+		met = map[string]string{}
+
+		for _, k := range resource[res] {
+			v := tsk.Obj.Metadata[k]
+			if v == "" {
+				h.logger.Log(context.Background(), "level", "warning", "message", "skipping restore of resource", "resource", res, "reason", k+" must not be empty")
+				return nil
+			}
+
+			met[k] = v
+		}
+	}
+
+	// The restore is marked before any tombstone gets restored, so that
+	// delete tasks still pending for the children of the resource cannot
+	// delete them again.
+	{
+		err = h.tombstone.Mark(met)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var ent []*tombstone.Entry
+	{
+		ent, err = h.tombstone.Search(met)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	for _, e := range ent {
+		err = h.tombstone.Restore(e)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	h.logger.Log(context.Background(), "level", "info", "message", "restored resource", "resource", res, "tombstones", strconv.Itoa(len(ent)))
+
+	return nil
+}
+
+func (h *Handler) Filter(tsk *task.Task) bool {
+	for r := range resource {
+		met := map[string]string{
+			metadata.TaskAction:   "restore",
+			metadata.TaskResource: r,
+		}
+
+		if metadata.Contains(tsk.Obj.Metadata, met) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,282 @@
+package tombstonerestore
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/key"
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/venturemark/apicommon/pkg/schema"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/rescue/pkg/engine"
+	"github.com/xh3b4sd/rescue/pkg/metric"
+	"github.com/xh3b4sd/rescue/pkg/task"
+
+	"github.com/venturemark/apiworker/pkg/archive"
+	"github.com/venturemark/apiworker/pkg/blob/local"
+	"github.com/venturemark/apiworker/pkg/cursor"
+	"github.com/venturemark/apiworker/pkg/handler"
+	"github.com/venturemark/apiworker/pkg/handler/timelinedelete"
+	"github.com/venturemark/apiworker/pkg/handler/updatedelete"
+	"github.com/venturemark/apiworker/pkg/redistest"
+	"github.com/venturemark/apiworker/pkg/tombstone"
+	"github.com/venturemark/apiworker/pkg/transaction"
+)
+
+// Test_Handler_Ensure_Cascade verifies that restoring a deleted timeline
+// cancels the update deletions the timeline deletion enqueued, so that the
+// updates still pending deletion survive the restore.
+func Test_Handler_Ensure_Cascade(t *testing.T) {
+	red, tra := redistest.New(t)
+
+	log, err := logger.New(logger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := engine.New(engine.Config{Logger: log, Metric: metric.New(), Redigo: red})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var arc *archive.Archive
+	var cur *cursor.Cursor
+	var tom *tombstone.Tombstone
+	{
+		blo, err := local.NewBlob(local.BlobConfig{Directory: t.TempDir()})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		arc, err = archive.New(archive.Config{Blob: blo, Transaction: tra})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cur, err = cursor.New(cursor.Config{Redigo: red, Size: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tom, err = tombstone.New(tombstone.Config{Redigo: red, Transaction: tra, Grace: time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tid *timelinedelete.Handler
+	{
+		c := timelinedelete.HandlerConfig{
+			Archive:     arc,
+			Cursor:      cur,
+			Logger:      log,
+			Redigo:      red,
+			Rescue:      res,
+			Tombstone:   tom,
+			Transaction: tra,
+
+			Timeout: time.Minute,
+		}
+
+		tid, err = timelinedelete.NewHandler(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var upd *updatedelete.Handler
+	{
+		c := updatedelete.HandlerConfig{
+			Archive:     arc,
+			Cursor:      cur,
+			Logger:      log,
+			Redigo:      red,
+			Rescue:      res,
+			Tombstone:   tom,
+			Transaction: tra,
+
+			Timeout: time.Minute,
+		}
+
+		upd, err = updatedelete.NewHandler(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var han *Handler
+	{
+		c := HandlerConfig{
+			Logger:    log,
+			Tombstone: tom,
+
+			Timeout: time.Minute,
+		}
+
+		han, err = NewHandler(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	met := map[string]string{
+		metadata.TimelineID: "2",
+		metadata.VentureID:  "1",
+	}
+
+	{
+		var ops []transaction.Operation
+
+		{
+			byt, err := json.Marshal(&schema.Timeline{Obj: schema.TimelineObj{Metadata: met}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ops = append(ops, transaction.SortedCreate(key.Timeline(met).List(), string(byt), key.Timeline(met).ID().F()))
+		}
+
+		for j := 1; j <= 3; j++ {
+			m := map[string]string{
+				metadata.TimelineID: met[metadata.TimelineID],
+				metadata.UpdateID:   strconv.Itoa(j),
+				metadata.VentureID:  met[metadata.VentureID],
+			}
+
+			byt, err := json.Marshal(&schema.Update{Obj: schema.UpdateObj{Metadata: m}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ops = append(ops, transaction.SortedCreate(key.Update(m).List(), string(byt), key.Update(m).ID().F()))
+		}
+
+		err = tra.Execute(ops...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	{
+		tsk := &task.Task{
+			Obj: task.TaskObj{
+				Metadata: map[string]string{
+					metadata.TaskAction:   "delete",
+					metadata.TaskResource: "timeline",
+					metadata.TimelineID:   met[metadata.TimelineID],
+					metadata.VentureID:    met[metadata.VentureID],
+				},
+			},
+		}
+
+		var i int
+		for {
+			err = tid.Ensure(tsk)
+			if !handler.IsIncompleteExecution(err) {
+				break
+			}
+
+			i++
+			if i > 5 {
+				t.Fatal("expected timeline deletion to complete")
+			}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	{
+		str, err := red.Sorted().Search().Order(key.Timeline(met).List(), 0, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(str) != 0 {
+			t.Fatalf("expected timeline to be deleted, got %d timelines", len(str))
+		}
+	}
+
+	{
+		tsk := &task.Task{
+			Obj: task.TaskObj{
+				Metadata: map[string]string{
+					metadata.TaskAction:   "restore",
+					metadata.TaskResource: "timeline",
+					metadata.TimelineID:   met[metadata.TimelineID],
+					metadata.VentureID:    met[metadata.VentureID],
+				},
+			},
+		}
+
+		err = han.Ensure(tsk)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	{
+		var cou int
+		for {
+			tsk, err := res.Search()
+			if engine.IsNoTask(err) {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if upd.Filter(tsk) {
+				err = upd.Ensure(tsk)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				cou++
+			}
+
+			err = res.Delete(tsk)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if cou != 3 {
+			t.Fatalf("expected 3 update deletions, got %d", cou)
+		}
+	}
+
+	{
+		str, err := red.Sorted().Search().Order(key.Timeline(met).List(), 0, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(str) != 1 {
+			t.Fatalf("expected timeline to be restored, got %d timelines", len(str))
+		}
+	}
+
+	{
+		str, err := red.Sorted().Search().Order(key.Update(met).List(), 0, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(str) != 3 {
+			t.Fatalf("expected 3 updates, got %d", len(str))
+		}
+	}
+
+	{
+		ent, err := tom.Search(met)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(ent) != 0 {
+			t.Fatalf("expected no tombstones, got %d", len(ent))
+		}
+	}
+}
@@ -13,25 +13,47 @@ import (
 	"github.com/xh3b4sd/rescue"
 	"github.com/xh3b4sd/rescue/pkg/task"
 	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/archive"
+	"github.com/venturemark/apiworker/pkg/cursor"
+	"github.com/venturemark/apiworker/pkg/handler"
+	"github.com/venturemark/apiworker/pkg/tombstone"
+	"github.com/venturemark/apiworker/pkg/transaction"
 )
 
 type HandlerConfig struct {
-	Logger logger.Interface
-	Redigo redigo.Interface
-	Rescue rescue.Interface
-
+	Archive     *archive.Archive
+	Cursor      *cursor.Cursor
+	Logger      logger.Interface
+	Redigo      redigo.Interface
+	Rescue      rescue.Interface
+	Tombstone   *tombstone.Tombstone
+	Transaction transaction.Interface
+
+	DryRun  bool
 	Timeout time.Duration
 }
 
 type Handler struct {
-	logger logger.Interface
-	redigo redigo.Interface
-	rescue rescue.Interface
-
+	archive     *archive.Archive
+	cursor      *cursor.Cursor
+	logger      logger.Interface
+	redigo      redigo.Interface
+	rescue      rescue.Interface
+	tombstone   *tombstone.Tombstone
+	transaction transaction.Interface
+
+	dryRun  bool
 	timeout time.Duration
 }
 
 func NewHandler(c HandlerConfig) (*Handler, error) {
+	if c.Archive == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Archive must not be empty", c)
+	}
+	if c.Cursor == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Cursor must not be empty", c)
+	}
 	if c.Logger == nil {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", c)
 	}
@@ -41,16 +63,27 @@ func NewHandler(c HandlerConfig) (*Handler, error) {
 	if c.Rescue == nil {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Rescue must not be empty", c)
 	}
+	if c.Tombstone == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Tombstone must not be empty", c)
+	}
+	if c.Transaction == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Transaction must not be empty", c)
+	}
 
 	if c.Timeout == 0 {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Timeout must not be empty", c)
 	}
 
 	h := &Handler{
-		logger: c.Logger,
-		redigo: c.Redigo,
-		rescue: c.Rescue,
-
+		archive:     c.Archive,
+		cursor:      c.Cursor,
+		logger:      c.Logger,
+		redigo:      c.Redigo,
+		rescue:      c.Rescue,
+		tombstone:   c.Tombstone,
+		transaction: c.Transaction,
+
+		dryRun:  c.DryRun,
 		timeout: c.Timeout,
 	}
 
@@ -62,12 +95,49 @@ func (h *Handler) Ensure(tsk *task.Task) error {
 
 	h.logger.Log(context.Background(), "level", "info", "message", "deleting update resource")
 
-	err = h.deleteUpdate(tsk)
-	if err != nil {
-		return tracer.Mask(err)
+	// Tasks enqueued by the deletion of a parent resource are dropped once
+	// the parent got restored, since they would delete restored data again.
+	{
+		res, err := h.tombstone.IsRestored(tsk)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if res {
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping deletion of restored update resource", "update", tsk.Obj.Metadata[metadata.UpdateID])
+
+			c := cursor.Key(key.Message(tsk.Obj.Metadata).List())
+
+			if h.isDryRun(tsk) {
+				h.logger.Log(context.Background(), "level", "info", "message", "skipping key deletion in dry run", "key", c)
+				return nil
+			}
+
+			err = h.transaction.Execute(transaction.Delete(c))
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			return nil
+		}
+	}
+
+	// Message deletions are enqueued chunk by chunk before the update itself
+	// is removed. See the timeline delete handler for the reasoning.
+	var don bool
+	{
+		don, err = h.deleteMessage(tsk)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if !don {
+			h.logger.Log(context.Background(), "level", "info", "message", "deleting update resource incompletely", "update", tsk.Obj.Metadata[metadata.UpdateID])
+			return tracer.Mask(handler.IncompleteExecutionError)
+		}
 	}
 
-	err = h.deleteMessage(tsk)
+	err = h.deleteUpdate(tsk)
 	if err != nil {
 		return tracer.Mask(err)
 	}
@@ -96,7 +166,47 @@ func (h *Handler) deleteUpdate(tsk *task.Task) error {
 		k := upk.List()
 		s := upk.ID().F()
 
-		err := h.redigo.Sorted().Delete().Score(k, s)
+		c := cursor.Key(key.Message(tsk.Obj.Metadata).List())
+
+		if h.isDryRun(tsk) {
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping element deletion in dry run", "key", k, "update", tsk.Obj.Metadata[metadata.UpdateID])
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping key deletion in dry run", "key", c)
+			return nil
+		}
+
+		// The update is watched, so that the tombstone and the archive never
+		// hold a version older than the one being deleted.
+		err := h.transaction.Watch([]string{k}, func() ([]transaction.Operation, error) {
+			var ops []transaction.Operation
+
+			if h.archive.Enabled() || h.tombstone.Enabled() {
+				e, err := h.tombstone.Sorted(k, s)
+				if err != nil {
+					return nil, tracer.Mask(err)
+				}
+
+				if h.archive.Enabled() {
+					err = h.archive.Create(tsk.Obj.Metadata, e)
+					if err != nil {
+						return nil, tracer.Mask(err)
+					}
+				}
+
+				if h.tombstone.Enabled() {
+					o, err := h.tombstone.Bury(tsk.Obj.Metadata, e)
+					if err != nil {
+						return nil, tracer.Mask(err)
+					}
+
+					ops = append(ops, o...)
+				}
+			}
+
+			ops = append(ops, transaction.SortedDelete(k, s))
+			ops = append(ops, transaction.Delete(c))
+
+			return ops, nil
+		})
 		if err != nil {
 			return tracer.Mask(err)
 		}
@@ -105,33 +215,22 @@ func (h *Handler) deleteUpdate(tsk *task.Task) error {
 	return nil
 }
 
-func (h *Handler) deleteMessage(tsk *task.Task) error {
-	var mek *key.Key
+// deleteMessage enqueues the deletion of the next chunk of messages of the
+// deleted update and returns whether all messages got enqueued. In dry runs
+// all chunks are processed at once, since there is no cursor being persisted.
+func (h *Handler) deleteMessage(tsk *task.Task) (bool, error) {
+	var k string
 	{
-		mek = key.Message(tsk.Obj.Metadata)
+		k = key.Message(tsk.Obj.Metadata).List()
 	}
 
-	var mes []*schema.Message
-	{
-		k := mek.List()
-
-		str, err := h.redigo.Sorted().Search().Order(k, 0, -1)
+	don, err := h.cursor.Process(k, h.isDryRun(tsk), h.timeout/2, score, func(s string) error {
+		m := &schema.Message{}
+		err := json.Unmarshal([]byte(s), m)
 		if err != nil {
 			return tracer.Mask(err)
 		}
 
-		for _, s := range str {
-			m := &schema.Message{}
-			err = json.Unmarshal([]byte(s), m)
-			if err != nil {
-				return tracer.Mask(err)
-			}
-
-			mes = append(mes, m)
-		}
-	}
-
-	for _, m := range mes {
 		t := &task.Task{
 			Obj: task.TaskObj{
 				Metadata: m.Obj.Metadata,
@@ -141,11 +240,37 @@ func (h *Handler) deleteMessage(tsk *task.Task) error {
 		t.Obj.Metadata[metadata.TaskAction] = "delete"
 		t.Obj.Metadata[metadata.TaskResource] = "message"
 
-		err := h.rescue.Create(t)
+		handler.Cascade(t)
+
+		if h.isDryRun(tsk) {
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping task creation in dry run", "resource", "message", "message", t.Obj.Metadata[metadata.MessageID])
+			return nil
+		}
+
+		err = h.rescue.Create(t)
 		if err != nil {
 			return tracer.Mask(err)
 		}
+
+		return nil
+	})
+	if err != nil {
+		return false, tracer.Mask(err)
 	}
 
-	return nil
+	return don, nil
+}
+
+func (h *Handler) isDryRun(tsk *task.Task) bool {
+	return h.dryRun || handler.IsDryRun(tsk)
+}
+
+func score(s string) (float64, error) {
+	m := &schema.Message{}
+	err := json.Unmarshal([]byte(s), m)
+	if err != nil {
+		return 0, tracer.Mask(err)
+	}
+
+	return key.Message(m.Obj.Metadata).ID().F(), nil
 }
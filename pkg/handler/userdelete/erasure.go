@@ -0,0 +1,429 @@
+package userdelete
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/key"
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/venturemark/apicommon/pkg/schema"
+	"github.com/xh3b4sd/redigo/pkg/simple"
+	"github.com/xh3b4sd/rescue/pkg/task"
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/transaction"
+)
+
+const (
+	// erased is the cursor position marking update lists of which all
+	// content got erased already.
+	erased = math.MaxFloat64
+)
+
+const (
+	// ErasureAnonymise keeps the updates and messages authored by a deleted
+	// user but removes the user ID from their metadata.
+	ErasureAnonymise = "anonymise"
+	// ErasureDelete deletes the updates and messages authored by a deleted
+	// user.
+	ErasureDelete = "delete"
+)
+
+// eraseContent applies the configured erasure policy to the updates and
+// messages the deleted user authored within the ventures they are member of,
+// until half of the handler timeout elapsed, and returns whether all content
+// got erased. Every timeline is erased chunk by chunk using its own cursor, so
+// that large accounts neither exceed the memory limit nor the handler
+// timeout. The update lists of all timelines are returned, so that the cursors
+// iterating them can be cleaned up once the user got deleted. In dry runs all
+// chunks of every timeline are processed at once, since there is no cursor
+// being persisted. Note that the memberships are looked up via the subject
+// associations of the user, which is why subjectdelete and roledelete wait for
+// the user to be deleted before cleaning them up.
+func (h *Handler) eraseContent(tsk *task.Task) (bool, []string, error) {
+	var err error
+
+	var uid string
+	{
+		uid = tsk.Obj.Metadata[metadata.UserID]
+	}
+
+	var ven []*schema.Venture
+	{
+		ven, err = h.searchVentures(tsk)
+		if err != nil {
+			return false, nil, tracer.Mask(err)
+		}
+	}
+
+	var dea time.Time
+	{
+		dea = time.Now().Add(h.timeout / 2)
+	}
+
+	var lis []string
+	var don bool
+	{
+		don = true
+	}
+
+	for _, v := range ven {
+		var tim []*schema.Timeline
+		{
+			str, err := h.redigo.Sorted().Search().Order(key.Timeline(v.Obj.Metadata).List(), 0, -1)
+			if err != nil {
+				return false, nil, tracer.Mask(err)
+			}
+
+			for _, s := range str {
+				t := &schema.Timeline{}
+				err = json.Unmarshal([]byte(s), t)
+				if err != nil {
+					return false, nil, tracer.Mask(err)
+				}
+
+				tim = append(tim, t)
+			}
+		}
+
+		for _, t := range tim {
+			k := key.Update(t.Obj.Metadata).List()
+
+			lis = append(lis, k)
+
+			if !don {
+				continue
+			}
+
+			ok, err := h.eraseUpdates(tsk, k, eraseCursor(uid, k), uid, dea)
+			if err != nil {
+				return false, nil, tracer.Mask(err)
+			}
+
+			if !ok {
+				don = false
+			}
+		}
+	}
+
+	return don, lis, nil
+}
+
+// eraseChunk erases the given chunk of updates of the update list k and
+// returns the position of the last update of the chunk, or the given position
+// p if the chunk is empty.
+func (h *Handler) eraseChunk(tsk *task.Task, k string, p float64, str []string, uid string) (float64, error) {
+	for _, s := range str {
+		u := &schema.Update{}
+		err := json.Unmarshal([]byte(s), u)
+		if err != nil {
+			return 0, tracer.Mask(err)
+		}
+
+		p = key.Update(u.Obj.Metadata).ID().F()
+
+		// Deleting an update deletes all of its messages too, so there is
+		// nothing left to erase below updates the user authored.
+		if u.Obj.Metadata[metadata.UserID] == uid && h.erasure == ErasureDelete {
+			err = h.eraseElement(tsk, "update", k, p, u.Obj.Metadata, decodeUpdate)
+			if err != nil {
+				return 0, tracer.Mask(err)
+			}
+
+			continue
+		}
+
+		err = h.eraseMessages(tsk, u, uid)
+		if err != nil {
+			return 0, tracer.Mask(err)
+		}
+
+		if u.Obj.Metadata[metadata.UserID] == uid {
+			err = h.eraseElement(tsk, "update", k, p, u.Obj.Metadata, decodeUpdate)
+			if err != nil {
+				return 0, tracer.Mask(err)
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// eraseElement applies the configured erasure policy to the update or message
+// with the score s within the sorted set k. The given metadata must belong to
+// the element. Anonymised elements are decoded using dec.
+func (h *Handler) eraseElement(tsk *task.Task, res string, k string, s float64, met map[string]string, dec decoder) error {
+	if h.erasure == ErasureDelete {
+		t := &task.Task{
+			Obj: task.TaskObj{
+				Metadata: map[string]string{},
+			},
+		}
+
+		for l, v := range met {
+			t.Obj.Metadata[l] = v
+		}
+
+		t.Obj.Metadata[metadata.TaskAction] = "delete"
+		t.Obj.Metadata[metadata.TaskResource] = res
+
+		if h.isDryRun(tsk) {
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping task creation in dry run", "resource", res, "key", k)
+			return nil
+		}
+
+		err := h.rescue.Create(t)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	if h.erasure == ErasureAnonymise {
+		if h.isDryRun(tsk) {
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping element anonymisation in dry run", "resource", res, "key", k)
+			return nil
+		}
+
+		// The element is read again while being watched, so that edits made
+		// since it got read by the cursor are never overwritten.
+		err := h.transaction.Watch([]string{k}, func() ([]transaction.Operation, error) {
+			val, err := h.redigo.Sorted().Search().Score(k, s, s)
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			if len(val) == 0 {
+				return nil, nil
+			}
+
+			met, obj, err := dec(val[0])
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			if met[metadata.UserID] != tsk.Obj.Metadata[metadata.UserID] {
+				return nil, nil
+			}
+
+			delete(met, metadata.UserID)
+
+			byt, err := json.Marshal(obj)
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			ops := []transaction.Operation{
+				transaction.SortedDelete(k, s),
+				transaction.SortedCreate(k, string(byt), s),
+			}
+
+			return ops, nil
+		})
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	return nil
+}
+
+func (h *Handler) eraseMessages(tsk *task.Task, upd *schema.Update, uid string) error {
+	var k string
+	{
+		k = key.Message(upd.Obj.Metadata).List()
+	}
+
+	str, err := h.redigo.Sorted().Search().Order(k, 0, -1)
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	for _, s := range str {
+		m := &schema.Message{}
+		err = json.Unmarshal([]byte(s), m)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if m.Obj.Metadata[metadata.UserID] != uid {
+			continue
+		}
+
+		err = h.eraseElement(tsk, "message", k, key.Message(m.Obj.Metadata).ID().F(), m.Obj.Metadata, decodeMessage)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	return nil
+}
+
+// eraseUpdates erases the update list k chunk by chunk until either all
+// updates got erased or the given deadline passed, and returns whether the
+// erasure of the list is complete. The position of the erasure is tracked by
+// the cursor c. Lists erased completely are marked as such, so that later
+// executions skip them. In dry runs all chunks are processed at once, since
+// there is no cursor being persisted.
+func (h *Handler) eraseUpdates(tsk *task.Task, k string, c string, uid string, dea time.Time) (bool, error) {
+	var p float64
+	{
+		s, err := h.cursor.Search(c)
+		if err != nil {
+			return false, tracer.Mask(err)
+		}
+
+		p = s
+	}
+
+	if p == erased {
+		return true, nil
+	}
+
+	for {
+		str, don, err := h.cursor.Next(k, p, updateScore)
+		if err != nil {
+			return false, tracer.Mask(err)
+		}
+
+		p, err = h.eraseChunk(tsk, k, p, str, uid)
+		if err != nil {
+			return false, tracer.Mask(err)
+		}
+
+		if h.isDryRun(tsk) {
+			if don {
+				return true, nil
+			}
+
+			continue
+		}
+
+		if don {
+			p = erased
+		}
+
+		if len(str) != 0 || don {
+			err = h.cursor.Commit(c, p)
+			if err != nil {
+				return false, tracer.Mask(err)
+			}
+		}
+
+		if don {
+			return true, nil
+		}
+
+		if time.Now().After(dea) {
+			return false, nil
+		}
+	}
+}
+
+func (h *Handler) searchVentures(tsk *task.Task) ([]*schema.Venture, error) {
+	var str []string
+	{
+		met := map[string]string{
+			metadata.ResourceKind: "venture",
+			metadata.SubjectID:    tsk.Obj.Metadata[metadata.SubjectID],
+		}
+
+		var err error
+		str, err = h.redigo.Sorted().Search().Order(key.Subject(met).Elem(), 0, -1)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+	}
+
+	var ven []*schema.Venture
+	for _, s := range str {
+		var rol *schema.Role
+		{
+			i := strings.LastIndex(s, ":")
+
+			roi, err := strconv.ParseFloat(s[i+1:], 64)
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			val, err := h.redigo.Sorted().Search().Score(s[:i], roi, roi)
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			if len(val) == 0 {
+				continue
+			}
+
+			rol = &schema.Role{}
+			err = json.Unmarshal([]byte(val[0]), rol)
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+		}
+
+		{
+			val, err := h.redigo.Simple().Search().Value(key.Venture(rol.Obj.Metadata).Elem())
+			if simple.IsNotFound(err) {
+				continue
+			} else if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			v := &schema.Venture{}
+			err = json.Unmarshal([]byte(val), v)
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			ven = append(ven, v)
+		}
+	}
+
+	return ven, nil
+}
+
+// eraseCursor returns the name of the cursor tracking the erasure of the
+// update list k on behalf of the deleted user. The name differs from the
+// update list itself, so that the erasure does not interfere with a
+// timeline deletion iterating the same list.
+func eraseCursor(uid string, k string) string {
+	return fmt.Sprintf("era:%s:%s", uid, k)
+}
+
+// decoder returns the given update or message along with its metadata.
+type decoder func(s string) (map[string]string, interface{}, error)
+
+func decodeMessage(s string) (map[string]string, interface{}, error) {
+	m := &schema.Message{}
+	err := json.Unmarshal([]byte(s), m)
+	if err != nil {
+		return nil, nil, tracer.Mask(err)
+	}
+
+	return m.Obj.Metadata, m, nil
+}
+
+func decodeUpdate(s string) (map[string]string, interface{}, error) {
+	u := &schema.Update{}
+	err := json.Unmarshal([]byte(s), u)
+	if err != nil {
+		return nil, nil, tracer.Mask(err)
+	}
+
+	return u.Obj.Metadata, u, nil
+}
+
+func updateScore(s string) (float64, error) {
+	u := &schema.Update{}
+	err := json.Unmarshal([]byte(s), u)
+	if err != nil {
+		return 0, tracer.Mask(err)
+	}
+
+	return key.Update(u.Obj.Metadata).ID().F(), nil
+}
@@ -2,6 +2,7 @@ package userdelete
 
 import (
 	"context"
+	"path"
 	"time"
 
 	"github.com/venturemark/apicommon/pkg/key"
@@ -11,25 +12,52 @@ import (
 	"github.com/xh3b4sd/rescue"
 	"github.com/xh3b4sd/rescue/pkg/task"
 	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/blob"
+	"github.com/venturemark/apiworker/pkg/cursor"
+	"github.com/venturemark/apiworker/pkg/handler"
+	"github.com/venturemark/apiworker/pkg/handler/userexport"
+	"github.com/venturemark/apiworker/pkg/preference"
+	"github.com/venturemark/apiworker/pkg/readmarker"
+	"github.com/venturemark/apiworker/pkg/transaction"
+	"github.com/venturemark/apiworker/pkg/user"
 )
 
 type HandlerConfig struct {
-	Logger logger.Interface
-	Redigo redigo.Interface
-	Rescue rescue.Interface
+	Blob        blob.Interface
+	Cursor      *cursor.Cursor
+	Logger      logger.Interface
+	Redigo      redigo.Interface
+	Rescue      rescue.Interface
+	Transaction transaction.Interface
 
+	DryRun bool
+	// Erasure is the policy applied to the updates and messages authored by
+	// deleted users, either ErasureAnonymise or ErasureDelete.
+	Erasure string
 	Timeout time.Duration
 }
 
 type Handler struct {
-	logger logger.Interface
-	redigo redigo.Interface
-	rescue rescue.Interface
+	blob        blob.Interface
+	cursor      *cursor.Cursor
+	logger      logger.Interface
+	redigo      redigo.Interface
+	rescue      rescue.Interface
+	transaction transaction.Interface
 
+	dryRun  bool
+	erasure string
 	timeout time.Duration
 }
 
 func NewHandler(c HandlerConfig) (*Handler, error) {
+	if c.Blob == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Blob must not be empty", c)
+	}
+	if c.Cursor == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Cursor must not be empty", c)
+	}
 	if c.Logger == nil {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", c)
 	}
@@ -39,16 +67,27 @@ func NewHandler(c HandlerConfig) (*Handler, error) {
 	if c.Rescue == nil {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Rescue must not be empty", c)
 	}
+	if c.Transaction == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Transaction must not be empty", c)
+	}
 
+	if c.Erasure != ErasureAnonymise && c.Erasure != ErasureDelete {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Erasure must be %s or %s", c, ErasureAnonymise, ErasureDelete)
+	}
 	if c.Timeout == 0 {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Timeout must not be empty", c)
 	}
 
 	h := &Handler{
-		logger: c.Logger,
-		redigo: c.Redigo,
-		rescue: c.Rescue,
+		blob:        c.Blob,
+		cursor:      c.Cursor,
+		logger:      c.Logger,
+		redigo:      c.Redigo,
+		rescue:      c.Rescue,
+		transaction: c.Transaction,
 
+		dryRun:  c.DryRun,
+		erasure: c.Erasure,
 		timeout: c.Timeout,
 	}
 
@@ -60,12 +99,44 @@ func (h *Handler) Ensure(tsk *task.Task) error {
 
 	h.logger.Log(context.Background(), "level", "info", "message", "deleting user resource")
 
-	err = h.deleteAssociation(tsk)
+	// The user is only deleted once all of its content got erased, so a
+	// missing user means that a former execution of the task completed the
+	// deletion already, e.g. while another handler asked for a retry.
+	{
+		usr, err := user.Search(h.redigo, tsk.Obj.Metadata[metadata.UserID])
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if usr == nil {
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping deletion of missing user", "user", tsk.Obj.Metadata[metadata.UserID])
+			return nil
+		}
+	}
+
+	// The content of the user is erased one chunk per execution. The user is
+	// only deleted once all content got erased, because the memberships of the
+	// user are required to find the content in the first place.
+	var lis []string
+	{
+		var don bool
+		don, lis, err = h.eraseContent(tsk)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if !don {
+			h.logger.Log(context.Background(), "level", "info", "message", "deleting user resource incompletely", "user", tsk.Obj.Metadata[metadata.UserID])
+			return tracer.Mask(handler.IncompleteExecutionError)
+		}
+	}
+
+	err = h.deleteExport(tsk)
 	if err != nil {
 		return tracer.Mask(err)
 	}
 
-	err = h.deleteUser(tsk)
+	err = h.deleteUser(tsk, lis)
 	if err != nil {
 		return tracer.Mask(err)
 	}
@@ -84,18 +155,35 @@ func (h *Handler) Filter(tsk *task.Task) bool {
 	return metadata.Contains(tsk.Obj.Metadata, met)
 }
 
-func (h *Handler) deleteAssociation(tsk *task.Task) error {
-	var err error
-
-	var clk *key.Key
+// deleteExport removes the blobs of export archives of former versions, which
+// were written to the blob store. Blobs cannot be removed transactionally, so
+// this happens before the user gets deleted, so that failures are retried.
+// Archives of the current version are kept in redis and get deleted together
+// with the user.
+func (h *Handler) deleteExport(tsk *task.Task) error {
+	var uid string
 	{
-		clk = key.Claim(tsk.Obj.Metadata)
+		uid = tsk.Obj.Metadata[metadata.UserID]
 	}
 
-	{
-		k := clk.Elem()
+	// The user ID ends up in a blob prefix, so it must not be trusted blindly.
+	if !user.IsValidID(uid) {
+		h.logger.Log(context.Background(), "level", "warning", "message", "skipping export deletion of invalid user", "user", uid)
+		return nil
+	}
+
+	nam, err := h.blob.Search(path.Join("export", "user", uid))
+	if err != nil {
+		return tracer.Mask(err)
+	}
 
-		err = h.redigo.Simple().Delete().Element(k)
+	for _, n := range nam {
+		if h.isDryRun(tsk) {
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping blob deletion in dry run", "blob", n)
+			continue
+		}
+
+		err = h.blob.Delete(n)
 		if err != nil {
 			return tracer.Mask(err)
 		}
@@ -104,22 +192,90 @@ func (h *Handler) deleteAssociation(tsk *task.Task) error {
 	return nil
 }
 
-func (h *Handler) deleteUser(tsk *task.Task) error {
-	var err error
-
-	var usk *key.Key
+// deleteUser removes the user, its claim association, its notification
+// preferences, its read markers, its export archive and the cursors of its
+// erasure and export within a single transaction, so that a crash can never
+// leave behind a claim pointing to a user that does not exist anymore, or
+// vice versa. The given update lists are the ones erased on behalf of the
+// user. Read markers of ventures the user left before are not known here and
+// get cleaned up by orphandelete.
+func (h *Handler) deleteUser(tsk *task.Task, lis []string) error {
+	var uid string
 	{
-		usk = key.User(tsk.Obj.Metadata)
+		uid = tsk.Obj.Metadata[metadata.UserID]
 	}
 
+	var sub string
 	{
-		k := usk.Elem()
+		met := map[string]string{
+			metadata.ResourceKind: "venture",
+			metadata.SubjectID:    tsk.Obj.Metadata[metadata.SubjectID],
+		}
+
+		sub = key.Subject(met).Elem()
+	}
+
+	fun := func() ([]transaction.Operation, error) {
+		ops := []transaction.Operation{
+			transaction.Delete(key.Claim(tsk.Obj.Metadata).Elem()),
+			transaction.Delete(key.User(tsk.Obj.Metadata).Elem()),
+			transaction.Delete(preference.Key(uid)),
+		}
+
+		{
+			ven, err := h.searchVentures(tsk)
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			for _, v := range ven {
+				ops = append(ops, transaction.Delete(readmarker.Key(uid, v.Obj.Metadata[metadata.VentureID])))
+				ops = append(ops, transaction.Delete(cursor.Key(userexport.Cursor(uid, key.Invite(v.Obj.Metadata).List()))))
+			}
+		}
+
+		for _, k := range lis {
+			ops = append(ops, transaction.Delete(cursor.Key(eraseCursor(uid, k))))
+			ops = append(ops, transaction.Delete(cursor.Key(userexport.Cursor(uid, k))))
+		}
 
-		err = h.redigo.Simple().Delete().Element(k)
+		{
+			exp, err := userexport.Keys(h.redigo, uid)
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			for _, k := range exp {
+				ops = append(ops, transaction.Delete(k))
+			}
+		}
+
+		return ops, nil
+	}
+
+	if h.isDryRun(tsk) {
+		ops, err := fun()
 		if err != nil {
 			return tracer.Mask(err)
 		}
+
+		for _, o := range ops {
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping key deletion in dry run", "key", o.Arguments[0])
+		}
+
+		return nil
+	}
+
+	// The memberships and the export archive of the user are watched, so that
+	// the read markers, cursors and archives deleted are never outdated.
+	err := h.transaction.Watch([]string{sub, userexport.Key(uid)}, fun)
+	if err != nil {
+		return tracer.Mask(err)
 	}
 
 	return nil
 }
+
+func (h *Handler) isDryRun(tsk *task.Task) bool {
+	return h.dryRun || handler.IsDryRun(tsk)
+}
@@ -0,0 +1,181 @@
+package userdelete
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/key"
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/venturemark/apicommon/pkg/schema"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/rescue/pkg/engine"
+	"github.com/xh3b4sd/rescue/pkg/metric"
+	"github.com/xh3b4sd/rescue/pkg/task"
+
+	"github.com/venturemark/apiworker/pkg/blob/local"
+	"github.com/venturemark/apiworker/pkg/cursor"
+	"github.com/venturemark/apiworker/pkg/handler/userexport"
+	"github.com/venturemark/apiworker/pkg/preference"
+	"github.com/venturemark/apiworker/pkg/readmarker"
+	"github.com/venturemark/apiworker/pkg/redistest"
+	"github.com/venturemark/apiworker/pkg/transaction"
+	"github.com/venturemark/apiworker/pkg/user"
+)
+
+// Test_Handler_Ensure_Fail verifies that a user deletion failing midway keeps
+// all keys of the user, and that retrying it afterwards deletes all of them,
+// including the export archives of the user.
+func Test_Handler_Ensure_Fail(t *testing.T) {
+	red, tra := redistest.New(t)
+
+	log, err := logger.New(logger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := engine.New(engine.Config{Logger: log, Metric: metric.New(), Redigo: red})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blo, err := local.NewBlob(local.BlobConfig{Directory: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cur, err := cursor.New(cursor.Config{Redigo: red, Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fau := &redistest.Fault{Fail: true, Transaction: tra}
+
+	var han *Handler
+	{
+		c := HandlerConfig{
+			Blob:        blo,
+			Cursor:      cur,
+			Logger:      log,
+			Redigo:      red,
+			Rescue:      res,
+			Transaction: fau,
+
+			Erasure: ErasureDelete,
+			Timeout: time.Minute,
+		}
+
+		han, err = NewHandler(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tsk := &task.Task{
+		Obj: task.TaskObj{
+			Metadata: map[string]string{
+				metadata.TaskAction:   "delete",
+				metadata.TaskResource: "user",
+				metadata.SubjectID:    "1",
+				metadata.UserID:       "1",
+			},
+		},
+	}
+
+	var exk []string
+	{
+		dak := userexport.Data("1", time.Unix(1, 0))
+
+		loc := fmt.Sprintf(`{"version":"2","location":%q,"complete":true}`, dak)
+
+		rol := &schema.Role{}
+		rol.Obj.Metadata = map[string]string{
+			metadata.ResourceKind: "venture",
+			metadata.RoleID:       "1",
+			metadata.SubjectID:    "1",
+			metadata.VentureID:    "1",
+		}
+
+		byt, err := json.Marshal(rol)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = tra.Execute(
+			transaction.SimpleCreate(user.Key("1"), "{}"),
+			transaction.SimpleCreate(key.Claim(tsk.Obj.Metadata).Elem(), "1"),
+			transaction.SimpleCreate(preference.Key("1"), "{}"),
+			transaction.SimpleCreate(readmarker.Key("1", "1"), "1"),
+			transaction.SimpleCreate(key.Venture(rol.Obj.Metadata).Elem(), "{}"),
+			transaction.SortedCreate(key.Role(rol.Obj.Metadata).List(), string(byt), 1),
+			transaction.SortedCreate(key.Subject(rol.Obj.Metadata).Elem(), fmt.Sprintf("%s:1", key.Role(rol.Obj.Metadata).List()), 1),
+			transaction.SimpleCreate(userexport.Key("1"), loc),
+			transaction.SortedCreate(dak, "{}", 1),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = blo.Write("export/user/1/1.json", []byte("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exk = []string{
+			user.Key("1"),
+			key.Claim(tsk.Obj.Metadata).Elem(),
+			preference.Key("1"),
+			readmarker.Key("1", "1"),
+			userexport.Key("1"),
+			dak,
+		}
+	}
+
+	{
+		err = han.Ensure(tsk)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+
+		for _, k := range exk {
+			exi, err := red.Simple().Exists().Element(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !exi {
+				t.Fatalf("expected key %q to be kept", k)
+			}
+		}
+	}
+
+	{
+		fau.Fail = false
+
+		err = han.Ensure(tsk)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, k := range exk {
+			exi, err := red.Simple().Exists().Element(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if exi {
+				t.Fatalf("expected key %q to be deleted", k)
+			}
+		}
+
+		nam, err := blo.Search("export/user/1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(nam) != 0 {
+			t.Fatalf("expected no export blobs got %v", nam)
+		}
+	}
+}
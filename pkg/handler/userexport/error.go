@@ -0,0 +1,23 @@
+package userexport
+
+import (
+	"errors"
+
+	"github.com/xh3b4sd/tracer"
+)
+
+var invalidConfigError = &tracer.Error{
+	Kind: "invalidConfigError",
+}
+
+func IsInvalidConfig(err error) bool {
+	return errors.Is(err, invalidConfigError)
+}
+
+var invalidKeyError = &tracer.Error{
+	Kind: "invalidKeyError",
+}
+
+func IsInvalidKey(err error) bool {
+	return errors.Is(err, invalidKeyError)
+}
@@ -0,0 +1,596 @@
+package userexport
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/key"
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/venturemark/apicommon/pkg/schema"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/redigo/pkg/simple"
+	"github.com/xh3b4sd/rescue/pkg/task"
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/cursor"
+	"github.com/venturemark/apiworker/pkg/handler"
+	"github.com/venturemark/apiworker/pkg/transaction"
+	"github.com/venturemark/apiworker/pkg/user"
+)
+
+var (
+	// kind are the resource kinds of which the subject roles of the user are
+	// exported.
+	kind = []string{
+		"timeline",
+		"venture",
+	}
+)
+
+type HandlerConfig struct {
+	Cursor      *cursor.Cursor
+	Logger      logger.Interface
+	Redigo      redigo.Interface
+	Transaction transaction.Interface
+
+	Timeout time.Duration
+}
+
+type Handler struct {
+	cursor      *cursor.Cursor
+	logger      logger.Interface
+	redigo      redigo.Interface
+	transaction transaction.Interface
+
+	timeout time.Duration
+}
+
+func NewHandler(c HandlerConfig) (*Handler, error) {
+	if c.Cursor == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Cursor must not be empty", c)
+	}
+	if c.Logger == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", c)
+	}
+	if c.Redigo == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Redigo must not be empty", c)
+	}
+	if c.Transaction == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Transaction must not be empty", c)
+	}
+
+	if c.Timeout == 0 {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Timeout must not be empty", c)
+	}
+
+	h := &Handler{
+		cursor:      c.Cursor,
+		logger:      c.Logger,
+		redigo:      c.Redigo,
+		transaction: c.Transaction,
+
+		timeout: c.Timeout,
+	}
+
+	return h, nil
+}
+
+// Ensure writes the archive of the user chunk by chunk. The archive is created
+// with the user and its roles on the first execution. Every execution then
+// adds the next chunks of invites, updates and messages of the user, until
+// the archive is marked complete. Only content the user created, or which is
+// addressed to the user, is exported, never the content of other members of
+// the user's ventures.
+func (h *Handler) Ensure(tsk *task.Task) error {
+	var err error
+
+	var uid string
+	{
+		uid = tsk.Obj.Metadata[metadata.UserID]
+	}
+
+	// The user ID ends up in keys, so it must not be trusted blindly.
+	if !user.IsValidID(uid) {
+		h.logger.Log(context.Background(), "level", "warning", "message", "skipping export of invalid user", "user", uid)
+		return nil
+	}
+
+	h.logger.Log(context.Background(), "level", "info", "message", "exporting user resource", "user", uid)
+
+	var usr *schema.User
+	{
+		usr, err = user.Search(h.redigo, uid)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if usr == nil {
+			h.logger.Log(context.Background(), "level", "warning", "message", "skipping export of missing user", "user", uid)
+			return nil
+		}
+	}
+
+	var rol []*schema.Role
+	{
+		rol, err = h.searchRole(uid)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	var loc *location
+	{
+		loc, err = searchLocation(h.redigo, uid)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		// Archives of former versions are never continued, because their
+		// location does not refer to a sorted set.
+		if loc == nil || loc.Complete || loc.Version != version {
+			loc, err = h.createArchive(uid, usr, rol, loc)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+		}
+	}
+
+	var cur []string
+	{
+		var don bool
+		don, cur, err = h.exportContent(uid, usr, rol, loc)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if !don {
+			h.logger.Log(context.Background(), "level", "info", "message", "exporting user resource incompletely", "user", uid)
+			return tracer.Mask(handler.IncompleteExecutionError)
+		}
+	}
+
+	{
+		err = h.completeArchive(uid, loc, cur)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	h.logger.Log(context.Background(), "level", "info", "message", "exported user resource", "user", uid)
+
+	return nil
+}
+
+func (h *Handler) Filter(tsk *task.Task) bool {
+	met := map[string]string{
+		metadata.TaskAction:   "export",
+		metadata.TaskResource: "user",
+	}
+
+	return metadata.Contains(tsk.Obj.Metadata, met)
+}
+
+// completeArchive marks the given archive complete and removes the cursors
+// of the export within a single transaction, so that the next export starts
+// from scratch.
+func (h *Handler) completeArchive(uid string, loc *location, cur []string) error {
+	l := *loc
+	l.Complete = true
+
+	byt, err := json.Marshal(l)
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	ops := []transaction.Operation{
+		transaction.SimpleCreate(Key(uid), string(byt)),
+	}
+
+	for _, c := range cur {
+		ops = append(ops, transaction.Delete(cursor.Key(c)))
+	}
+
+	err = h.transaction.Execute(ops...)
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	return nil
+}
+
+// createArchive records a new, incomplete archive holding the given user and
+// its roles. The items of the given former archive are removed within the
+// same transaction, so that only a single archive is kept per user.
+func (h *Handler) createArchive(uid string, usr *schema.User, rol []*schema.Role, old *location) (*location, error) {
+	var err error
+
+	loc := &location{
+		Version: version,
+		Created: time.Now().UTC(),
+	}
+
+	{
+		loc.Location = Data(uid, loc.Created)
+	}
+
+	var ops []transaction.Operation
+	{
+		byt, err := json.Marshal(loc)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		ops = append(ops, transaction.SimpleCreate(Key(uid), string(byt)))
+	}
+
+	if old != nil && old.Version == version {
+		ops = append(ops, transaction.Delete(old.Location))
+	}
+
+	{
+		byt, err := json.Marshal(usr)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		o, err := newItem(loc, "user", byt)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		ops = append(ops, o)
+	}
+
+	for _, r := range rol {
+		byt, err := json.Marshal(r)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		o, err := newItem(loc, "role", byt)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		ops = append(ops, o)
+	}
+
+	err = h.transaction.Execute(ops...)
+	if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	return loc, nil
+}
+
+// exportContent adds the next chunks of invites and updates of the ventures
+// the user is a member of to the given archive, until half of the handler
+// timeout elapsed. The names of all cursors involved are returned, so that
+// they can be removed once the export completed.
+func (h *Handler) exportContent(uid string, usr *schema.User, rol []*schema.Role, loc *location) (bool, []string, error) {
+	var dea time.Time
+	{
+		dea = time.Now().Add(h.timeout / 2)
+	}
+
+	var cur []string
+	var don bool
+	{
+		don = true
+	}
+
+	for _, r := range rol {
+		if r.Obj.Metadata[metadata.ResourceKind] != "venture" {
+			continue
+		}
+
+		var met map[string]string
+		{
+			met = map[string]string{
+				metadata.VentureID: r.Obj.Metadata[metadata.VentureID],
+			}
+		}
+
+		{
+			k := key.Invite(met).List()
+			c := Cursor(uid, k)
+
+			cur = append(cur, c)
+
+			if don {
+				ok, err := h.exportInvite(uid, usr, loc, k, c, dea)
+				if err != nil {
+					return false, nil, tracer.Mask(err)
+				}
+
+				if !ok {
+					don = false
+				}
+			}
+		}
+
+		var tim []*schema.Timeline
+		{
+			str, err := h.redigo.Sorted().Search().Order(key.Timeline(met).List(), 0, -1)
+			if err != nil {
+				return false, nil, tracer.Mask(err)
+			}
+
+			for _, s := range str {
+				t := &schema.Timeline{}
+				err = json.Unmarshal([]byte(s), t)
+				if err != nil {
+					return false, nil, tracer.Mask(err)
+				}
+
+				tim = append(tim, t)
+			}
+		}
+
+		for _, t := range tim {
+			k := key.Update(t.Obj.Metadata).List()
+			c := Cursor(uid, k)
+
+			cur = append(cur, c)
+
+			if !don {
+				continue
+			}
+
+			ok, err := h.exportUpdate(uid, loc, k, c, dea)
+			if err != nil {
+				return false, nil, tracer.Mask(err)
+			}
+
+			if !ok {
+				don = false
+			}
+		}
+	}
+
+	return don, cur, nil
+}
+
+// exportInvite adds the invites of the invite list k which the user sent, or
+// which are addressed to the user, to the given archive.
+func (h *Handler) exportInvite(uid string, usr *schema.User, loc *location, k string, c string, dea time.Time) (bool, error) {
+	if time.Now().After(dea) {
+		return false, nil
+	}
+
+	don, err := h.cursor.ProcessAs(c, k, false, time.Until(dea), inviteScore, func(s string) error {
+		i := &schema.Invite{}
+		err := json.Unmarshal([]byte(s), i)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if i.Obj.Metadata[metadata.UserID] != uid && i.Obj.Property.Mail != usr.Obj.Property.Mail {
+			return nil
+		}
+
+		o, err := newItem(loc, "invite", []byte(s))
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		err = h.transaction.Execute(o)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return false, tracer.Mask(err)
+	}
+
+	return don, nil
+}
+
+// exportUpdate adds the updates of the update list k which the user authored,
+// and the messages the user wrote in reply to any of them, to the given
+// archive. An update and its messages are added within a single transaction,
+// so that the cursor never moves past an update partially exported.
+func (h *Handler) exportUpdate(uid string, loc *location, k string, c string, dea time.Time) (bool, error) {
+	if time.Now().After(dea) {
+		return false, nil
+	}
+
+	don, err := h.cursor.ProcessAs(c, k, false, time.Until(dea), updateScore, func(s string) error {
+		u := &schema.Update{}
+		err := json.Unmarshal([]byte(s), u)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		var ops []transaction.Operation
+
+		if u.Obj.Metadata[metadata.UserID] == uid {
+			o, err := newItem(loc, "update", []byte(s))
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			ops = append(ops, o)
+		}
+
+		{
+			m, err := h.searchMessage(uid, loc, u)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			ops = append(ops, m...)
+		}
+
+		err = h.transaction.Execute(ops...)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return false, tracer.Mask(err)
+	}
+
+	return don, nil
+}
+
+// searchMessage returns the operations adding the messages the user wrote in
+// reply to the given update to the given archive. Messages are read chunk by
+// chunk, so that long discussions are never read into memory at once.
+func (h *Handler) searchMessage(uid string, loc *location, upd *schema.Update) ([]transaction.Operation, error) {
+	var k string
+	{
+		k = key.Message(upd.Obj.Metadata).List()
+	}
+
+	var ops []transaction.Operation
+	var p float64
+	for {
+		str, don, err := h.cursor.Next(k, p, messageScore)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		for _, s := range str {
+			m := &schema.Message{}
+			err = json.Unmarshal([]byte(s), m)
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			p = key.Message(m.Obj.Metadata).ID().F()
+
+			if m.Obj.Metadata[metadata.UserID] != uid {
+				continue
+			}
+
+			o, err := newItem(loc, "message", []byte(s))
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			ops = append(ops, o)
+		}
+
+		if don {
+			return ops, nil
+		}
+	}
+}
+
+func (h *Handler) searchRole(uid string) ([]*schema.Role, error) {
+	var rol []*schema.Role
+
+	for _, k := range kind {
+		var str []string
+		{
+			met := map[string]string{
+				metadata.ResourceKind: k,
+				metadata.SubjectID:    uid,
+			}
+
+			var err error
+			str, err = h.redigo.Sorted().Search().Order(key.Subject(met).Elem(), 0, -1)
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+		}
+
+		for _, s := range str {
+			rei, roi, err := split(s)
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			val, err := h.redigo.Sorted().Search().Score(rei, roi, roi)
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			if len(val) == 0 {
+				continue
+			}
+
+			r := &schema.Role{}
+			err = json.Unmarshal([]byte(val[0]), r)
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			rol = append(rol, r)
+		}
+	}
+
+	return rol, nil
+}
+
+func inviteScore(s string) (float64, error) {
+	i := &schema.Invite{}
+	err := json.Unmarshal([]byte(s), i)
+	if err != nil {
+		return 0, tracer.Mask(err)
+	}
+
+	return key.Invite(i.Obj.Metadata).ID().F(), nil
+}
+
+func messageScore(s string) (float64, error) {
+	m := &schema.Message{}
+	err := json.Unmarshal([]byte(s), m)
+	if err != nil {
+		return 0, tracer.Mask(err)
+	}
+
+	return key.Message(m.Obj.Metadata).ID().F(), nil
+}
+
+// newItem returns the operation adding the given resource to the given
+// archive. Items are scored by the time they got exported. Exporting the same
+// resource again only updates the score of its item, so that retries never
+// duplicate items.
+func newItem(loc *location, kin string, byt []byte) (transaction.Operation, error) {
+	i := item{
+		Kind: kin,
+		Data: json.RawMessage(byt),
+	}
+
+	val, err := json.Marshal(i)
+	if err != nil {
+		return transaction.Operation{}, tracer.Mask(err)
+	}
+
+	return transaction.SortedCreate(loc.Location, string(val), float64(time.Now().UnixNano())), nil
+}
+
+func updateScore(s string) (float64, error) {
+	u := &schema.Update{}
+	err := json.Unmarshal([]byte(s), u)
+	if err != nil {
+		return 0, tracer.Mask(err)
+	}
+
+	return key.Update(u.Obj.Metadata).ID().F(), nil
+}
+
+func searchLocation(red redigo.Interface, uid string) (*location, error) {
+	val, err := red.Simple().Search().Value(Key(uid))
+	if simple.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	l := &location{}
+	err = json.Unmarshal([]byte(val), l)
+	if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	return l, nil
+}
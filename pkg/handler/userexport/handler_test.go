@@ -0,0 +1,289 @@
+package userexport
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/key"
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/venturemark/apicommon/pkg/schema"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/rescue/pkg/task"
+
+	"github.com/venturemark/apiworker/pkg/cursor"
+	"github.com/venturemark/apiworker/pkg/handler"
+	"github.com/venturemark/apiworker/pkg/redistest"
+	"github.com/venturemark/apiworker/pkg/transaction"
+	"github.com/venturemark/apiworker/pkg/user"
+)
+
+// Test_Handler_Ensure verifies that the archive of a user is written chunk by
+// chunk, holds only content of the user, and that no cursor is left behind
+// once the archive is complete.
+func Test_Handler_Ensure(t *testing.T) {
+	red, tra := redistest.New(t)
+
+	exp := testHandler(t, red, tra)
+
+	seed(t, tra, "1")
+
+	tsk := &task.Task{
+		Obj: task.TaskObj{
+			Metadata: map[string]string{
+				metadata.TaskAction:   "export",
+				metadata.TaskResource: "user",
+				metadata.UserID:       "1",
+			},
+		},
+	}
+
+	var i int
+	for {
+		err := exp.Ensure(tsk)
+		if !handler.IsIncompleteExecution(err) && err != nil {
+			t.Fatal(err)
+		}
+		if err == nil {
+			break
+		}
+
+		i++
+		if i > 10 {
+			t.Fatal("expected export to complete")
+		}
+	}
+
+	var loc *location
+	{
+		val, err := red.Simple().Search().Value(Key("1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		loc = &location{}
+		err = json.Unmarshal([]byte(val), loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !loc.Complete {
+			t.Fatal("expected archive to be complete")
+		}
+		if loc.Location != Data("1", loc.Created) {
+			t.Fatalf("expected %s got %s", Data("1", loc.Created), loc.Location)
+		}
+	}
+
+	{
+		str, err := red.Sorted().Search().Order(loc.Location, 0, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cou := map[string]int{}
+		for _, s := range str {
+			itm := item{}
+			err = json.Unmarshal([]byte(s), &itm)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cou[itm.Kind]++
+		}
+
+		exp := map[string]int{
+			"user":    1,
+			"role":    1,
+			"update":  3,
+			"message": 1,
+		}
+
+		if len(cou) != len(exp) {
+			t.Fatalf("expected %v got %v", exp, cou)
+		}
+		for k, v := range exp {
+			if cou[k] != v {
+				t.Fatalf("expected %v got %v", exp, cou)
+			}
+		}
+	}
+
+	{
+		met := map[string]string{
+			metadata.VentureID:  "1",
+			metadata.TimelineID: "1",
+		}
+
+		ok, err := red.Simple().Exists().Element(cursor.Key(Cursor("1", key.Update(met).List())))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ok {
+			t.Fatal("expected cursor to be deleted")
+		}
+	}
+}
+
+// Test_Handler_Ensure_Invalid verifies that user IDs which do not have the
+// format of user IDs are never used to create keys.
+func Test_Handler_Ensure_Invalid(t *testing.T) {
+	red, tra := redistest.New(t)
+
+	exp := testHandler(t, red, tra)
+
+	uid := "../1"
+
+	tsk := &task.Task{
+		Obj: task.TaskObj{
+			Metadata: map[string]string{
+				metadata.TaskAction:   "export",
+				metadata.TaskResource: "user",
+				metadata.UserID:       uid,
+			},
+		},
+	}
+
+	err := exp.Ensure(tsk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := red.Simple().Exists().Element(Key(uid))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok {
+		t.Fatal("expected no archive")
+	}
+}
+
+// seed creates the user with the given ID as member of a venture with a
+// single timeline holding 5 updates, 3 of which the user authored, and a
+// single message the user wrote in reply to an update of somebody else.
+func seed(t *testing.T, tra transaction.Interface, uid string) {
+	t.Helper()
+
+	var ops []transaction.Operation
+
+	add := func(k string, v interface{}, s float64) {
+		byt, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ops = append(ops, transaction.SortedCreate(k, string(byt), s))
+	}
+
+	{
+		u := &schema.User{}
+		u.Obj.Metadata = map[string]string{metadata.UserID: uid}
+
+		byt, err := json.Marshal(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ops = append(ops, transaction.SimpleCreate(user.Key(uid), string(byt)))
+	}
+
+	{
+		met := map[string]string{
+			metadata.ResourceKind: "venture",
+			metadata.RoleID:       "1",
+			metadata.SubjectID:    uid,
+			metadata.VentureID:    "1",
+		}
+
+		r := &schema.Role{}
+		r.Obj.Metadata = met
+
+		add(key.Role(met).List(), r, 1)
+
+		ops = append(ops, transaction.SortedCreate(key.Subject(met).Elem(), fmt.Sprintf("%s:1", key.Role(met).List()), 1))
+	}
+
+	{
+		tim := &schema.Timeline{}
+		tim.Obj.Metadata = map[string]string{
+			metadata.TimelineID: "1",
+			metadata.VentureID:  "1",
+		}
+
+		add(key.Timeline(tim.Obj.Metadata).List(), tim, 1)
+	}
+
+	for i := 1; i <= 5; i++ {
+		aut := uid
+		if i%2 == 0 {
+			aut = "2"
+		}
+
+		u := &schema.Update{}
+		u.Obj.Metadata = map[string]string{
+			metadata.TimelineID: "1",
+			metadata.UpdateID:   strconv.Itoa(i),
+			metadata.UserID:     aut,
+			metadata.VentureID:  "1",
+		}
+
+		add(key.Update(u.Obj.Metadata).List(), u, float64(i))
+	}
+
+	for i := 1; i <= 2; i++ {
+		aut := uid
+		if i == 2 {
+			aut = "2"
+		}
+
+		m := &schema.Message{}
+		m.Obj.Metadata = map[string]string{
+			metadata.MessageID:  strconv.Itoa(i),
+			metadata.TimelineID: "1",
+			metadata.UpdateID:   "2",
+			metadata.UserID:     aut,
+			metadata.VentureID:  "1",
+		}
+
+		add(key.Message(m.Obj.Metadata).List(), m, float64(i))
+	}
+
+	err := tra.Execute(ops...)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testHandler returns a user export handler processing updates in chunks of 2.
+func testHandler(t *testing.T, red redigo.Interface, tra transaction.Interface) *Handler {
+	log, err := logger.New(logger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cur, err := cursor.New(cursor.Config{Redigo: red, Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := HandlerConfig{
+		Cursor:      cur,
+		Logger:      log,
+		Redigo:      red,
+		Transaction: tra,
+
+		Timeout: time.Minute,
+	}
+
+	exp, err := NewHandler(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return exp
+}
@@ -0,0 +1,84 @@
+package userexport
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/tracer"
+)
+
+// Key returns the simple key under which the location of the latest export
+// archive of the given user is recorded.
+func Key(uid string) string {
+	return fmt.Sprintf("apiworker.venturemark.co:exp:%s", uid)
+}
+
+// Data returns the key of the sorted set holding the items of the archive of
+// the given user created at the given time.
+func Data(uid string, cre time.Time) string {
+	return fmt.Sprintf("apiworker.venturemark.co:exp:%s:%d", uid, cre.UnixNano())
+}
+
+// Keys returns the keys holding the latest export archive of the given user,
+// so that they can be removed together with the user. The cursors of pending
+// exports are not included, see Cursor.
+func Keys(red redigo.Interface, uid string) ([]string, error) {
+	loc, err := searchLocation(red, uid)
+	if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	if loc == nil {
+		return nil, nil
+	}
+
+	k := []string{
+		Key(uid),
+	}
+
+	// Archives of former versions were written to the blob store, so their
+	// location does not refer to a key.
+	if loc.Version == version {
+		k = append(k, loc.Location)
+	}
+
+	return k, nil
+}
+
+// Cursor returns the name of the cursor tracking the export of the sorted set
+// k on behalf of the given user. The name differs from the sorted set itself,
+// so that the export does not interfere with e.g. a timeline deletion
+// iterating the same list.
+func Cursor(uid string, k string) string {
+	return fmt.Sprintf("exp:%s:%s", uid, k)
+}
+
+// split returns the role key and the role ID of the given subject element,
+// e.g. rol:ven:123:456 for rol:ven:123:456:789. An error is returned for
+// malformed elements, so that a single broken key does not crash the worker.
+func split(s string) (string, float64, error) {
+	var err error
+
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return "", 0, tracer.Maskf(invalidKeyError, "%s must contain a role ID", s)
+	}
+
+	var rei string
+	{
+		rei = s[:i]
+	}
+
+	var roi float64
+	{
+		roi, err = strconv.ParseFloat(s[i+1:], 64)
+		if err != nil {
+			return "", 0, tracer.Maskf(invalidKeyError, "%s must end with a numeric role ID", s)
+		}
+	}
+
+	return rei, roi, nil
+}
@@ -0,0 +1,33 @@
+package userexport
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	// version is the format version of the archive written by the handler.
+	// It must be increased whenever the structure of the archive changes in a
+	// backward incompatible way.
+	version = "2"
+)
+
+// item is a single element of an archive. Archives are sorted sets of items,
+// so that they can be written chunk by chunk and be served by apiserver
+// without reading them into memory at once.
+type item struct {
+	// Kind is the kind of resource the item holds, e.g. user, role, invite,
+	// update or message.
+	Kind string          `json:"kind"`
+	Data json.RawMessage `json:"data"`
+}
+
+// location is the record of the latest archive of a user. Location is the key
+// of the sorted set holding the items of the archive, see Data. Archives are
+// incomplete, and must not be served, until Complete is true.
+type location struct {
+	Version  string    `json:"version"`
+	Created  time.Time `json:"created"`
+	Location string    `json:"location"`
+	Complete bool      `json:"complete"`
+}
@@ -13,25 +13,48 @@ import (
 	"github.com/xh3b4sd/rescue"
 	"github.com/xh3b4sd/rescue/pkg/task"
 	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/archive"
+	"github.com/venturemark/apiworker/pkg/cursor"
+	"github.com/venturemark/apiworker/pkg/handler"
+	"github.com/venturemark/apiworker/pkg/readmarker"
+	"github.com/venturemark/apiworker/pkg/tombstone"
+	"github.com/venturemark/apiworker/pkg/transaction"
 )
 
 type HandlerConfig struct {
-	Logger logger.Interface
-	Redigo redigo.Interface
-	Rescue rescue.Interface
-
+	Archive     *archive.Archive
+	Cursor      *cursor.Cursor
+	Logger      logger.Interface
+	Redigo      redigo.Interface
+	Rescue      rescue.Interface
+	Tombstone   *tombstone.Tombstone
+	Transaction transaction.Interface
+
+	DryRun  bool
 	Timeout time.Duration
 }
 
 type Handler struct {
-	logger logger.Interface
-	redigo redigo.Interface
-	rescue rescue.Interface
-
+	archive     *archive.Archive
+	cursor      *cursor.Cursor
+	logger      logger.Interface
+	redigo      redigo.Interface
+	rescue      rescue.Interface
+	tombstone   *tombstone.Tombstone
+	transaction transaction.Interface
+
+	dryRun  bool
 	timeout time.Duration
 }
 
 func NewHandler(c HandlerConfig) (*Handler, error) {
+	if c.Archive == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Archive must not be empty", c)
+	}
+	if c.Cursor == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Cursor must not be empty", c)
+	}
 	if c.Logger == nil {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", c)
 	}
@@ -41,16 +64,27 @@ func NewHandler(c HandlerConfig) (*Handler, error) {
 	if c.Rescue == nil {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Rescue must not be empty", c)
 	}
+	if c.Tombstone == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Tombstone must not be empty", c)
+	}
+	if c.Transaction == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Transaction must not be empty", c)
+	}
 
 	if c.Timeout == 0 {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Timeout must not be empty", c)
 	}
 
 	h := &Handler{
-		logger: c.Logger,
-		redigo: c.Redigo,
-		rescue: c.Rescue,
-
+		archive:     c.Archive,
+		cursor:      c.Cursor,
+		logger:      c.Logger,
+		redigo:      c.Redigo,
+		rescue:      c.Rescue,
+		tombstone:   c.Tombstone,
+		transaction: c.Transaction,
+
+		dryRun:  c.DryRun,
 		timeout: c.Timeout,
 	}
 
@@ -62,6 +96,35 @@ func (h *Handler) Ensure(tsk *task.Task) error {
 
 	h.logger.Log(context.Background(), "level", "info", "message", "deleting venture resource")
 
+	// Invites are enqueued one chunk per execution, so that ventures with
+	// lots of invites neither exceed the memory limit nor the handler timeout.
+	var don bool
+	{
+		don, err = h.deleteInvite(tsk)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if !don {
+			h.logger.Log(context.Background(), "level", "info", "message", "deleting venture resource incompletely", "venture", tsk.Obj.Metadata[metadata.VentureID])
+			return tracer.Mask(handler.IncompleteExecutionError)
+		}
+	}
+
+	// Read markers are deleted chunk by chunk for the same reason, before the
+	// venture is gone and its roles are no longer around to derive them from.
+	{
+		don, err = h.deleteReadMarker(tsk)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if !don {
+			h.logger.Log(context.Background(), "level", "info", "message", "deleting venture resource incompletely", "venture", tsk.Obj.Metadata[metadata.VentureID])
+			return tracer.Mask(handler.IncompleteExecutionError)
+		}
+	}
+
 	err = h.deleteTimeline(tsk)
 	if err != nil {
 		return tracer.Mask(err)
@@ -86,6 +149,88 @@ func (h *Handler) Filter(tsk *task.Task) bool {
 	return metadata.Contains(tsk.Obj.Metadata, met)
 }
 
+// deleteInvite enqueues the deletion of the next chunk of invites of the
+// deleted venture and returns whether all invites got enqueued. In dry runs
+// all chunks are processed at once, since there is no cursor being persisted.
+func (h *Handler) deleteInvite(tsk *task.Task) (bool, error) {
+	var k string
+	{
+		k = key.Invite(tsk.Obj.Metadata).List()
+	}
+
+	don, err := h.cursor.Process(k, h.isDryRun(tsk), h.timeout/2, score, func(s string) error {
+		i := &schema.Invite{}
+		err := json.Unmarshal([]byte(s), i)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		t := &task.Task{
+			Obj: task.TaskObj{
+				Metadata: i.Obj.Metadata,
+			},
+		}
+
+		t.Obj.Metadata[metadata.TaskAction] = "delete"
+		t.Obj.Metadata[metadata.TaskResource] = "invite"
+
+		if h.isDryRun(tsk) {
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping task creation in dry run", "resource", "invite", "invite", t.Obj.Metadata[metadata.InviteID])
+			return nil
+		}
+
+		err = h.rescue.Create(t)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return false, tracer.Mask(err)
+	}
+
+	return don, nil
+}
+
+// deleteReadMarker deletes the read markers of the next chunk of members of
+// the deleted venture and returns whether the read markers of all members got
+// deleted. Read markers of former members are not known here and get cleaned
+// up by orphandelete.
+func (h *Handler) deleteReadMarker(tsk *task.Task) (bool, error) {
+	var k string
+	{
+		k = roleKey(tsk)
+	}
+
+	don, err := h.cursor.ProcessAs(readMarkerCursor(tsk), k, h.isDryRun(tsk), h.timeout/2, roleScore, func(s string) error {
+		r := &schema.Role{}
+		err := json.Unmarshal([]byte(s), r)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		rea := readmarker.Key(r.Obj.Metadata[metadata.SubjectID], tsk.Obj.Metadata[metadata.VentureID])
+
+		if h.isDryRun(tsk) {
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping key deletion in dry run", "key", rea)
+			return nil
+		}
+
+		err = h.transaction.Execute(transaction.Delete(rea))
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return false, tracer.Mask(err)
+	}
+
+	return don, nil
+}
+
 func (h *Handler) deleteTimeline(tsk *task.Task) error {
 	var tik *key.Key
 	{
@@ -122,6 +267,13 @@ func (h *Handler) deleteTimeline(tsk *task.Task) error {
 		t.Obj.Metadata[metadata.TaskAction] = "delete"
 		t.Obj.Metadata[metadata.TaskResource] = "timeline"
 
+		handler.Cascade(t)
+
+		if h.isDryRun(tsk) {
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping task creation in dry run", "resource", "timeline", "timeline", t.Obj.Metadata[metadata.TimelineID])
+			continue
+		}
+
 		err := h.rescue.Create(t)
 		if err != nil {
 			return tracer.Mask(err)
@@ -141,8 +293,50 @@ func (h *Handler) deleteVenture(tsk *task.Task) error {
 
 	{
 		k := vek.Elem()
+		c := cursor.Key(key.Invite(tsk.Obj.Metadata).List())
+		r := cursor.Key(readMarkerCursor(tsk))
+
+		if h.isDryRun(tsk) {
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping key deletion in dry run", "key", k)
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping key deletion in dry run", "key", c)
+			h.logger.Log(context.Background(), "level", "info", "message", "skipping key deletion in dry run", "key", r)
+			return nil
+		}
+
+		// The venture is watched, so that the tombstone never holds a version
+		// older than the one being deleted.
+		err = h.transaction.Watch([]string{k}, func() ([]transaction.Operation, error) {
+			var ops []transaction.Operation
+
+			if h.archive.Enabled() || h.tombstone.Enabled() {
+				e, err := h.tombstone.Simple(k)
+				if err != nil {
+					return nil, tracer.Mask(err)
+				}
+
+				if h.archive.Enabled() {
+					err = h.archive.Create(tsk.Obj.Metadata, e)
+					if err != nil {
+						return nil, tracer.Mask(err)
+					}
+				}
+
+				if h.tombstone.Enabled() {
+					o, err := h.tombstone.Bury(tsk.Obj.Metadata, e)
+					if err != nil {
+						return nil, tracer.Mask(err)
+					}
+
+					ops = append(ops, o...)
+				}
+			}
+
+			ops = append(ops, transaction.Delete(k))
+			ops = append(ops, transaction.Delete(c))
+			ops = append(ops, transaction.Delete(r))
 
-		err = h.redigo.Simple().Delete().Element(k)
+			return ops, nil
+		})
 		if err != nil {
 			return tracer.Mask(err)
 		}
@@ -150,3 +344,44 @@ func (h *Handler) deleteVenture(tsk *task.Task) error {
 
 	return nil
 }
+
+func (h *Handler) isDryRun(tsk *task.Task) bool {
+	return h.dryRun || handler.IsDryRun(tsk)
+}
+
+// readMarkerCursor returns the name of the cursor iterating the roles of the
+// deleted venture for deleting read markers, which must not interfere with
+// other iterations of the same roles.
+func readMarkerCursor(tsk *task.Task) string {
+	return "rea:" + roleKey(tsk)
+}
+
+// roleKey returns the key of the roles of the deleted venture.
+func roleKey(tsk *task.Task) string {
+	m := map[string]string{
+		metadata.ResourceKind: "venture",
+		metadata.VentureID:    tsk.Obj.Metadata[metadata.VentureID],
+	}
+
+	return key.Role(m).List()
+}
+
+func score(s string) (float64, error) {
+	i := &schema.Invite{}
+	err := json.Unmarshal([]byte(s), i)
+	if err != nil {
+		return 0, tracer.Mask(err)
+	}
+
+	return key.Invite(i.Obj.Metadata).ID().F(), nil
+}
+
+func roleScore(s string) (float64, error) {
+	r := &schema.Role{}
+	err := json.Unmarshal([]byte(s), r)
+	if err != nil {
+		return 0, tracer.Mask(err)
+	}
+
+	return key.Role(r.Obj.Metadata).ID().F(), nil
+}
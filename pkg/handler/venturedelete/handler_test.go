@@ -0,0 +1,409 @@
+package venturedelete
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/key"
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/venturemark/apicommon/pkg/schema"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/redigo/pkg/simple"
+	"github.com/xh3b4sd/rescue"
+	"github.com/xh3b4sd/rescue/pkg/engine"
+	"github.com/xh3b4sd/rescue/pkg/metric"
+	"github.com/xh3b4sd/rescue/pkg/task"
+
+	"github.com/venturemark/apiworker/pkg/archive"
+	"github.com/venturemark/apiworker/pkg/blob/local"
+	"github.com/venturemark/apiworker/pkg/cursor"
+	"github.com/venturemark/apiworker/pkg/handler"
+	"github.com/venturemark/apiworker/pkg/handler/invitedelete"
+	"github.com/venturemark/apiworker/pkg/mailer/capture"
+	"github.com/venturemark/apiworker/pkg/preference"
+	"github.com/venturemark/apiworker/pkg/readmarker"
+	"github.com/venturemark/apiworker/pkg/redistest"
+	"github.com/venturemark/apiworker/pkg/render"
+	"github.com/venturemark/apiworker/pkg/tombstone"
+	"github.com/venturemark/apiworker/pkg/transaction"
+)
+
+// Test_Handler_Ensure_Invite verifies that deleting a venture with more
+// invites than fit into a single chunk leaves no invite keys and no cursor
+// behind, once all enqueued invite deletions got executed.
+func Test_Handler_Ensure_Invite(t *testing.T) {
+	red, tra := redistest.New(t)
+
+	log, err := logger.New(logger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := engine.New(engine.Config{Logger: log, Metric: metric.New(), Redigo: red})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ven := testHandler(t, red, tra, log, res)
+
+	var inv *invitedelete.Handler
+	{
+		mai, err := capture.NewMailer(capture.MailerConfig{Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pre, err := preference.New(preference.Config{Redigo: red})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ren, err := render.New(render.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := invitedelete.HandlerConfig{
+			Logger:      log,
+			Mailer:      mai,
+			Preference:  pre,
+			Redigo:      red,
+			Render:      ren,
+			Rescue:      res,
+			Transaction: tra,
+
+			Timeout: time.Minute,
+		}
+
+		inv, err = invitedelete.NewHandler(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	met := map[string]string{
+		metadata.VentureID: "1",
+	}
+
+	var ink string
+	{
+		ink = key.Invite(met).List()
+	}
+
+	seed(t, tra, met, 5, nil)
+
+	{
+		tsk := &task.Task{
+			Obj: task.TaskObj{
+				Metadata: map[string]string{
+					metadata.TaskAction:   "delete",
+					metadata.TaskResource: "venture",
+					metadata.VentureID:    met[metadata.VentureID],
+				},
+			},
+		}
+
+		var i int
+		for {
+			err = ven.Ensure(tsk)
+			if !handler.IsIncompleteExecution(err) {
+				break
+			}
+
+			i++
+			if i > 5 {
+				t.Fatal("expected venture deletion to complete")
+			}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i != 2 {
+			t.Fatalf("expected 2 incomplete executions for 5 invites in chunks of 2, got %d", i)
+		}
+	}
+
+	{
+		var cou int
+		for {
+			tsk, err := res.Search()
+			if engine.IsNoTask(err) {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if inv.Filter(tsk) {
+				err = inv.Ensure(tsk)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				cou++
+			}
+
+			err = res.Delete(tsk)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if cou != 5 {
+			t.Fatalf("expected 5 invite deletions, got %d", cou)
+		}
+	}
+
+	{
+		str, err := red.Sorted().Search().Order(ink, 0, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(str) != 0 {
+			t.Fatalf("expected no invites, got %d", len(str))
+		}
+	}
+
+	{
+		exi, err := red.Simple().Exists().Element(cursor.Key(ink))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if exi {
+			t.Fatal("expected invite cursor to be deleted")
+		}
+	}
+
+	{
+		_, err := red.Simple().Search().Value(key.Venture(met).Elem())
+		if !simple.IsNotFound(err) {
+			t.Fatalf("expected venture to be deleted, got %#v", err)
+		}
+	}
+}
+
+// Test_Handler_Ensure_DryRun verifies that a dry run completes within a single
+// execution, even for more invites than fit into a single chunk, logs every
+// task and key a real run would touch and does not mutate any state.
+func Test_Handler_Ensure_DryRun(t *testing.T) {
+	red, tra := redistest.New(t)
+
+	log := &recorder{}
+
+	res, err := engine.New(engine.Config{Logger: log, Metric: metric.New(), Redigo: red})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ven := testHandler(t, red, tra, log, res)
+
+	met := map[string]string{
+		metadata.VentureID: "1",
+	}
+
+	seed(t, tra, met, 5, []string{"10", "11"})
+
+	{
+		tsk := &task.Task{
+			Obj: task.TaskObj{
+				Metadata: map[string]string{
+					metadata.TaskAction:   "delete",
+					metadata.TaskResource: "venture",
+					metadata.VentureID:    met[metadata.VentureID],
+
+					handler.DryRun: "true",
+				},
+			},
+		}
+
+		err = ven.Ensure(tsk)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	{
+		for j := 1; j <= 5; j++ {
+			if !log.contains("invite", strconv.Itoa(j)) {
+				t.Fatalf("expected invite %d to be logged", j)
+			}
+		}
+
+		k := []string{
+			key.Venture(met).Elem(),
+			cursor.Key(key.Invite(met).List()),
+			readmarker.Key("10", met[metadata.VentureID]),
+			readmarker.Key("11", met[metadata.VentureID]),
+		}
+
+		for _, l := range k {
+			if !log.contains("key", l) {
+				t.Fatalf("expected key %s to be logged", l)
+			}
+		}
+	}
+
+	{
+		_, err := res.Search()
+		if !engine.IsNoTask(err) {
+			t.Fatalf("expected no task, got %#v", err)
+		}
+	}
+
+	{
+		str, err := red.Sorted().Search().Order(key.Invite(met).List(), 0, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(str) != 5 {
+			t.Fatalf("expected 5 invites, got %d", len(str))
+		}
+	}
+
+	{
+		_, err := red.Simple().Search().Value(key.Venture(met).Elem())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// recorder is a logger keeping all logged key value pairs, so that tests can
+// verify what dry runs report.
+type recorder struct {
+	mutex sync.Mutex
+	lines [][]interface{}
+}
+
+func (r *recorder) Log(ctx context.Context, v ...interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.lines = append(r.lines, v)
+}
+
+// contains returns whether any logged line holds the value v for the key k.
+func (r *recorder) contains(k string, v string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, l := range r.lines {
+		for i := 0; i+1 < len(l); i += 2 {
+			if l[i] == k && l[i+1] == v {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// seed creates the venture given by met, with n invites and a member role
+// for each of the given subjects.
+func seed(t *testing.T, tra transaction.Interface, met map[string]string, n int, sub []string) {
+	v := &schema.Venture{
+		Obj: schema.VentureObj{
+			Metadata: met,
+		},
+	}
+
+	byt, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ops := []transaction.Operation{
+		transaction.SimpleCreate(key.Venture(met).Elem(), string(byt)),
+	}
+
+	for j := 1; j <= n; j++ {
+		i := &schema.Invite{
+			Obj: schema.InviteObj{
+				Metadata: map[string]string{
+					metadata.InviteID:  strconv.Itoa(j),
+					metadata.VentureID: met[metadata.VentureID],
+				},
+			},
+		}
+
+		byt, err := json.Marshal(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ops = append(ops, transaction.SortedCreate(key.Invite(met).List(), string(byt), float64(j)))
+	}
+
+	for j, s := range sub {
+		rol := map[string]string{
+			metadata.ResourceKind: "venture",
+			metadata.RoleID:       strconv.Itoa(j + 1),
+			metadata.RoleKind:     "member",
+			metadata.SubjectID:    s,
+			metadata.VentureID:    met[metadata.VentureID],
+		}
+
+		byt, err := json.Marshal(&schema.Role{Obj: schema.RoleObj{Metadata: rol}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ops = append(ops, transaction.SortedCreate(key.Role(rol).List(), string(byt), key.Role(rol).ID().F()))
+	}
+
+	err = tra.Execute(ops...)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testHandler returns a venture delete handler processing invites in chunks
+// of 2.
+func testHandler(t *testing.T, red redigo.Interface, tra transaction.Interface, log logger.Interface, res rescue.Interface) *Handler {
+	blo, err := local.NewBlob(local.BlobConfig{Directory: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	arc, err := archive.New(archive.Config{Blob: blo, Transaction: tra})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cur, err := cursor.New(cursor.Config{Redigo: red, Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tom, err := tombstone.New(tombstone.Config{Redigo: red, Transaction: tra})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := HandlerConfig{
+		Archive:     arc,
+		Cursor:      cur,
+		Logger:      log,
+		Redigo:      red,
+		Rescue:      res,
+		Tombstone:   tom,
+		Transaction: tra,
+
+		Timeout: time.Minute,
+	}
+
+	ven, err := NewHandler(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ven
+}
@@ -0,0 +1,466 @@
+package venturetransfer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/key"
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/venturemark/apicommon/pkg/schema"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/redigo/pkg/simple"
+	"github.com/xh3b4sd/rescue/pkg/task"
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/handler"
+	"github.com/venturemark/apiworker/pkg/index"
+	"github.com/venturemark/apiworker/pkg/mailer"
+	"github.com/venturemark/apiworker/pkg/preference"
+	"github.com/venturemark/apiworker/pkg/render"
+	"github.com/venturemark/apiworker/pkg/transaction"
+	"github.com/venturemark/apiworker/pkg/user"
+)
+
+const (
+	// Target is the task metadata key holding the ID of the user the venture
+	// is transferred to.
+	Target = "task.venturemark.co/target"
+)
+
+const (
+	// MailPrefix is the key prefix under which the emails of venture
+	// transfers are recorded until they got sent. The full key is the prefix
+	// followed by the venture ID and the ID of the user the venture got
+	// transferred to, see Mail. The key is a sorted set holding the kind and
+	// the recipient ID of every email not sent yet, so that transfers of the
+	// same venture following each other quickly do not overwrite each other's
+	// emails.
+	MailPrefix = "apiworker.venturemark.co:vtr"
+)
+
+const (
+	// mailReceived is the kind of email informing the new owner.
+	mailReceived = "received"
+	// mailSent is the kind of email informing the previous owner.
+	mailSent = "sent"
+)
+
+const (
+	roleMember = "member"
+	roleOwner  = "owner"
+)
+
+type HandlerConfig struct {
+	Logger      logger.Interface
+	Mailer      mailer.Interface
+	Preference  *preference.Store
+	Redigo      redigo.Interface
+	Render      *render.Render
+	Transaction transaction.Interface
+
+	DryRun  bool
+	Timeout time.Duration
+}
+
+type Handler struct {
+	logger      logger.Interface
+	mailer      mailer.Interface
+	preference  *preference.Store
+	redigo      redigo.Interface
+	render      *render.Render
+	transaction transaction.Interface
+
+	dryRun  bool
+	timeout time.Duration
+}
+
+func NewHandler(c HandlerConfig) (*Handler, error) {
+	if c.Logger == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", c)
+	}
+	if c.Mailer == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Mailer must not be empty", c)
+	}
+	if c.Preference == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Preference must not be empty", c)
+	}
+	if c.Redigo == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Redigo must not be empty", c)
+	}
+	if c.Render == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Render must not be empty", c)
+	}
+	if c.Transaction == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Transaction must not be empty", c)
+	}
+
+	if c.Timeout == 0 {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Timeout must not be empty", c)
+	}
+
+	h := &Handler{
+		logger:      c.Logger,
+		mailer:      c.Mailer,
+		preference:  c.Preference,
+		redigo:      c.Redigo,
+		render:      c.Render,
+		transaction: c.Transaction,
+
+		dryRun:  c.DryRun,
+		timeout: c.Timeout,
+	}
+
+	return h, nil
+}
+
+// Ensure transfers the ownership of a venture to another user. The owner role
+// of the venture is rewritten to point to the new owner, while the previous
+// owner is kept as member of the venture. A role the new owner might have had
+// before is removed. All roles and subject associations are changed within a
+// single transaction. Both parties are informed via email afterwards. The
+// emails to send are recorded within the same transaction, so that emails
+// failing to be sent are retried once the task gets rescheduled, even though
+// the transfer itself succeeded already.
+func (h *Handler) Ensure(tsk *task.Task) error {
+	var err error
+
+	h.logger.Log(context.Background(), "level", "info", "message", "transferring venture resource")
+
+	var ven *schema.Venture
+	{
+		ven, err = h.searchVenture(tsk.Obj.Metadata)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if ven == nil {
+			h.logger.Log(context.Background(), "level", "warning", "message", "skipping venture transfer", "reason", "venture does not exist")
+			return nil
+		}
+	}
+
+	var tar *schema.User
+	{
+		tar, err = user.Search(h.redigo, tsk.Obj.Metadata[Target])
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if tar == nil {
+			h.logger.Log(context.Background(), "level", "warning", "message", "skipping venture transfer", "reason", "target user does not exist", "target", tsk.Obj.Metadata[Target])
+			return nil
+		}
+	}
+
+	{
+		own, _, err := h.searchRole(tsk.Obj.Metadata, tsk.Obj.Metadata[Target])
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		if own == nil {
+			h.logger.Log(context.Background(), "level", "warning", "message", "skipping venture transfer", "reason", "venture has no owner")
+			return nil
+		}
+	}
+
+	if h.isDryRun(tsk) {
+		h.logger.Log(context.Background(), "level", "info", "message", "skipping venture transfer in dry run", "venture", tsk.Obj.Metadata[metadata.VentureID], "target", tsk.Obj.Metadata[Target])
+		return nil
+	}
+
+	// The roles of the venture are watched and read again, so that the
+	// transfer is always based on their current state. The target user being
+	// the owner already means that the transfer got executed before, e.g. in
+	// case the task got rescheduled after sending the emails failed. Only
+	// the emails not sent yet are left to be sent then.
+	err = h.transaction.Watch([]string{roleKey(tsk.Obj.Metadata)}, func() ([]transaction.Operation, error) {
+		own, pre, err := h.searchRole(tsk.Obj.Metadata, tsk.Obj.Metadata[Target])
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		if own == nil || own.Obj.Metadata[metadata.SubjectID] == tsk.Obj.Metadata[Target] {
+			return nil, nil
+		}
+
+		ops, err := h.transferRole(own, pre, tsk.Obj.Metadata[Target])
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		return ops, nil
+	})
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	err = h.sendMail(tsk, ven, tar)
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	h.logger.Log(context.Background(), "level", "info", "message", "transferred venture resource")
+
+	return nil
+}
+
+func (h *Handler) Filter(tsk *task.Task) bool {
+	met := map[string]string{
+		metadata.TaskAction:   "transfer",
+		metadata.TaskResource: "venture",
+	}
+
+	return metadata.Contains(tsk.Obj.Metadata, met)
+}
+
+func (h *Handler) isDryRun(tsk *task.Task) bool {
+	return h.dryRun || handler.IsDryRun(tsk)
+}
+
+// searchRole returns the owner role of the venture described by the given
+// metadata, as well as the role the given user has within the venture, if
+// any.
+func (h *Handler) searchRole(met map[string]string, uid string) (*schema.Role, *schema.Role, error) {
+	str, err := h.redigo.Sorted().Search().Order(roleKey(met), 0, -1)
+	if err != nil {
+		return nil, nil, tracer.Mask(err)
+	}
+
+	var own *schema.Role
+	var pre *schema.Role
+	for _, s := range str {
+		r := &schema.Role{}
+		err = json.Unmarshal([]byte(s), r)
+		if err != nil {
+			return nil, nil, tracer.Mask(err)
+		}
+
+		if own == nil && r.Obj.Metadata[metadata.RoleKind] == roleOwner {
+			own = r
+		} else if r.Obj.Metadata[metadata.SubjectID] == uid {
+			pre = r
+		}
+	}
+
+	return own, pre, nil
+}
+
+func (h *Handler) searchVenture(met map[string]string) (*schema.Venture, error) {
+	val, err := h.redigo.Simple().Search().Value(key.Venture(met).Elem())
+	if simple.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	v := &schema.Venture{}
+	err = json.Unmarshal([]byte(val), v)
+	if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	return v, nil
+}
+
+// sendMail sends the emails recorded by the transfer of the given venture to
+// the given target user. Every email is removed from the record once it got
+// sent, so that no email is sent twice, unless removing it fails.
+func (h *Handler) sendMail(tsk *task.Task, ven *schema.Venture, tar *schema.User) error {
+	var k string
+	{
+		k = Mail(tsk.Obj.Metadata[metadata.VentureID], tsk.Obj.Metadata[Target])
+	}
+
+	var str []string
+	{
+		l, err := h.redigo.Sorted().Search().Order(k, 0, -1)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		str = l
+	}
+
+	for _, s := range str {
+		kin, uid := split(s)
+
+		var use *schema.User
+		{
+			var err error
+			use, err = user.Search(h.redigo, uid)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+		}
+
+		// The recipient might have been deleted in the meantime.
+		if use != nil {
+			pre, err := h.preference.Search(uid)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			data := templateTransfer{
+				Owner:   tar.Obj.Property.Name,
+				Venture: ven.Obj.Property.Name,
+			}
+
+			content, err := h.render.Execute(fmt.Sprintf("transfer-%s", kin), pre.Locale, data)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+
+			email := mailer.Message{
+				From:    "notifications@venturemark.co",
+				To:      use.Obj.Property.Mail,
+				Subject: content.Subject,
+				HTML:    content.HTML,
+				Text:    content.Text,
+				Data:    data,
+			}
+
+			_, err = h.mailer.Send(email)
+			if err != nil {
+				return tracer.Mask(err)
+			}
+		}
+
+		{
+			err := h.transaction.Execute(transaction.SortedRemove(k, s))
+			if err != nil {
+				return tracer.Mask(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// transferRole returns the operations rewriting the given owner role to point
+// to the given user and creating a member role for the previous owner. The
+// given previous role of the new owner is removed, if any. The emails informing
+// both parties are recorded within the same transaction, see sendMail.
+func (h *Handler) transferRole(own *schema.Role, pre *schema.Role, uid string) ([]transaction.Operation, error) {
+	var ops []transaction.Operation
+
+	{
+		k := Mail(own.Obj.Metadata[metadata.VentureID], uid)
+
+		ops = append(ops, transaction.SortedCreate(k, mail(mailReceived, uid), 0))
+		ops = append(ops, transaction.SortedCreate(k, mail(mailSent, own.Obj.Metadata[metadata.SubjectID]), 1))
+	}
+
+	if pre != nil {
+		rok := key.Role(pre.Obj.Metadata)
+
+		ops = append(ops, transaction.SortedDelete(rok.List(), rok.ID().F()))
+		ops = append(ops, transaction.SortedRemove(subject(pre), association(pre)))
+	}
+
+	var mem *schema.Role
+	{
+		mem = &schema.Role{}
+		mem.Obj.Metadata = map[string]string{}
+
+		for k, v := range own.Obj.Metadata {
+			mem.Obj.Metadata[k] = v
+		}
+
+		mem.Obj.Metadata[metadata.RoleID] = strconv.FormatInt(time.Now().UTC().UnixNano(), 10)
+		mem.Obj.Metadata[metadata.RoleKind] = roleMember
+
+		byt, err := json.Marshal(mem)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		rok := key.Role(mem.Obj.Metadata)
+
+		ops = append(ops, transaction.SortedCreate(rok.List(), string(byt), rok.ID().F()))
+		ops = append(ops, transaction.SortedCreate(subject(mem), association(mem), rok.ID().F()))
+		ops = append(ops, index.Create(subject(mem)))
+	}
+
+	{
+		rok := key.Role(own.Obj.Metadata)
+
+		ops = append(ops, transaction.SortedRemove(subject(own), association(own)))
+		ops = append(ops, transaction.SortedDelete(rok.List(), rok.ID().F()))
+
+		own.Obj.Metadata[metadata.SubjectID] = uid
+
+		byt, err := json.Marshal(own)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		ops = append(ops, transaction.SortedCreate(rok.List(), string(byt), rok.ID().F()))
+		ops = append(ops, transaction.SortedCreate(subject(own), association(own), rok.ID().F()))
+		ops = append(ops, index.Create(subject(own)))
+	}
+
+	return ops, nil
+}
+
+// association returns the element by which the subject set of the given
+// role's subject references the given role.
+func association(rol *schema.Role) string {
+	return fmt.Sprintf("%s:%s", key.Role(rol.Obj.Metadata).List(), rol.Obj.Metadata[metadata.RoleID])
+}
+
+// roleKey returns the key of the roles of the venture described by the given
+// metadata.
+func roleKey(met map[string]string) string {
+	m := map[string]string{
+		metadata.ResourceKind: "venture",
+		metadata.VentureID:    met[metadata.VentureID],
+	}
+
+	return key.Role(m).List()
+}
+
+// split returns the kind and the recipient ID of the given recorded email.
+func split(s string) (string, string) {
+	i := strings.Index(s, ":")
+	if i == -1 {
+		return s, ""
+	}
+
+	return s[:i], s[i+1:]
+}
+
+// subject returns the subject set of the given role's subject.
+func subject(rol *schema.Role) string {
+	met := map[string]string{
+		metadata.ResourceKind: "venture",
+		metadata.SubjectID:    rol.Obj.Metadata[metadata.SubjectID],
+	}
+
+	return key.Subject(met).Elem()
+}
+
+// Mail returns the sorted set recording the emails of the transfer of the
+// venture with the given ID to the user with the given ID.
+func Mail(vei string, tar string) string {
+	return fmt.Sprintf("%s:%s:%s", MailPrefix, vei, tar)
+}
+
+// mail returns the element recording the email of the given kind for the
+// recipient with the given ID.
+func mail(kin string, uid string) string {
+	return fmt.Sprintf("%s:%s", kin, uid)
+}
+
+// templateTransfer is the data transfer emails are rendered with.
+type templateTransfer struct {
+	// Owner is the name of the new owner.
+	Owner string
+	// Venture is the name of the transferred venture.
+	Venture string
+}
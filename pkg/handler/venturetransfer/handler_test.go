@@ -0,0 +1,223 @@
+package venturetransfer
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/key"
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/venturemark/apicommon/pkg/schema"
+	"github.com/xh3b4sd/logger"
+	"github.com/xh3b4sd/rescue/pkg/task"
+
+	"github.com/venturemark/apiworker/pkg/index"
+	"github.com/venturemark/apiworker/pkg/mailer"
+	"github.com/venturemark/apiworker/pkg/preference"
+	"github.com/venturemark/apiworker/pkg/redistest"
+	"github.com/venturemark/apiworker/pkg/render"
+	"github.com/venturemark/apiworker/pkg/transaction"
+	"github.com/venturemark/apiworker/pkg/user"
+)
+
+// flaky is a mailer failing the given number of times before it starts to
+// deliver messages.
+type flaky struct {
+	fail int
+	sent []mailer.Message
+}
+
+func (f *flaky) Send(msg mailer.Message) (mailer.Result, error) {
+	if f.fail > 0 {
+		f.fail--
+		return mailer.Result{}, errors.New("test error")
+	}
+
+	f.sent = append(f.sent, msg)
+
+	return mailer.Result{}, nil
+}
+
+// Test_Handler_Ensure_Mail verifies that the emails of a transfer are sent
+// once the task gets retried, even though the roles got transferred already by
+// the failed execution, and that the subject associations of the rewritten
+// roles are added to the subject index.
+func Test_Handler_Ensure_Mail(t *testing.T) {
+	red, tra := redistest.New(t)
+
+	var mai *flaky
+	var han *Handler
+	{
+		log, err := logger.New(logger.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pre, err := preference.New(preference.Config{Redigo: red})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ren, err := render.New(render.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mai = &flaky{fail: 1}
+
+		c := HandlerConfig{
+			Logger:      log,
+			Mailer:      mai,
+			Preference:  pre,
+			Redigo:      red,
+			Render:      ren,
+			Transaction: tra,
+
+			Timeout: time.Minute,
+		}
+
+		han, err = NewHandler(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	{
+		ven := &schema.Venture{
+			Obj: schema.VentureObj{
+				Metadata: map[string]string{metadata.VentureID: "1"},
+				Property: schema.VentureObjProperty{Name: "Acme"},
+			},
+		}
+
+		own := &schema.User{
+			Obj: schema.UserObj{
+				Metadata: map[string]string{metadata.UserID: "2"},
+				Property: schema.UserObjProperty{Mail: "owner@example.com", Name: "Owner"},
+			},
+		}
+
+		tar := &schema.User{
+			Obj: schema.UserObj{
+				Metadata: map[string]string{metadata.UserID: "3"},
+				Property: schema.UserObjProperty{Mail: "target@example.com", Name: "Target"},
+			},
+		}
+
+		rol := &schema.Role{
+			Obj: schema.RoleObj{
+				Metadata: map[string]string{
+					metadata.ResourceKind: "venture",
+					metadata.RoleID:       "4",
+					metadata.RoleKind:     roleOwner,
+					metadata.SubjectID:    "2",
+					metadata.VentureID:    "1",
+				},
+			},
+		}
+
+		var val []string
+		for _, v := range []interface{}{ven, own, tar, rol} {
+			byt, err := json.Marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			val = append(val, string(byt))
+		}
+
+		rok := key.Role(rol.Obj.Metadata)
+
+		err := tra.Execute(
+			transaction.SimpleCreate(key.Venture(ven.Obj.Metadata).Elem(), val[0]),
+			transaction.SimpleCreate(user.Key("2"), val[1]),
+			transaction.SimpleCreate(user.Key("3"), val[2]),
+			transaction.SortedCreate(rok.List(), val[3], rok.ID().F()),
+			transaction.SortedCreate(subject(rol), association(rol), rok.ID().F()),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tsk *task.Task
+	{
+		tsk = &task.Task{
+			Obj: task.TaskObj{
+				Metadata: map[string]string{
+					metadata.TaskAction:   "transfer",
+					metadata.TaskResource: "venture",
+					metadata.VentureID:    "1",
+					Target:                "3",
+				},
+			},
+		}
+	}
+
+	{
+		err := han.Ensure(tsk)
+		if err == nil {
+			t.Fatal("expected first execution to fail")
+		}
+
+		own, _, err := han.searchRole(tsk.Obj.Metadata, "3")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if own.Obj.Metadata[metadata.SubjectID] != "3" {
+			t.Fatalf("expected ownership to be transferred by the first execution")
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		err := han.Ensure(tsk)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	{
+		if len(mai.sent) != 2 {
+			t.Fatalf("expected 2 emails, got %d", len(mai.sent))
+		}
+
+		if mai.sent[0].To != "target@example.com" {
+			t.Fatalf("expected first email to target, got %q", mai.sent[0].To)
+		}
+		if mai.sent[1].To != "owner@example.com" {
+			t.Fatalf("expected second email to previous owner, got %q", mai.sent[1].To)
+		}
+		if mai.sent[1].Subject != "You transferred the ownership of Acme" {
+			t.Fatalf("expected rendered subject, got %q", mai.sent[1].Subject)
+		}
+	}
+
+	{
+		exi, err := red.Sorted().Exists().Score(Mail("1", "3"), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if exi {
+			t.Fatal("expected recorded emails to be removed")
+		}
+	}
+
+	for _, sui := range []string{"2", "3"} {
+		m := map[string]string{
+			metadata.ResourceKind: "venture",
+			metadata.SubjectID:    sui,
+		}
+
+		str, err := red.Sorted().Search().Order(index.Key(sui), 0, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(str) != 1 || str[0] != key.Subject(m).Elem() {
+			t.Fatalf("expected subject association of %s to be indexed, got %v", sui, str)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package index
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/venturemark/apiworker/pkg/transaction"
+)
+
+const (
+	// Marker is the simple key holding the time at which the subject index
+	// got backfilled the last time. Its absence indicates that the index
+	// cannot be relied on yet.
+	Marker = "apiworker.venturemark.co:idx"
+	// Prefix is the key prefix of the sorted sets indexing the keys that
+	// reference a subject. Note that the prefix must not contain the subject
+	// pattern itself, so that the index does not end up indexing itself.
+	Prefix = "apiworker.venturemark.co:idx"
+)
+
+// Key returns the sorted set indexing all keys which reference the subject
+// sui.
+func Key(sui string) string {
+	return fmt.Sprintf("%s:%s", Prefix, sui)
+}
+
+// Create returns the operation adding the given key to the index of the
+// subject it references. Handlers creating keys which reference subjects
+// execute it within the same transaction, so that the index does not only
+// learn about these keys with the next backfill.
+func Create(k string) transaction.Operation {
+	return transaction.SortedCreate(Key(Subject(k)), k, 0)
+}
+
+// Subject returns the subject ID referenced by the given key, if any. Keys
+// reference subjects by a "sub:<id>" segment.
+func Subject(k string) string {
+	i := strings.Index(k, "sub:")
+	if i == -1 {
+		return ""
+	}
+
+	s := k[i+len("sub:"):]
+
+	j := strings.Index(s, ":")
+	if j != -1 {
+		s = s[:j]
+	}
+
+	return s
+}
@@ -0,0 +1,23 @@
+package capture
+
+import (
+	"errors"
+
+	"github.com/xh3b4sd/tracer"
+)
+
+var invalidConfigError = &tracer.Error{
+	Kind: "invalidConfigError",
+}
+
+func IsInvalidConfig(err error) bool {
+	return errors.Is(err, invalidConfigError)
+}
+
+var notFoundError = &tracer.Error{
+	Kind: "notFoundError",
+}
+
+func IsNotFound(err error) bool {
+	return errors.Is(err, notFoundError)
+}
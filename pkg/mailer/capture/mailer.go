@@ -0,0 +1,190 @@
+package capture
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/mailer"
+)
+
+type MailerConfig struct {
+	// Directory is the directory captured messages are persisted in, one JSON
+	// file per message. Messages are kept in memory if Directory is empty.
+	Directory string
+	// Limit is the maximum number of messages kept in memory. The oldest
+	// messages are dropped first. Limit does not apply to Directory.
+	Limit int
+}
+
+// Entry is a single captured message.
+type Entry struct {
+	Captured time.Time      `json:"captured"`
+	ID       string         `json:"id"`
+	Message  mailer.Message `json:"message"`
+}
+
+// Mailer does not deliver messages at all, but captures them so that they can
+// be inspected later, e.g. via the inbox of the metrics server. It is meant
+// for testing changes to emails locally without sending them to real
+// recipients.
+type Mailer struct {
+	directory string
+	entry     []Entry
+	limit     int
+	mutex     sync.Mutex
+}
+
+func NewMailer(config MailerConfig) (*Mailer, error) {
+	if config.Directory == "" && config.Limit <= 0 {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Limit must be greater than 0", config)
+	}
+
+	m := &Mailer{
+		directory: config.Directory,
+		entry:     nil,
+		limit:     config.Limit,
+		mutex:     sync.Mutex{},
+	}
+
+	return m, nil
+}
+
+// List returns all captured messages, the most recent message first.
+func (m *Mailer) List() ([]Entry, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var ent []Entry
+
+	if m.directory == "" {
+		for i := len(m.entry) - 1; i >= 0; i-- {
+			ent = append(ent, m.entry[i])
+		}
+
+		return ent, nil
+	}
+
+	fil, err := os.ReadDir(m.directory)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	for _, f := range fil {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+
+		e, err := m.read(strings.TrimSuffix(f.Name(), ".json"))
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		ent = append(ent, e)
+	}
+
+	sort.Slice(ent, func(i, j int) bool {
+		return ent[i].Captured.After(ent[j].Captured)
+	})
+
+	return ent, nil
+}
+
+// Search returns the captured message with the given ID.
+func (m *Mailer) Search(id string) (Entry, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.directory == "" {
+		for _, e := range m.entry {
+			if e.ID == id {
+				return e, nil
+			}
+		}
+
+		return Entry{}, tracer.Maskf(notFoundError, "%s", id)
+	}
+
+	e, err := m.read(id)
+	if err != nil {
+		return Entry{}, tracer.Mask(err)
+	}
+
+	return e, nil
+}
+
+func (m *Mailer) Send(msg mailer.Message) (mailer.Result, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var e Entry
+	{
+		now := time.Now().UTC()
+
+		e = Entry{
+			Captured: now,
+			ID:       strconv.FormatInt(now.UnixNano(), 10),
+			Message:  msg,
+		}
+	}
+
+	if m.directory == "" {
+		m.entry = append(m.entry, e)
+
+		if len(m.entry) > m.limit {
+			m.entry = m.entry[len(m.entry)-m.limit:]
+		}
+
+		return mailer.Result{ID: e.ID}, nil
+	}
+
+	byt, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return mailer.Result{}, tracer.Mask(err)
+	}
+
+	err = os.MkdirAll(m.directory, 0700)
+	if err != nil {
+		return mailer.Result{}, tracer.Mask(err)
+	}
+
+	err = os.WriteFile(filepath.Join(m.directory, e.ID+".json"), byt, 0600)
+	if err != nil {
+		return mailer.Result{}, tracer.Mask(err)
+	}
+
+	return mailer.Result{ID: e.ID}, nil
+}
+
+func (m *Mailer) read(id string) (Entry, error) {
+	// IDs are unix nano timestamps. Anything else is rejected so that IDs
+	// given by the inbox cannot be used to read arbitrary files.
+	_, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return Entry{}, tracer.Maskf(notFoundError, "%s", id)
+	}
+
+	byt, err := os.ReadFile(filepath.Join(m.directory, id+".json"))
+	if os.IsNotExist(err) {
+		return Entry{}, tracer.Maskf(notFoundError, "%s", id)
+	} else if err != nil {
+		return Entry{}, tracer.Mask(err)
+	}
+
+	var e Entry
+	err = json.Unmarshal(byt, &e)
+	if err != nil {
+		return Entry{}, tracer.Mask(err)
+	}
+
+	return e, nil
+}
@@ -0,0 +1,28 @@
+package mailer
+
+import (
+	"errors"
+
+	"github.com/xh3b4sd/tracer"
+)
+
+// DeliveryError is returned by backends which rejected a message for good.
+// Sending the same message again is not expected to succeed.
+var DeliveryError = &tracer.Error{
+	Kind: "deliveryError",
+}
+
+func IsDelivery(err error) bool {
+	return errors.Is(err, DeliveryError)
+}
+
+// TemporaryError is returned by backends which could not send a message due
+// to temporary conditions, e.g. network failures. Sending the same message
+// again may succeed later.
+var TemporaryError = &tracer.Error{
+	Kind: "temporaryError",
+}
+
+func IsTemporary(err error) bool {
+	return errors.Is(err, TemporaryError)
+}
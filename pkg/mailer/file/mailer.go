@@ -0,0 +1,74 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/mailer"
+)
+
+type MailerConfig struct {
+	// Directory is the directory messages are written to, one JSON file per
+	// message. Messages are written to stdout if Directory is empty.
+	Directory string
+}
+
+// Mailer does not deliver messages at all, but writes them to local files or
+// stdout instead. It is meant for running the worker in development and CI
+// without any mail provider credentials.
+type Mailer struct {
+	directory string
+	mutex     sync.Mutex
+	writer    io.Writer
+}
+
+func NewMailer(config MailerConfig) (*Mailer, error) {
+	m := &Mailer{
+		directory: config.Directory,
+		mutex:     sync.Mutex{},
+		writer:    os.Stdout,
+	}
+
+	return m, nil
+}
+
+func (m *Mailer) Send(msg mailer.Message) (mailer.Result, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var id string
+	{
+		id = fmt.Sprintf("%d", time.Now().UTC().UnixNano())
+	}
+
+	byt, err := json.MarshalIndent(msg, "", "  ")
+	if err != nil {
+		return mailer.Result{}, tracer.Mask(err)
+	}
+
+	if m.directory == "" {
+		_, err = fmt.Fprintf(m.writer, "%s\n", byt)
+		if err != nil {
+			return mailer.Result{}, tracer.Mask(err)
+		}
+	} else {
+		err = os.MkdirAll(m.directory, 0700)
+		if err != nil {
+			return mailer.Result{}, tracer.Mask(err)
+		}
+
+		err = os.WriteFile(filepath.Join(m.directory, id+".json"), byt, 0600)
+		if err != nil {
+			return mailer.Result{}, tracer.Mask(err)
+		}
+	}
+
+	return mailer.Result{ID: id}, nil
+}
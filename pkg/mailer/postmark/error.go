@@ -0,0 +1,23 @@
+package postmark
+
+import (
+	"errors"
+
+	"github.com/xh3b4sd/tracer"
+)
+
+var invalidConfigError = &tracer.Error{
+	Kind: "invalidConfigError",
+}
+
+func IsInvalidConfig(err error) bool {
+	return errors.Is(err, invalidConfigError)
+}
+
+var notActivatableError = &tracer.Error{
+	Kind: "notActivatableError",
+}
+
+func IsNotActivatable(err error) bool {
+	return errors.Is(err, notActivatableError)
+}
@@ -0,0 +1,132 @@
+package postmark
+
+import (
+	"github.com/keighl/postmark"
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/mailer"
+)
+
+const (
+	// codeInactiveRecipient is the error code postmark responds with when
+	// the recipient is marked inactive, e.g. due to hard bounces.
+	codeInactiveRecipient = 406
+	// pageBounces is the maximum number of bounces postmark returns per
+	// request.
+	pageBounces = 500
+)
+
+type MailerConfig struct {
+	TokenAccount string
+	TokenServer  string
+}
+
+type Mailer struct {
+	client *postmark.Client
+}
+
+func NewMailer(config MailerConfig) (*Mailer, error) {
+	if config.TokenAccount == "" {
+		return nil, tracer.Maskf(invalidConfigError, "%T.TokenAccount must not be empty", config)
+	}
+	if config.TokenServer == "" {
+		return nil, tracer.Maskf(invalidConfigError, "%T.TokenServer must not be empty", config)
+	}
+
+	m := &Mailer{
+		client: postmark.NewClient(config.TokenServer, config.TokenAccount),
+	}
+
+	return m, nil
+}
+
+// Activate reactivates the given address by activating all of its inactive
+// bounces. Postmark marks addresses inactive on hard bounces and spam
+// complaints and rejects all messages sent to them afterwards. Spam complaints
+// cannot be activated via the API, in which case an error matched by
+// IsNotActivatable is returned before any bounce is activated. The number of
+// activated bounces is returned.
+func (m *Mailer) Activate(add string) (int, error) {
+	var ids []int64
+	{
+		opt := map[string]interface{}{
+			"emailFilter": add,
+			"inactive":    true,
+		}
+
+		for off := int64(0); ; off += pageBounces {
+			bou, _, err := m.client.GetBounces(pageBounces, off, opt)
+			if err != nil {
+				return 0, tracer.Mask(err)
+			}
+
+			for _, b := range bou {
+				if !b.Inactive {
+					continue
+				}
+				if !b.CanActivate {
+					return 0, tracer.Maskf(notActivatableError, "bounce %d of type %s cannot be activated", b.ID, b.Type)
+				}
+
+				ids = append(ids, b.ID)
+			}
+
+			if len(bou) < pageBounces {
+				break
+			}
+		}
+	}
+
+	for _, i := range ids {
+		_, _, err := m.client.ActivateBounce(i)
+		if err != nil {
+			return 0, tracer.Mask(err)
+		}
+	}
+
+	return len(ids), nil
+}
+
+func (m *Mailer) Send(msg mailer.Message) (mailer.Result, error) {
+	var hea []postmark.Header
+	for k, v := range msg.Header {
+		hea = append(hea, postmark.Header{Name: k, Value: v})
+	}
+
+	var err error
+	var res postmark.EmailResponse
+	if msg.Template != "" {
+		e := postmark.TemplatedEmail{
+			TemplateAlias: msg.Template,
+			TemplateModel: msg.Model,
+			From:          msg.From,
+			To:            msg.To,
+			Headers:       hea,
+			TrackOpens:    msg.TrackOpens,
+		}
+
+		res, err = m.client.SendTemplatedEmail(e)
+	} else {
+		e := postmark.Email{
+			From:       msg.From,
+			To:         msg.To,
+			Subject:    msg.Subject,
+			HtmlBody:   msg.HTML,
+			TextBody:   msg.Text,
+			Headers:    hea,
+			TrackOpens: msg.TrackOpens,
+		}
+
+		res, err = m.client.SendEmail(e)
+	}
+
+	if err != nil {
+		return mailer.Result{}, tracer.Maskf(mailer.TemporaryError, "%s", err.Error())
+	} else if res.ErrorCode == codeInactiveRecipient {
+		return mailer.Result{ID: res.MessageID, Inactive: true}, nil
+	} else if res.Message != "OK" {
+		return mailer.Result{}, tracer.Maskf(mailer.DeliveryError, "%s", res.Message)
+	}
+
+	return mailer.Result{ID: res.MessageID}, nil
+}
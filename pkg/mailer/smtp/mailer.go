@@ -0,0 +1,201 @@
+package smtp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strings"
+
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/mailer"
+)
+
+type MailerConfig struct {
+	// Address is the host and port of the SMTP server.
+	Address  string
+	Password string
+	Username string
+}
+
+type Mailer struct {
+	address  string
+	password string
+	username string
+}
+
+func NewMailer(config MailerConfig) (*Mailer, error) {
+	if config.Address == "" {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Address must not be empty", config)
+	}
+
+	m := &Mailer{
+		address:  config.Address,
+		password: config.Password,
+		username: config.Username,
+	}
+
+	return m, nil
+}
+
+// Send delivers the given message via SMTP. SMTP servers do not manage
+// templates, so templated messages are sent with the template alias as
+// subject and the template model as JSON body, which is good enough for
+// development and testing.
+func (m *Mailer) Send(msg mailer.Message) (mailer.Result, error) {
+	var byt []byte
+	{
+		b, err := m.message(msg)
+		if err != nil {
+			return mailer.Result{}, tracer.Mask(err)
+		}
+
+		byt = b
+	}
+
+	var aut smtp.Auth
+	if m.username != "" {
+		h, _, err := net.SplitHostPort(m.address)
+		if err != nil {
+			return mailer.Result{}, tracer.Mask(err)
+		}
+
+		aut = smtp.PlainAuth("", m.username, m.password, h)
+	}
+
+	err := smtp.SendMail(m.address, aut, msg.From, []string{msg.To}, byt)
+	if e, ok := err.(*textproto.Error); ok && e.Code >= 500 {
+		return mailer.Result{}, tracer.Maskf(mailer.DeliveryError, "%s", e.Error())
+	} else if err != nil {
+		return mailer.Result{}, tracer.Maskf(mailer.TemporaryError, "%s", err.Error())
+	}
+
+	return mailer.Result{}, nil
+}
+
+func (m *Mailer) message(msg mailer.Message) ([]byte, error) {
+	sub := msg.Subject
+	htm := msg.HTML
+	tex := msg.Text
+
+	if msg.Template != "" {
+		b, err := json.MarshalIndent(msg.Model, "", "  ")
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		sub = msg.Template
+		htm = ""
+		tex = string(b)
+	}
+
+	var buf bytes.Buffer
+
+	{
+		var hea []string
+		for k := range msg.Header {
+			hea = append(hea, k)
+		}
+		sort.Strings(hea)
+
+		fmt.Fprintf(&buf, "From: %s\r\n", strip(msg.From))
+		fmt.Fprintf(&buf, "To: %s\r\n", strip(msg.To))
+		fmt.Fprintf(&buf, "Subject: %s\r\n", encode(sub))
+		for _, k := range hea {
+			fmt.Fprintf(&buf, "%s: %s\r\n", strip(k), encode(msg.Header[k]))
+		}
+		fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	}
+
+	if htm == "" {
+		fmt.Fprintf(&buf, "Content-Type: text/plain; charset=utf-8\r\n")
+		fmt.Fprintf(&buf, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+		err := quoted(&buf, tex)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	var par bytes.Buffer
+	w := multipart.NewWriter(&par)
+
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", w.Boundary())
+
+	for _, p := range []struct {
+		typ string
+		con string
+	}{
+		{typ: "text/plain; charset=utf-8", con: tex},
+		{typ: "text/html; charset=utf-8", con: htm},
+	} {
+		if p.con == "" {
+			continue
+		}
+
+		hea := textproto.MIMEHeader{
+			"Content-Type":              {p.typ},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		}
+
+		pw, err := w.CreatePart(hea)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		err = quoted(pw, p.con)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+	}
+
+	err := w.Close()
+	if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	buf.Write(par.Bytes())
+
+	return buf.Bytes(), nil
+}
+
+// encode returns the given header value encoded according to RFC 2047, so
+// that non ASCII characters survive the transport. Line breaks are removed
+// first, so that values cannot inject further headers.
+func encode(s string) string {
+	return mime.QEncoding.Encode("utf-8", strip(s))
+}
+
+// quoted writes the given content quoted-printable encoded to the given
+// writer. Quoted-printable encoding keeps lines short enough for SMTP and
+// normalises line breaks to CRLF.
+func quoted(w io.Writer, s string) error {
+	q := quotedprintable.NewWriter(w)
+
+	_, err := q.Write([]byte(s))
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	err = q.Close()
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	return nil
+}
+
+// strip removes all line breaks from the given header name or value.
+func strip(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
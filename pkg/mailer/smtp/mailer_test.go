@@ -0,0 +1,174 @@
+package smtp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/venturemark/apiworker/pkg/mailer"
+)
+
+func Test_Mailer_message(t *testing.T) {
+	testCases := []struct {
+		msg     mailer.Message
+		subject string
+		header  map[string]string
+		parts   map[string]string
+	}{
+		// Case 0 ensures that non ASCII subjects and bodies survive the
+		// transport.
+		{
+			msg: mailer.Message{
+				From:    "notifications@venturemark.co",
+				To:      "user@example.com",
+				Subject: "Es gibt 2 neue Updates für Müller",
+				Text:    "Grüße\nvon Venturemark",
+			},
+			subject: "Es gibt 2 neue Updates für Müller",
+			parts: map[string]string{
+				"text/plain; charset=utf-8": "Grüße\r\nvon Venturemark",
+			},
+		},
+		// Case 1 ensures that line breaks cannot inject further headers.
+		{
+			msg: mailer.Message{
+				From:    "notifications@venturemark.co",
+				To:      "user@example.com\r\nBcc: evil@example.com",
+				Subject: "Hello\r\nBcc: evil@example.com",
+				Header: map[string]string{
+					"X-Test": "foo\nBcc: evil@example.com",
+				},
+				Text: "body",
+			},
+			subject: "HelloBcc: evil@example.com",
+			header: map[string]string{
+				"X-Test": "fooBcc: evil@example.com",
+			},
+			parts: map[string]string{
+				"text/plain; charset=utf-8": "body",
+			},
+		},
+		// Case 2 ensures that multipart messages encode their parts, also
+		// when lines exceed the line length limit of SMTP.
+		{
+			msg: mailer.Message{
+				From:    "notifications@venturemark.co",
+				To:      "user@example.com",
+				Subject: "Hello",
+				Header: map[string]string{
+					"List-Unsubscribe": "<https://example.com/unsubscribe>",
+				},
+				Text: "Hällo",
+				HTML: "<p>" + strings.Repeat("ä", 200) + "</p>",
+			},
+			subject: "Hello",
+			header: map[string]string{
+				"List-Unsubscribe": "<https://example.com/unsubscribe>",
+			},
+			parts: map[string]string{
+				"text/plain; charset=utf-8": "Hällo",
+				"text/html; charset=utf-8":  "<p>" + strings.Repeat("ä", 200) + "</p>",
+			},
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			m := &Mailer{}
+
+			byt, err := m.message(tc.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, l := range strings.Split(string(byt), "\r\n") {
+				if len(l) > 998 {
+					t.Fatalf("expected lines of at most 998 characters, got %d", len(l))
+				}
+			}
+
+			msg, err := mail.ReadMessage(bytes.NewReader(byt))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(msg.Header["Bcc"]) != 0 {
+				t.Fatalf("expected no Bcc header, got %#v", msg.Header["Bcc"])
+			}
+
+			dec := &mime.WordDecoder{}
+
+			sub, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sub != tc.subject {
+				t.Fatalf("expected subject %q, got %q", tc.subject, sub)
+			}
+
+			for k, v := range tc.header {
+				h, err := dec.DecodeHeader(msg.Header.Get(k))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if h != v {
+					t.Fatalf("expected header %s to be %q, got %q", k, v, h)
+				}
+			}
+
+			par := map[string]string{}
+			{
+				typ, pa, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if typ != "multipart/alternative" {
+					par[msg.Header.Get("Content-Type")] = decode(t, msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+				} else {
+					r := multipart.NewReader(msg.Body, pa["boundary"])
+					for {
+						p, err := r.NextRawPart()
+						if err == io.EOF {
+							break
+						} else if err != nil {
+							t.Fatal(err)
+						}
+
+						par[p.Header.Get("Content-Type")] = decode(t, p.Header.Get("Content-Transfer-Encoding"), p)
+					}
+				}
+			}
+
+			if len(par) != len(tc.parts) {
+				t.Fatalf("expected %d parts, got %d", len(tc.parts), len(par))
+			}
+			for k, v := range tc.parts {
+				if par[k] != v {
+					t.Fatalf("expected part %s to be %q, got %q", k, v, par[k])
+				}
+			}
+		})
+	}
+}
+
+func decode(t *testing.T, enc string, r io.Reader) string {
+	t.Helper()
+
+	if enc != "quoted-printable" {
+		t.Fatalf("expected quoted-printable encoding, got %q", enc)
+	}
+
+	byt, err := io.ReadAll(quotedprintable.NewReader(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(byt)
+}
@@ -0,0 +1,41 @@
+package mailer
+
+type Interface interface {
+	// Send delivers the given message. Messages with a template are rendered
+	// by the backend using the template model, all other messages are sent
+	// as they are. Errors can be classified using IsDelivery and IsTemporary.
+	Send(msg Message) (Result, error)
+}
+
+type Message struct {
+	From   string
+	Header map[string]string
+	To     string
+
+	// Subject, HTML and Text make up the content of raw messages.
+	Subject string
+	HTML    string
+	Text    string
+
+	// Template is the alias of the template the message is rendered with,
+	// if any. Model is the data the template is rendered with.
+	Template string
+	Model    map[string]interface{}
+
+	// Data is the data Subject, HTML and Text got rendered with, if any. Data
+	// is never sent, but kept for inspection by mailers capturing messages.
+	Data interface{}
+
+	// TrackOpens expresses whether the backend should track whether the
+	// message got opened, if the backend supports it.
+	TrackOpens bool
+}
+
+type Result struct {
+	// ID is the backend specific ID of the sent message, if any.
+	ID string
+	// Inactive expresses that the message was not delivered because the
+	// backend knows the recipient to be inactive, e.g. due to hard bounces or
+	// spam complaints. This is not considered an error.
+	Inactive bool
+}
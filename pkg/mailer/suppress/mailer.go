@@ -0,0 +1,95 @@
+package suppress
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/mailer"
+	"github.com/venturemark/apiworker/pkg/suppression"
+)
+
+type MailerConfig struct {
+	// Mailer is the backend messages are delivered with unless their
+	// recipient is suppressed.
+	Mailer      mailer.Interface
+	Suppression *suppression.Store
+}
+
+// Mailer wraps another mailer and does not send any messages to suppressed
+// recipients. Recipients the backend reports to be inactive are suppressed,
+// so that they are not tried again and again. Mailer is a prometheus
+// collector counting the messages which were not sent due to suppressions.
+type Mailer struct {
+	counter     *prometheus.CounterVec
+	mailer      mailer.Interface
+	suppression *suppression.Store
+}
+
+func NewMailer(config MailerConfig) (*Mailer, error) {
+	if config.Mailer == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Mailer must not be empty", config)
+	}
+	if config.Suppression == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Suppression must not be empty", config)
+	}
+
+	m := &Mailer{
+		counter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "apiworker",
+				Subsystem: "mailer",
+				Name:      "suppressed_total",
+				Help:      "Number of messages not sent because their recipient is suppressed.",
+			},
+			[]string{"reason"},
+		),
+		mailer:      config.Mailer,
+		suppression: config.Suppression,
+	}
+
+	return m, nil
+}
+
+func (m *Mailer) Collect(ch chan<- prometheus.Metric) {
+	m.counter.Collect(ch)
+}
+
+func (m *Mailer) Describe(ch chan<- *prometheus.Desc) {
+	m.counter.Describe(ch)
+}
+
+// Send delivers the given message using the wrapped mailer. Messages to
+// suppressed recipients are not sent and reported as inactive instead.
+func (m *Mailer) Send(msg mailer.Message) (mailer.Result, error) {
+	{
+		e, err := m.suppression.Search(msg.To)
+		if err == nil {
+			m.counter.WithLabelValues(e.Reason).Inc()
+			return mailer.Result{Inactive: true}, nil
+		} else if !suppression.IsNotFound(err) {
+			return mailer.Result{}, tracer.Mask(err)
+		}
+	}
+
+	res, err := m.mailer.Send(msg)
+	if err != nil {
+		return mailer.Result{}, tracer.Mask(err)
+	}
+
+	if res.Inactive {
+		e := &suppression.Entry{
+			Address: msg.To,
+			Created: time.Now().UTC(),
+			Reason:  suppression.ReasonInactive,
+		}
+
+		err = m.suppression.Create(e)
+		if err != nil {
+			return mailer.Result{}, tracer.Mask(err)
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,23 @@
+package preference
+
+import (
+	"errors"
+
+	"github.com/xh3b4sd/tracer"
+)
+
+var invalidConfigError = &tracer.Error{
+	Kind: "invalidConfigError",
+}
+
+func IsInvalidConfig(err error) bool {
+	return errors.Is(err, invalidConfigError)
+}
+
+var invalidPreferenceError = &tracer.Error{
+	Kind: "invalidPreferenceError",
+}
+
+func IsInvalidPreference(err error) bool {
+	return errors.Is(err, invalidPreferenceError)
+}
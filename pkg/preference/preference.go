@@ -0,0 +1,277 @@
+package preference
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/redigo/pkg/simple"
+	"github.com/xh3b4sd/tracer"
+)
+
+const (
+	// Prefix is the key prefix under which the notification preferences of
+	// users are persisted. The full key of a user's preferences is the prefix
+	// followed by the user ID, see Key.
+	Prefix = "apiworker.venturemark.co:pre"
+)
+
+const (
+	// Frequency is the task metadata key holding the reminder frequency,
+	// one of FrequencyOff, FrequencyDaily or FrequencyWeekly.
+	Frequency = "preference.venturemark.co/frequency"
+	// Hour is the task metadata key holding the preferred hour of the day,
+	// in UTC, at which reminders are sent.
+	Hour = "preference.venturemark.co/hour"
+	// Locale is the task metadata key holding the locale reminders are
+	// rendered in, e.g. en or de-AT.
+	Locale = "preference.venturemark.co/locale"
+	// Mute is the task metadata key holding a comma separated list of venture
+	// IDs for which no reminders should be sent anymore.
+	Mute = "preference.venturemark.co/mute"
+	// Unread is the task metadata key expressing whether reminders should
+	// only contain updates the user did not view yet, either true or false.
+	Unread = "preference.venturemark.co/unread"
+	// Unmute is the task metadata key holding a comma separated list of
+	// venture IDs for which reminders should be sent again.
+	Unmute = "preference.venturemark.co/unmute"
+	// Window is the task metadata key holding the period of time reminders
+	// look back for updates, e.g. 72h. An empty value resets the window to
+	// the default derived from the frequency.
+	Window = "preference.venturemark.co/window"
+)
+
+const (
+	FrequencyDaily  = "daily"
+	FrequencyOff    = "off"
+	FrequencyWeekly = "weekly"
+)
+
+const (
+	// defaultHour is the hour at which reminders are sent to users who did
+	// not express any preference.
+	defaultHour = 13
+	// weekday is the day of the week at which weekly reminders are sent.
+	weekday = time.Monday
+)
+
+// Preference is the set of notification preferences of a single user.
+type Preference struct {
+	Frequency string `json:"frequency"`
+	Hour      int    `json:"hour"`
+	// Locale is the locale reminders are rendered in. The fallback locale of
+	// the renderer is used if Locale is empty.
+	Locale string   `json:"locale,omitempty"`
+	Mute   []string `json:"mute,omitempty"`
+	// Unread expresses whether reminders should only contain updates the
+	// user did not view yet, according to the read markers of the user.
+	Unread bool `json:"unread,omitempty"`
+	// Window is the custom period of time reminders look back for updates.
+	// The period is derived from the frequency if Window is zero.
+	Window time.Duration `json:"window,omitempty"`
+}
+
+// Default returns the preferences of users who did not express any
+// preference, which is a daily reminder at the default hour.
+func Default() *Preference {
+	return &Preference{
+		Frequency: FrequencyDaily,
+		Hour:      defaultHour,
+	}
+}
+
+// Due returns whether a reminder should be sent at the given time according
+// to the preferences.
+func (p *Preference) Due(t time.Time) bool {
+	if t.Hour() != p.Hour {
+		return false
+	}
+
+	switch p.Frequency {
+	case FrequencyDaily:
+		return true
+	case FrequencyWeekly:
+		return t.Weekday() == weekday
+	}
+
+	return false
+}
+
+// Period returns the period of time reminders look back for updates. Unless
+// the user chose a custom window, the period matches the frequency, so that
+// consecutive reminders neither overlap nor miss any updates.
+func (p *Preference) Period() time.Duration {
+	if p.Window != 0 {
+		return p.Window
+	}
+
+	if p.Frequency == FrequencyWeekly {
+		return 7 * 24 * time.Hour
+	}
+
+	return 24 * time.Hour
+}
+
+// Muted returns whether the venture with the given ID is muted.
+func (p *Preference) Muted(vei string) bool {
+	for _, m := range p.Mute {
+		if m == vei {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Apply changes the preferences according to the given task metadata. Only
+// the preferences present in the metadata are changed.
+func (p *Preference) Apply(met map[string]string) error {
+	if f, ok := met[Frequency]; ok {
+		if f != FrequencyDaily && f != FrequencyOff && f != FrequencyWeekly {
+			return tracer.Maskf(invalidPreferenceError, "%s must be %s, %s or %s", Frequency, FrequencyDaily, FrequencyOff, FrequencyWeekly)
+		}
+
+		p.Frequency = f
+	}
+
+	if h, ok := met[Hour]; ok {
+		i, err := strconv.Atoi(h)
+		if err != nil || i < 0 || i > 23 {
+			return tracer.Maskf(invalidPreferenceError, "%s must be between 0 and 23", Hour)
+		}
+
+		p.Hour = i
+	}
+
+	if r, ok := met[Unread]; ok {
+		b, err := strconv.ParseBool(r)
+		if err != nil {
+			return tracer.Maskf(invalidPreferenceError, "%s must be true or false", Unread)
+		}
+
+		p.Unread = b
+	}
+
+	if w, ok := met[Window]; ok {
+		var d time.Duration
+		if w != "" {
+			var err error
+			d, err = time.ParseDuration(w)
+			if err != nil || d < time.Hour {
+				return tracer.Maskf(invalidPreferenceError, "%s must be a duration of at least 1h", Window)
+			}
+		}
+
+		p.Window = d
+	}
+
+	if l, ok := met[Locale]; ok {
+		for _, r := range l {
+			if !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && r != '-' && r != '_' {
+				return tracer.Maskf(invalidPreferenceError, "%s must be a language tag like en or de-AT", Locale)
+			}
+		}
+
+		p.Locale = l
+	}
+
+	if m, ok := met[Mute]; ok {
+		for _, v := range split(m) {
+			if !p.Muted(v) {
+				p.Mute = append(p.Mute, v)
+			}
+		}
+	}
+
+	if m, ok := met[Unmute]; ok {
+		for _, v := range split(m) {
+			var mut []string
+			for _, x := range p.Mute {
+				if x != v {
+					mut = append(mut, x)
+				}
+			}
+
+			p.Mute = mut
+		}
+	}
+
+	return nil
+}
+
+type Config struct {
+	Redigo redigo.Interface
+}
+
+// Store persists the notification preferences of users in redis.
+type Store struct {
+	redigo redigo.Interface
+}
+
+func New(config Config) (*Store, error) {
+	if config.Redigo == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Redigo must not be empty", config)
+	}
+
+	s := &Store{
+		redigo: config.Redigo,
+	}
+
+	return s, nil
+}
+
+// Search returns the preferences of the user with the given ID. The default
+// preferences are returned for users who did not express any preference.
+func (s *Store) Search(uid string) (*Preference, error) {
+	val, err := s.redigo.Simple().Search().Value(Key(uid))
+	if simple.IsNotFound(err) {
+		return Default(), nil
+	} else if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	p := Default()
+	err = json.Unmarshal([]byte(val), p)
+	if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	return p, nil
+}
+
+// Update persists the given preferences of the user with the given ID.
+func (s *Store) Update(uid string, pre *Preference) error {
+	byt, err := json.Marshal(pre)
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	err = s.redigo.Simple().Create().Element(Key(uid), string(byt))
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	return nil
+}
+
+// Key returns the simple key holding the preferences of the user with the
+// given ID.
+func Key(uid string) string {
+	return fmt.Sprintf("%s:%s", Prefix, uid)
+}
+
+func split(s string) []string {
+	var l []string
+
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			l = append(l, v)
+		}
+	}
+
+	return l
+}
@@ -0,0 +1,120 @@
+package preference
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_Preference_Due(t *testing.T) {
+	// March 1st 2021 is a Monday.
+	mon := time.Date(2021, time.March, 1, 13, 0, 0, 0, time.UTC)
+	tue := mon.Add(24 * time.Hour)
+
+	testCases := []struct {
+		pre *Preference
+		tim time.Time
+		due bool
+	}{
+		// Case 0 ensures that default preferences are due at the default
+		// hour.
+		{
+			pre: Default(),
+			tim: mon,
+			due: true,
+		},
+		// Case 1 ensures that default preferences are not due at other
+		// hours.
+		{
+			pre: Default(),
+			tim: mon.Add(time.Hour),
+			due: false,
+		},
+		// Case 2 ensures that daily preferences are due every day.
+		{
+			pre: &Preference{Frequency: FrequencyDaily, Hour: 13},
+			tim: tue,
+			due: true,
+		},
+		// Case 3 ensures that weekly preferences are due on Mondays.
+		{
+			pre: &Preference{Frequency: FrequencyWeekly, Hour: 13},
+			tim: mon,
+			due: true,
+		},
+		// Case 4 ensures that weekly preferences are not due on other days.
+		{
+			pre: &Preference{Frequency: FrequencyWeekly, Hour: 13},
+			tim: tue,
+			due: false,
+		},
+		// Case 5 ensures that weekly preferences are not due at other hours
+		// on Mondays.
+		{
+			pre: &Preference{Frequency: FrequencyWeekly, Hour: 8},
+			tim: mon,
+			due: false,
+		},
+		// Case 6 ensures that disabled reminders are never due.
+		{
+			pre: &Preference{Frequency: FrequencyOff, Hour: 13},
+			tim: mon,
+			due: false,
+		},
+		// Case 7 ensures that custom windows do not change when reminders
+		// are due.
+		{
+			pre: &Preference{Frequency: FrequencyWeekly, Hour: 13, Window: 72 * time.Hour},
+			tim: tue,
+			due: false,
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			due := tc.pre.Due(tc.tim)
+			if due != tc.due {
+				t.Fatalf("expected %t, got %t", tc.due, due)
+			}
+		})
+	}
+}
+
+func Test_Preference_Period(t *testing.T) {
+	testCases := []struct {
+		pre *Preference
+		per time.Duration
+	}{
+		// Case 0 ensures that default preferences cover a day.
+		{
+			pre: Default(),
+			per: 24 * time.Hour,
+		},
+		// Case 1 ensures that weekly preferences cover a week.
+		{
+			pre: &Preference{Frequency: FrequencyWeekly},
+			per: 7 * 24 * time.Hour,
+		},
+		// Case 2 ensures that custom windows take precedence over daily
+		// frequencies.
+		{
+			pre: &Preference{Frequency: FrequencyDaily, Window: 72 * time.Hour},
+			per: 72 * time.Hour,
+		},
+		// Case 3 ensures that custom windows take precedence over weekly
+		// frequencies.
+		{
+			pre: &Preference{Frequency: FrequencyWeekly, Window: 12 * time.Hour},
+			per: 12 * time.Hour,
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			per := tc.pre.Period()
+			if per != tc.per {
+				t.Fatalf("expected %s, got %s", tc.per, per)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package readmarker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/redigo/pkg/simple"
+	"github.com/xh3b4sd/tracer"
+)
+
+const (
+	// Prefix is the key prefix under which read markers are persisted. Read
+	// markers are written by apiserver whenever a user views the updates of
+	// a venture. The full key of a read marker is the prefix followed by the
+	// user ID and the venture ID, see Key. apiserver must write read markers
+	// using exactly this format, since apiworker deletes them along with the
+	// users and ventures they belong to. The format should eventually move
+	// to the key package of apicommon, so that both sides share a single
+	// definition.
+	Prefix = "apiworker.venturemark.co:rea"
+)
+
+type Config struct {
+	Redigo redigo.Interface
+}
+
+// Store looks up read markers. A read marker is the update ID, that is the
+// unix nano timestamp, of the most recent update a user viewed within a
+// venture. All updates of the venture up to the read marker are considered
+// read by the user.
+type Store struct {
+	redigo redigo.Interface
+}
+
+func New(config Config) (*Store, error) {
+	if config.Redigo == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Redigo must not be empty", config)
+	}
+
+	s := &Store{
+		redigo: config.Redigo,
+	}
+
+	return s, nil
+}
+
+// Search returns the read marker of the given user for the given venture.
+// Zero is returned if the user did not view any updates of the venture yet.
+func (s *Store) Search(uid string, vei string) (int64, error) {
+	val, err := s.redigo.Simple().Search().Value(Key(uid, vei))
+	if simple.IsNotFound(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, tracer.Mask(err)
+	}
+
+	i, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return 0, tracer.Mask(err)
+	}
+
+	return i, nil
+}
+
+// Key returns the simple key holding the read marker of the given user for
+// the given venture.
+func Key(uid string, vei string) string {
+	return fmt.Sprintf("%s:%s:%s", Prefix, uid, vei)
+}
+
+// Split returns the user ID and the venture ID of the given read marker key.
+// False is returned if the given key is not a read marker key.
+func Split(k string) (string, string, bool) {
+	if !strings.HasPrefix(k, Prefix+":") {
+		return "", "", false
+	}
+
+	l := strings.Split(strings.TrimPrefix(k, Prefix+":"), ":")
+	if len(l) != 2 || l[0] == "" || l[1] == "" {
+		return "", "", false
+	}
+
+	return l[0], l[1], true
+}
@@ -0,0 +1,53 @@
+package readmarker
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_ReadMarker_Split(t *testing.T) {
+	testCases := []struct {
+		key string
+		uid string
+		vei string
+		ok  bool
+	}{
+		// Case 0 ensures that keys created by Key can be split.
+		{
+			key: Key("1", "2"),
+			uid: "1",
+			vei: "2",
+			ok:  true,
+		},
+		// Case 1 ensures that keys of other prefixes are rejected.
+		{
+			key: "apiworker.venturemark.co:pre:1",
+			ok:  false,
+		},
+		// Case 2 ensures that keys without venture ID are rejected.
+		{
+			key: Prefix + ":1",
+			ok:  false,
+		},
+		// Case 3 ensures that keys with further segments are rejected.
+		{
+			key: Key("1", "2") + ":3",
+			ok:  false,
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			uid, vei, ok := Split(tc.key)
+			if ok != tc.ok {
+				t.Fatalf("expected %t, got %t", tc.ok, ok)
+			}
+			if uid != tc.uid {
+				t.Fatalf("expected user ID %q, got %q", tc.uid, uid)
+			}
+			if vei != tc.vei {
+				t.Fatalf("expected venture ID %q, got %q", tc.vei, vei)
+			}
+		})
+	}
+}
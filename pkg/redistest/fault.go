@@ -0,0 +1,63 @@
+package redistest
+
+import (
+	"github.com/venturemark/apiworker/pkg/transaction"
+)
+
+var (
+	// invalid is an operation redis refuses to queue because of its wrong
+	// number of arguments, which causes redis to discard the transaction it
+	// is part of as a whole.
+	invalid = transaction.Operation{Command: "SET", Arguments: []interface{}{"redistest"}}
+)
+
+// Fault wraps a transaction in order to inject failures into the handler steps
+// executed through it, so that tests can verify that failing steps leave no
+// partial writes behind.
+type Fault struct {
+	// Fail causes every transaction to fail midway, by injecting an invalid
+	// operation between its operations.
+	Fail bool
+	// Race, if set, is called once within the first watched transaction,
+	// after its operations got derived but before they get executed, so that
+	// tests can modify the watched keys concurrently.
+	Race func()
+
+	Transaction transaction.Interface
+}
+
+func (f *Fault) Execute(ops ...transaction.Operation) error {
+	return f.Transaction.Execute(f.inject(ops)...)
+}
+
+func (f *Fault) Watch(keys []string, fun func() ([]transaction.Operation, error)) error {
+	return f.Transaction.Watch(keys, func() ([]transaction.Operation, error) {
+		ops, err := fun()
+		if err != nil {
+			return nil, err
+		}
+
+		if f.Race != nil {
+			r := f.Race
+			f.Race = nil
+			r()
+		}
+
+		return f.inject(ops), nil
+	})
+}
+
+func (f *Fault) inject(ops []transaction.Operation) []transaction.Operation {
+	if !f.Fail || len(ops) == 0 {
+		return ops
+	}
+
+	i := len(ops) / 2
+
+	var inj []transaction.Operation
+	inj = append(inj, ops[:i]...)
+	inj = append(inj, invalid)
+	inj = append(inj, ops[i:]...)
+
+	return inj
+}
@@ -0,0 +1,63 @@
+// Package redistest provides access to a real redis instance for tests which
+// depend on the semantics of redis itself, e.g. the ordering of sorted sets or
+// the behaviour of transactions.
+package redistest
+
+import (
+	"os"
+	"testing"
+
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/redigo/pkg/client"
+
+	"github.com/venturemark/apiworker/pkg/transaction"
+	"github.com/venturemark/apiworker/pkg/transaction/multi"
+)
+
+const (
+	// Env is the environment variable holding the address of the redis
+	// instance tests run against, e.g. 127.0.0.1:6379. Tests using this
+	// package are skipped if the variable is not set. The database of the
+	// instance is flushed by every test, so it must never point to a redis
+	// instance holding data of any value.
+	Env = "APIWORKER_TEST_REDIS_ADDRESS"
+)
+
+// New returns a redigo client and a transaction talking to the redis instance
+// given by Env, or skips the calling test if Env is not set. The database is
+// flushed before the test runs and after it finished.
+func New(t *testing.T) (redigo.Interface, transaction.Interface) {
+	t.Helper()
+
+	a := os.Getenv(Env)
+	if a == "" {
+		t.Skipf("%s not set", Env)
+	}
+
+	red, err := client.New(client.Config{Address: a, Kind: "single"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	poo, err := multi.NewPool(multi.PoolConfig{Address: a, Kind: "single"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tra, err := multi.NewTransaction(multi.TransactionConfig{Pool: poo})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pur := func() {
+		err := red.Purge()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pur()
+	t.Cleanup(pur)
+
+	return red, tra
+}
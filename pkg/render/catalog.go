@@ -0,0 +1,162 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xh3b4sd/tracer"
+)
+
+const (
+	// Fallback is the locale used for users without any locale, as well as
+	// for messages missing in the catalog of a user's locale.
+	Fallback = "en"
+)
+
+// catalog holds the localised messages of a single locale.
+type catalog struct {
+	// Date is the format dates are rendered with. It is given the day of
+	// the month, the name of the month and the time of the day, in that
+	// order.
+	Date    string             `json:"date"`
+	Month   []string           `json:"month"`
+	Message map[string]message `json:"message"`
+}
+
+// message is a single localised message, which may be a fmt format. Messages
+// depending on a count provide a form for exactly one and a form for any
+// other count. Messages not depending on a count may be given as plain string
+// in the catalog.
+type message struct {
+	One   string `json:"one"`
+	Other string `json:"other"`
+}
+
+func (m *message) UnmarshalJSON(byt []byte) error {
+	var s string
+	err := json.Unmarshal(byt, &s)
+	if err == nil {
+		m.One = s
+		m.Other = s
+		return nil
+	}
+
+	type raw message
+	var r raw
+	err = json.Unmarshal(byt, &r)
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	*m = message(r)
+
+	return nil
+}
+
+// chain returns the locales messages are looked up in for the given locale,
+// most specific first, e.g. de-AT, de and en.
+func chain(loc string) []string {
+	var l []string
+
+	loc = strings.ReplaceAll(strings.TrimSpace(loc), "_", "-")
+	for loc != "" {
+		l = append(l, strings.ToLower(loc))
+
+		i := strings.LastIndex(loc, "-")
+		if i == -1 {
+			break
+		}
+
+		loc = loc[:i]
+	}
+
+	return append(l, Fallback)
+}
+
+// funcs returns the template functions rendering localised content for the
+// given locale. The function t renders the message with the given key and
+// arguments. The function tn renders the form of the message with the given
+// key matching the given count, which is passed to the message as its first
+// argument. The functions date and ago render the given time as localised
+// date and relative to the given time respectively.
+func (r *Render) funcs(loc string, now time.Time) map[string]interface{} {
+	cha := chain(loc)
+
+	t := func(k string, arg ...interface{}) (string, error) {
+		m, err := r.search(cha, k)
+		if err != nil {
+			return "", tracer.Mask(err)
+		}
+
+		return fmt.Sprintf(m.Other, arg...), nil
+	}
+
+	tn := func(k string, n int, arg ...interface{}) (string, error) {
+		m, err := r.search(cha, k)
+		if err != nil {
+			return "", tracer.Mask(err)
+		}
+
+		f := m.Other
+		if n == 1 {
+			f = m.One
+		}
+
+		return fmt.Sprintf(f, append([]interface{}{n}, arg...)...), nil
+	}
+
+	date := func(d time.Time) (string, error) {
+		for _, l := range cha {
+			c, ok := r.catalog[l]
+			if !ok || c.Date == "" || len(c.Month) != 12 {
+				continue
+			}
+
+			return fmt.Sprintf(c.Date, d.Day(), c.Month[d.Month()-1], d.Format("15:04")), nil
+		}
+
+		return "", tracer.Maskf(notFoundError, "date format")
+	}
+
+	ago := func(d time.Time) (string, error) {
+		s := now.Sub(d)
+
+		switch {
+		case s < time.Minute:
+			return t("ago.now")
+		case s < time.Hour:
+			return tn("ago.minute", int(s/time.Minute))
+		case s < 24*time.Hour:
+			return tn("ago.hour", int(s/time.Hour))
+		}
+
+		return tn("ago.day", int(s/(24*time.Hour)))
+	}
+
+	return map[string]interface{}{
+		"ago":  ago,
+		"date": date,
+		"t":    t,
+		"tn":   tn,
+	}
+}
+
+// search returns the message with the given key from the catalog of the first
+// of the given locales providing it.
+func (r *Render) search(cha []string, k string) (message, error) {
+	for _, l := range cha {
+		c, ok := r.catalog[l]
+		if !ok {
+			continue
+		}
+
+		m, ok := c.Message[k]
+		if ok {
+			return m, nil
+		}
+	}
+
+	return message{}, tracer.Maskf(notFoundError, "message %q", k)
+}
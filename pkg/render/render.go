@@ -0,0 +1,216 @@
+package render
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/xh3b4sd/tracer"
+)
+
+// files contains all email templates and message catalogs. Every template
+// lives in its own directory under template/, named after the template, and
+// consists of the files subject.txt, body.html and body.txt. Templates render
+// localised content using the messages of the catalogs under catalog/, one
+// JSON file per locale, named after the locale.
+//
+//go:embed catalog template
+var files embed.FS
+
+const (
+	fileHTML    = "body.html"
+	fileSubject = "subject.txt"
+	fileText    = "body.txt"
+)
+
+type Config struct{}
+
+// Content is the rendered content of an email.
+type Content struct {
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// Render renders emails using the templates embedded into the binary. All
+// templates are parsed upfront, so that broken templates cause the worker to
+// fail on boot instead of failing when sending emails.
+type Render struct {
+	catalog map[string]*catalog
+	html    map[string]*htmltemplate.Template
+	text    map[string]*texttemplate.Template
+}
+
+func New(config Config) (*Render, error) {
+	var err error
+
+	r := &Render{
+		catalog: map[string]*catalog{},
+		html:    map[string]*htmltemplate.Template{},
+		text:    map[string]*texttemplate.Template{},
+	}
+
+	{
+		dir, err := fs.ReadDir(files, "catalog")
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		for _, d := range dir {
+			if d.IsDir() || path.Ext(d.Name()) != ".json" {
+				continue
+			}
+
+			byt, err := fs.ReadFile(files, path.Join("catalog", d.Name()))
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			c := &catalog{}
+			err = json.Unmarshal(byt, c)
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			r.catalog[strings.TrimSuffix(d.Name(), ".json")] = c
+		}
+
+		if _, ok := r.catalog[Fallback]; !ok {
+			return nil, tracer.Maskf(notFoundError, "catalog %q", Fallback)
+		}
+	}
+
+	// Templates are parsed with the functions of the fallback locale. The
+	// functions are replaced with the ones of the requested locale for every
+	// execution, see Execute.
+	var fun map[string]interface{}
+	{
+		fun = r.funcs(Fallback, time.Now())
+	}
+
+	var dir []fs.DirEntry
+	{
+		dir, err = fs.ReadDir(files, "template")
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+	}
+
+	for _, d := range dir {
+		if !d.IsDir() {
+			continue
+		}
+
+		var n string
+		{
+			n = d.Name()
+		}
+
+		r.html[n], err = htmltemplate.New(n).Funcs(fun).ParseFS(files, path.Join("template", n, fileHTML))
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		r.text[n], err = texttemplate.New(n).Funcs(fun).ParseFS(files, path.Join("template", n, fileSubject), path.Join("template", n, fileText))
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+	}
+
+	return r, nil
+}
+
+// Execute renders the template with the given name in the given locale using
+// the given data. Messages missing for the given locale are looked up in less
+// specific locales, e.g. de for de-AT, and eventually in the fallback locale.
+// Leading and trailing whitespace is removed from the rendered subject, so
+// that templates can be formatted freely.
+func (r *Render) Execute(name string, locale string, data interface{}) (Content, error) {
+	var err error
+
+	var fun map[string]interface{}
+	{
+		fun = r.funcs(locale, time.Now())
+	}
+
+	var htm *htmltemplate.Template
+	{
+		h, ok := r.html[name]
+		if !ok {
+			return Content{}, tracer.Maskf(notFoundError, "%s", name)
+		}
+
+		htm, err = h.Clone()
+		if err != nil {
+			return Content{}, tracer.Mask(err)
+		}
+
+		htm.Funcs(fun)
+	}
+
+	var txt *texttemplate.Template
+	{
+		t, ok := r.text[name]
+		if !ok {
+			return Content{}, tracer.Maskf(notFoundError, "%s", name)
+		}
+
+		txt, err = t.Clone()
+		if err != nil {
+			return Content{}, tracer.Mask(err)
+		}
+
+		txt.Funcs(fun)
+	}
+
+	var sub bytes.Buffer
+	{
+		err = txt.ExecuteTemplate(&sub, fileSubject, data)
+		if err != nil {
+			return Content{}, tracer.Mask(err)
+		}
+	}
+
+	var hbo bytes.Buffer
+	{
+		err = htm.ExecuteTemplate(&hbo, fileHTML, data)
+		if err != nil {
+			return Content{}, tracer.Mask(err)
+		}
+	}
+
+	var tbo bytes.Buffer
+	{
+		err = txt.ExecuteTemplate(&tbo, fileText, data)
+		if err != nil {
+			return Content{}, tracer.Mask(err)
+		}
+	}
+
+	c := Content{
+		Subject: strings.TrimSpace(sub.String()),
+		HTML:    hbo.String(),
+		Text:    tbo.String(),
+	}
+
+	return c, nil
+}
+
+// Message renders the message with the given key and arguments in the given
+// locale, like the template function t does, for content not rendered from
+// templates, e.g. the pages served to users following unsubscribe links.
+func (r *Render) Message(locale string, key string, arg ...interface{}) (string, error) {
+	m, err := r.search(chain(locale), key)
+	if err != nil {
+		return "", tracer.Mask(err)
+	}
+
+	return fmt.Sprintf(m.Other, arg...), nil
+}
@@ -0,0 +1,245 @@
+package render
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update the golden files under testdata")
+
+// The types below mirror the data the reminder templates are rendered with by
+// remindercreate.
+
+type testVenture struct {
+	Name string
+	Path string
+}
+
+type testTimeline struct {
+	Name string
+	Path string
+}
+
+type testMore struct {
+	Count   int
+	Venture testVenture
+}
+
+type testUpdate struct {
+	Title      template.HTML
+	TitleText  string
+	Body       template.HTML
+	BodyText   string
+	AuthorName string
+	Created    time.Time
+	Path       string
+	Venture    testVenture
+	Timelines  []testTimeline
+}
+
+type testReminder struct {
+	BaseURL     string
+	Count       int
+	Start       time.Time
+	End         time.Time
+	More        []*testMore
+	Unsubscribe string
+	Updates     []*testUpdate
+}
+
+func Test_Render_Execute_Golden(t *testing.T) {
+	testCases := []struct {
+		name   string
+		locale string
+		more   bool
+		unsub  bool
+	}{
+		{
+			name:   "en",
+			locale: "en",
+		},
+		{
+			name:   "en-more-unsubscribe",
+			locale: "en",
+			more:   true,
+			unsub:  true,
+		},
+		{
+			name:   "de",
+			locale: "de",
+		},
+		{
+			name:   "de-more-unsubscribe",
+			locale: "de-AT",
+			more:   true,
+			unsub:  true,
+		},
+	}
+
+	r, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			ven := testVenture{
+				Name: "Acme & Co",
+				Path: "/acme",
+			}
+
+			dat := testReminder{
+				BaseURL: "https://venturemark.co",
+				Count:   1,
+				Start:   time.Date(2021, time.March, 1, 13, 0, 0, 0, time.UTC),
+				End:     time.Date(2021, time.March, 2, 13, 0, 0, 0, time.UTC),
+				Updates: []*testUpdate{
+					{
+						Title:      "<h3>Raised our seed round</h3>",
+						TitleText:  "Raised our seed round",
+						Body:       "<p>We closed the round with <strong>3</strong> investors.</p>",
+						BodyText:   "We closed the round with 3 investors.",
+						AuthorName: "Jane",
+						// The creation time is rendered relative to the time
+						// of rendering, so it lies between two full hours in
+						// order to render the same way reliably.
+						Created: time.Now().Add(-150 * time.Minute),
+						Path:    "/acme/fundraising",
+						Venture: ven,
+						Timelines: []testTimeline{
+							{
+								Name: "Fundraising",
+								Path: "/acme/fundraising",
+							},
+						},
+					},
+				},
+			}
+
+			if tc.more {
+				dat.Count = 4
+				dat.More = []*testMore{
+					{
+						Count:   1,
+						Venture: testVenture{Name: "Beta", Path: "/beta"},
+					},
+					{
+						Count:   2,
+						Venture: ven,
+					},
+				}
+			}
+
+			if tc.unsub {
+				dat.Unsubscribe = "https://venturemark.co/unsubscribe?token=abc&kind=reminder"
+			}
+
+			con, err := r.Execute("daily-update", tc.locale, dat)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, f := range []struct {
+				name string
+				act  string
+			}{
+				{name: fileSubject, act: con.Subject + "\n"},
+				{name: fileHTML, act: con.HTML},
+				{name: fileText, act: con.Text},
+			} {
+				p := filepath.Join("testdata", tc.name, f.name)
+
+				if *update {
+					err := os.MkdirAll(filepath.Dir(p), 0755)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					err = os.WriteFile(p, []byte(f.act), 0600)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				exp, err := os.ReadFile(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if f.act != string(exp) {
+					t.Fatalf("%s: expected\n%s\ngot\n%s", p, exp, f.act)
+				}
+			}
+		})
+	}
+}
+
+// Test_Render_Execute_Deleted verifies that updates of deleted users are
+// attributed to a localised placeholder instead of an author name.
+func Test_Render_Execute_Deleted(t *testing.T) {
+	testCases := []struct {
+		locale string
+		name   string
+	}{
+		{
+			locale: "en",
+			name:   "Deleted user",
+		},
+		{
+			locale: "de-AT",
+			name:   "Gelöschter Nutzer",
+		},
+	}
+
+	r, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			dat := testReminder{
+				Count: 1,
+				Updates: []*testUpdate{
+					{
+						Created: time.Now(),
+						Venture: testVenture{Name: "Acme"},
+					},
+				},
+			}
+
+			con, err := r.Execute("daily-update", tc.locale, dat)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(con.Text, tc.name) {
+				t.Fatalf("expected %q in\n%s", tc.name, con.Text)
+			}
+		})
+	}
+}
+
+// Test_Render_Message verifies that messages rendered outside of templates
+// fall back to less specific locales.
+func Test_Render_Message(t *testing.T) {
+	r, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := r.Message("de-AT", "unsubscribe.done.title")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg != "Abgemeldet" {
+		t.Fatalf("expected %q got %q", "Abgemeldet", msg)
+	}
+}
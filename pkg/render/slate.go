@@ -0,0 +1,152 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/venturemark/apicommon/pkg/slate"
+	"github.com/xh3b4sd/tracer"
+)
+
+// Slate renders the given slate document, the JSON encoded list of slate
+// nodes, as plain text. It understands the same node types the HTML of
+// reminders is rendered from. Titles are underlined, list items are prefixed
+// with bullets or numbers and links are followed by their URL in parentheses.
+// Unknown element types are rendered like paragraphs. Blocks are separated by
+// blank lines.
+func Slate(doc string) (string, error) {
+	var nod slate.Nodes
+	err := json.Unmarshal([]byte(doc), &nod)
+	if err != nil {
+		return "", tracer.Mask(err)
+	}
+
+	var blo []string
+	for _, n := range nod {
+		s := block(n, "")
+		if s != "" {
+			blo = append(blo, s)
+		}
+	}
+
+	return strings.Join(blo, "\n\n"), nil
+}
+
+// block renders the given element node as a block of text, prefixing every
+// line with the given indentation.
+func block(n slate.Node, ind string) string {
+	switch n.Type {
+	case "title":
+		return heading(inline(n), "=", ind)
+
+	case "bulleted-list", "numbered-list":
+		var lin []string
+		for i, c := range n.Children {
+			pre := "- "
+			if n.Type == "numbered-list" {
+				pre = fmt.Sprintf("%d. ", i+1)
+			}
+
+			lin = append(lin, item(c, pre, ind))
+		}
+
+		return strings.Join(lin, "\n")
+
+	case "list-item":
+		return item(n, "- ", ind)
+	}
+
+	return children(n, ind)
+}
+
+// children renders the children of the given element node. Children which
+// are blocks themselves are rendered as separate lines, all other children
+// are rendered inline.
+func children(n slate.Node, ind string) string {
+	if !nested(n) {
+		s := strings.TrimSpace(inline(n))
+		if s == "" {
+			return ""
+		}
+
+		return ind + s
+	}
+
+	var lin []string
+	for _, c := range n.Children {
+		s := block(c, ind)
+		if s != "" {
+			lin = append(lin, s)
+		}
+	}
+
+	return strings.Join(lin, "\n")
+}
+
+func heading(s string, und string, ind string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%s%s\n%s%s", ind, s, ind, strings.Repeat(und, utf8.RuneCountInString(s)))
+}
+
+// inline renders the given node and all of its children as a single line of
+// text.
+func inline(n slate.Node) string {
+	if len(n.Children) == 0 {
+		return n.Text
+	}
+
+	var b strings.Builder
+	for _, c := range n.Children {
+		b.WriteString(inline(c))
+	}
+
+	s := b.String()
+	if n.Type == "link" && n.URL != "" && n.URL != s {
+		s = fmt.Sprintf("%s (%s)", s, n.URL)
+	}
+
+	return s
+}
+
+// item renders a single list item. Nested lists are indented beneath the
+// item they belong to.
+func item(n slate.Node, pre string, ind string) string {
+	if !nested(n) {
+		return ind + pre + strings.TrimSpace(inline(n))
+	}
+
+	var lin []string
+	for i, c := range n.Children {
+		if i == 0 && c.Type != "bulleted-list" && c.Type != "numbered-list" {
+			lin = append(lin, ind+pre+strings.TrimSpace(inline(c)))
+			continue
+		}
+
+		s := block(c, ind+strings.Repeat(" ", len(pre)))
+		if s != "" {
+			lin = append(lin, s)
+		}
+	}
+
+	return strings.Join(lin, "\n")
+}
+
+// nested returns whether the given node has children which are blocks.
+func nested(n slate.Node) bool {
+	for _, c := range n.Children {
+		switch c.Type {
+		case "", "link":
+			continue
+		}
+
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,142 @@
+package render
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_Render_Slate(t *testing.T) {
+	testCases := []struct {
+		doc string
+		txt string
+	}{
+		// Case 0 ensures that paragraphs are separated by blank lines.
+		{
+			doc: `[
+				{"type": "paragraph", "children": [{"text": "foo"}]},
+				{"type": "paragraph", "children": [{"text": "bar "}, {"text": "baz"}]}
+			]`,
+			txt: "foo\n\nbar baz",
+		},
+		// Case 1 ensures that titles are underlined.
+		{
+			doc: `[
+				{"type": "title", "children": [{"text": "Über"}]},
+				{"type": "paragraph", "children": [{"text": "foo"}]}
+			]`,
+			txt: "Über\n====\n\nfoo",
+		},
+		// Case 2 ensures that empty titles and paragraphs are dropped.
+		{
+			doc: `[
+				{"type": "title", "children": [{"text": " "}]},
+				{"type": "paragraph", "children": [{"text": ""}]},
+				{"type": "paragraph", "children": [{"text": "foo"}]}
+			]`,
+			txt: "foo",
+		},
+		// Case 3 ensures that bulleted list items are prefixed with dashes.
+		{
+			doc: `[
+				{"type": "bulleted-list", "children": [
+					{"type": "list-item", "children": [{"text": "foo"}]},
+					{"type": "list-item", "children": [{"text": "bar"}]}
+				]}
+			]`,
+			txt: "- foo\n- bar",
+		},
+		// Case 4 ensures that numbered list items are prefixed with numbers.
+		{
+			doc: `[
+				{"type": "numbered-list", "children": [
+					{"type": "list-item", "children": [{"text": "foo"}]},
+					{"type": "list-item", "children": [{"text": "bar"}]}
+				]}
+			]`,
+			txt: "1. foo\n2. bar",
+		},
+		// Case 5 ensures that nested lists are indented beneath their item.
+		{
+			doc: `[
+				{"type": "numbered-list", "children": [
+					{"type": "list-item", "children": [
+						{"type": "paragraph", "children": [{"text": "foo"}]},
+						{"type": "bulleted-list", "children": [
+							{"type": "list-item", "children": [{"text": "bar"}]}
+						]}
+					]},
+					{"type": "list-item", "children": [{"text": "baz"}]}
+				]}
+			]`,
+			txt: "1. foo\n   - bar\n2. baz",
+		},
+		// Case 6 ensures that list items outside of lists are rendered as
+		// bullets.
+		{
+			doc: `[
+				{"type": "list-item", "children": [{"text": "foo"}]}
+			]`,
+			txt: "- foo",
+		},
+		// Case 7 ensures that links are followed by their URL.
+		{
+			doc: `[
+				{"type": "paragraph", "children": [
+					{"text": "see "},
+					{"type": "link", "url": "https://venturemark.co", "children": [{"text": "here"}]},
+					{"text": " for more"}
+				]}
+			]`,
+			txt: "see here (https://venturemark.co) for more",
+		},
+		// Case 8 ensures that links showing their own URL are not repeated.
+		{
+			doc: `[
+				{"type": "paragraph", "children": [
+					{"type": "link", "url": "https://venturemark.co", "children": [{"text": "https://venturemark.co"}]}
+				]}
+			]`,
+			txt: "https://venturemark.co",
+		},
+		// Case 9 ensures that links within list items are followed by their
+		// URL.
+		{
+			doc: `[
+				{"type": "bulleted-list", "children": [
+					{"type": "list-item", "children": [
+						{"type": "link", "url": "https://venturemark.co", "children": [{"text": "foo"}]}
+					]}
+				]}
+			]`,
+			txt: "- foo (https://venturemark.co)",
+		},
+		// Case 10 ensures that unknown element types are rendered like
+		// paragraphs.
+		{
+			doc: `[
+				{"type": "unknown", "children": [{"text": "foo"}]}
+			]`,
+			txt: "foo",
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			txt, err := Slate(tc.doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if txt != tc.txt {
+				t.Fatalf("expected %q got %q", tc.txt, txt)
+			}
+		})
+	}
+}
+
+func Test_Render_Slate_Error(t *testing.T) {
+	_, err := Slate(`{"type": "paragraph"}`)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
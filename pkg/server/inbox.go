@@ -0,0 +1,177 @@
+package server
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/venturemark/apiworker/pkg/mailer/capture"
+)
+
+var inboxList = template.Must(template.New("list").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>apiworker inbox</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+td, th { border-bottom: 1px solid #ddd; padding: 0.5em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>Inbox</h1>
+{{ if . }}
+<table>
+<tr><th>Captured</th><th>From</th><th>To</th><th>Subject</th></tr>
+{{ range . }}
+<tr>
+<td>{{ .Captured.Format "2006-01-02 15:04:05" }}</td>
+<td>{{ .Message.From }}</td>
+<td>{{ .Message.To }}</td>
+<td><a href="/inbox/{{ .ID }}">{{ if .Message.Subject }}{{ .Message.Subject }}{{ else }}{{ .Message.Template }}{{ end }}</a></td>
+</tr>
+{{ end }}
+</table>
+{{ else }}
+<p>No messages captured yet.</p>
+{{ end }}
+</body>
+</html>
+`))
+
+var inboxShow = template.Must(template.New("show").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Entry.Message.Subject }}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+iframe { border: 1px solid #ddd; height: 40em; width: 100%; }
+pre { background: #f6f6f6; padding: 1em; white-space: pre-wrap; }
+td, th { padding: 0.25em 0.5em; text-align: left; vertical-align: top; }
+</style>
+</head>
+<body>
+<p><a href="/inbox">Back to inbox</a></p>
+<table>
+<tr><th>Captured</th><td>{{ .Entry.Captured.Format "2006-01-02 15:04:05" }}</td></tr>
+<tr><th>From</th><td>{{ .Entry.Message.From }}</td></tr>
+<tr><th>To</th><td>{{ .Entry.Message.To }}</td></tr>
+<tr><th>Subject</th><td>{{ .Entry.Message.Subject }}</td></tr>
+{{ if .Entry.Message.Template }}<tr><th>Template</th><td>{{ .Entry.Message.Template }}</td></tr>{{ end }}
+{{ range $k, $v := .Entry.Message.Header }}<tr><th>{{ $k }}</th><td>{{ $v }}</td></tr>{{ end }}
+</table>
+{{ if .Entry.Message.HTML }}
+<h2>HTML</h2>
+<iframe sandbox src="/inbox/{{ .Entry.ID }}/html"></iframe>
+{{ end }}
+{{ if .Entry.Message.Text }}
+<h2>Text</h2>
+<pre>{{ .Entry.Message.Text }}</pre>
+{{ end }}
+{{ if .Data }}
+<h2>Data</h2>
+<pre>{{ .Data }}</pre>
+{{ end }}
+</body>
+</html>
+`))
+
+// inbox serves the messages captured by the capture mailer. The inbox lists
+// all captured messages under /inbox and shows a single message under
+// /inbox/<id>. The HTML body of a message is served as it is under
+// /inbox/<id>/html, so that it can be rendered within a sandboxed iframe.
+func (s *Server) inbox(w http.ResponseWriter, r *http.Request) {
+	var id string
+	var sub string
+	{
+		p := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/inbox"), "/"), "/")
+
+		id = p[0]
+		if len(p) > 1 {
+			sub = p[1]
+		}
+	}
+
+	if id == "" {
+		ent, err := s.capture.List()
+		if err != nil {
+			s.logger.Log(r.Context(), "level", "error", "message", "failed to list captured messages", "error", err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		err = inboxList.Execute(w, ent)
+		if err != nil {
+			s.logger.Log(r.Context(), "level", "error", "message", "failed to render inbox", "error", err.Error())
+		}
+
+		return
+	}
+
+	ent, err := s.capture.Search(id)
+	if capture.IsNotFound(err) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		s.logger.Log(r.Context(), "level", "error", "message", "failed to search captured message", "error", err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	switch sub {
+	case "":
+		// Messages rendered by apiworker carry the data they got rendered
+		// with, while templated messages carry the model the backend renders
+		// them with.
+		var val interface{}
+		{
+			val = ent.Message.Data
+			if ent.Message.Template != "" {
+				val = ent.Message.Model
+			}
+		}
+
+		var dat string
+		if val != nil {
+			byt, err := json.MarshalIndent(val, "", "  ")
+			if err != nil {
+				s.logger.Log(r.Context(), "level", "error", "message", "failed to encode message data", "error", err.Error())
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			dat = string(byt)
+		}
+
+		tem := struct {
+			Data  string
+			Entry capture.Entry
+		}{
+			Data:  dat,
+			Entry: ent,
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		err = inboxShow.Execute(w, tem)
+		if err != nil {
+			s.logger.Log(r.Context(), "level", "error", "message", "failed to render captured message", "error", err.Error())
+		}
+
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		_, err = w.Write([]byte(ent.Message.HTML))
+		if err != nil {
+			s.logger.Log(r.Context(), "level", "error", "message", "failed to write captured message", "error", err.Error())
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -2,38 +2,83 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/pprof"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/xh3b4sd/logger"
 	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/controller"
+	"github.com/venturemark/apiworker/pkg/mailer/capture"
+	"github.com/venturemark/apiworker/pkg/render"
+	"github.com/venturemark/apiworker/pkg/suppression"
+	"github.com/venturemark/apiworker/pkg/unsubscribe"
 )
 
 type Config struct {
-	Collector []prometheus.Collector
-	Logger    logger.Interface
-
+	// Capture is the capture mailer whose messages are served by the inbox
+	// under /inbox. The inbox is not served if Capture is nil.
+	Capture    *capture.Mailer
+	Collector  []prometheus.Collector
+	Controller controller.Interface
+	Logger     logger.Interface
+	// Render is used to render the pages of the unsubscribe endpoint in the
+	// locale of the unsubscribing user. It must be given if Unsubscribe is.
+	Render *render.Render
+	// Suppression is used to suppress recipients reported by the postmark
+	// webhook under /webhook/postmark on the public http server. The webhook
+	// is not served if WebhookToken is empty.
+	Suppression *suppression.Store
+	// Unsubscribe is used to serve the unsubscribe endpoint under
+	// /unsubscribe on the public http server, see ListenPublic.
+	Unsubscribe *unsubscribe.Unsubscribe
+
+	// Debug enables the pprof endpoints under /debug/pprof/ and the controller
+	// state endpoint under /debug/tasks.
+	Debug    bool
 	ErrCha   chan<- error
 	HTTPHost string
 	HTTPPort string
+	// PublicHost and PublicPort are the address the public http server is
+	// bound to. Other than the metrics endpoints, the public endpoints are
+	// meant to be exposed to the internet.
+	PublicHost string
+	PublicPort string
+	// WebhookToken is the basic auth password postmark has to send along
+	// with webhook requests.
+	WebhookToken string
 }
 
 type Server struct {
-	collector []prometheus.Collector
-	logger    logger.Interface
-
-	errCha   chan<- error
-	httpHost string
-	httpPort string
+	capture     *capture.Mailer
+	collector   []prometheus.Collector
+	controller  controller.Interface
+	logger      logger.Interface
+	render      *render.Render
+	suppression *suppression.Store
+	unsub       *unsubscribe.Unsubscribe
+
+	debug        bool
+	errCha       chan<- error
+	httpHost     string
+	httpPort     string
+	pubHost      string
+	pubPort      string
+	webhookToken string
 }
 
 func New(config Config) (*Server, error) {
 	if len(config.Collector) == 0 {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Collector must not be empty", config)
 	}
+	if config.Controller == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Controller must not be empty", config)
+	}
 	if config.Logger == nil {
 		return nil, tracer.Maskf(invalidConfigError, "%T.Logger must not be empty", config)
 	}
@@ -47,14 +92,35 @@ func New(config Config) (*Server, error) {
 	if config.HTTPPort == "" {
 		return nil, tracer.Maskf(invalidConfigError, "%T.HTTPPort must not be empty", config)
 	}
+	if config.WebhookToken != "" && config.Suppression == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Suppression must not be empty", config)
+	}
+	if config.Unsubscribe != nil && config.Render == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Render must not be empty", config)
+	}
+	if (config.Unsubscribe != nil || config.WebhookToken != "") && config.PublicHost == "" {
+		return nil, tracer.Maskf(invalidConfigError, "%T.PublicHost must not be empty", config)
+	}
+	if (config.Unsubscribe != nil || config.WebhookToken != "") && config.PublicPort == "" {
+		return nil, tracer.Maskf(invalidConfigError, "%T.PublicPort must not be empty", config)
+	}
 
 	s := &Server{
-		collector: config.Collector,
-		logger:    config.Logger,
-
-		errCha:   config.ErrCha,
-		httpHost: config.HTTPHost,
-		httpPort: config.HTTPPort,
+		capture:     config.Capture,
+		collector:   config.Collector,
+		controller:  config.Controller,
+		logger:      config.Logger,
+		render:      config.Render,
+		suppression: config.Suppression,
+		unsub:       config.Unsubscribe,
+
+		debug:        config.Debug,
+		errCha:       config.ErrCha,
+		httpHost:     config.HTTPHost,
+		httpPort:     config.HTTPPort,
+		pubHost:      config.PublicHost,
+		pubPort:      config.PublicPort,
+		webhookToken: config.WebhookToken,
 	}
 
 	return s, nil
@@ -62,6 +128,7 @@ func New(config Config) (*Server, error) {
 
 func (s *Server) ListenHTTP() {
 	a := net.JoinHostPort(s.httpHost, s.httpPort)
+	m := http.NewServeMux()
 	r := prometheus.NewPedanticRegistry()
 
 	{
@@ -71,13 +138,68 @@ func (s *Server) ListenHTTP() {
 	}
 
 	{
-		http.Handle("/metrics", promhttp.HandlerFor(r, promhttp.HandlerOpts{}))
+		m.Handle("/metrics", promhttp.HandlerFor(r, promhttp.HandlerOpts{}))
+	}
+
+	if s.debug {
+		m.HandleFunc("/debug/pprof/", pprof.Index)
+		m.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		m.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		m.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		m.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+		m.HandleFunc("/debug/tasks", s.tasks)
+	}
+
+	if s.capture != nil {
+		m.HandleFunc("/inbox", s.inbox)
+		m.HandleFunc("/inbox/", s.inbox)
 	}
 
 	s.logger.Log(context.Background(), "level", "info", "message", fmt.Sprintf("http server running at %s", a))
 
 	{
-		err := http.ListenAndServe(a, nil)
+		err := http.ListenAndServe(a, m)
+		if err != nil {
+			s.errCha <- tracer.Mask(err)
+		}
+	}
+}
+
+func (s *Server) tasks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(s.controller.State())
+	if err != nil {
+		s.logger.Log(r.Context(), "level", "error", "message", "failed to encode controller state", "error", err.Error())
+	}
+}
+
+// ListenPublic serves the public http endpoints. Nothing is served if there
+// are no public endpoints enabled.
+func (s *Server) ListenPublic() {
+	uns := s.unsub != nil && s.unsub.Enabled()
+	whk := s.webhookToken != ""
+
+	if !uns && !whk {
+		return
+	}
+
+	a := net.JoinHostPort(s.pubHost, s.pubPort)
+	m := http.NewServeMux()
+
+	if uns {
+		m.HandleFunc("/unsubscribe", s.unsubscribe)
+	}
+
+	if whk {
+		m.HandleFunc("/webhook/postmark", s.webhook)
+	}
+
+	s.logger.Log(context.Background(), "level", "info", "message", fmt.Sprintf("public http server running at %s", a))
+
+	{
+		err := http.ListenAndServe(a, m)
 		if err != nil {
 			s.errCha <- tracer.Mask(err)
 		}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/unsubscribe"
+)
+
+var unsubscribeConfirm = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Title }}</title>
+</head>
+<body style="font-family:sans-serif;margin:2em">
+<p>{{ .Text }}</p>
+<form method="post" action="/unsubscribe?token={{ .Token }}">
+<button type="submit">{{ .Button }}</button>
+</form>
+</body>
+</html>
+`))
+
+var unsubscribeDone = template.Must(template.New("done").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Title }}</title>
+</head>
+<body style="font-family:sans-serif;margin:2em">
+<p>{{ .Text }}</p>
+</body>
+</html>
+`))
+
+// unsubscribePage is the data the unsubscribe pages are rendered with. All
+// texts are rendered in the locale of the user the token got issued for.
+type unsubscribePage struct {
+	Button string
+	Text   string
+	Title  string
+	Token  string
+}
+
+// unsubscribe opts users out of notifications. GET requests only render a
+// confirmation form, because mail clients and link scanners tend to prefetch
+// links. POST requests verify the token and change the user's preferences.
+// This also serves one-click unsubscribe requests as defined by RFC 8058.
+func (s *Server) unsubscribe(w http.ResponseWriter, r *http.Request) {
+	var tok string
+	{
+		tok = r.URL.Query().Get("token")
+	}
+
+	if tok == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var loc string
+	{
+		l, err := s.unsub.Locale(tok)
+		if err != nil {
+			s.logger.Log(r.Context(), "level", "error", "message", "failed to search unsubscribe locale", "error", err.Error())
+		}
+
+		loc = l
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		pag, err := s.unsubscribePage(loc, "unsubscribe.confirm")
+		if err != nil {
+			s.logger.Log(r.Context(), "level", "error", "message", "failed to render unsubscribe form", "error", err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		pag.Token = tok
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		err = unsubscribeConfirm.Execute(w, pag)
+		if err != nil {
+			s.logger.Log(r.Context(), "level", "error", "message", "failed to render unsubscribe form", "error", err.Error())
+		}
+
+	case http.MethodPost:
+		err := s.unsub.Execute(tok)
+		if unsubscribe.IsInvalidToken(err) {
+			msg, err := s.render.Message(loc, "unsubscribe.invalid")
+			if err != nil {
+				s.logger.Log(r.Context(), "level", "error", "message", "failed to render unsubscribe error", "error", err.Error())
+				msg = http.StatusText(http.StatusBadRequest)
+			}
+
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		} else if err != nil {
+			s.logger.Log(r.Context(), "level", "error", "message", "failed to unsubscribe", "error", err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		pag, err := s.unsubscribePage(loc, "unsubscribe.done")
+		if err != nil {
+			s.logger.Log(r.Context(), "level", "error", "message", "failed to render unsubscribe confirmation", "error", err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		err = unsubscribeDone.Execute(w, pag)
+		if err != nil {
+			s.logger.Log(r.Context(), "level", "error", "message", "failed to render unsubscribe confirmation", "error", err.Error())
+		}
+
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// unsubscribePage renders the texts of the unsubscribe page of which the
+// catalog messages share the given prefix, e.g. unsubscribe.confirm. Pages
+// without button leave it empty.
+func (s *Server) unsubscribePage(loc string, pre string) (unsubscribePage, error) {
+	var err error
+
+	var pag unsubscribePage
+	{
+		pag.Text, err = s.render.Message(loc, pre+".text")
+		if err != nil {
+			return unsubscribePage{}, tracer.Mask(err)
+		}
+
+		pag.Title, err = s.render.Message(loc, pre+".title")
+		if err != nil {
+			return unsubscribePage{}, tracer.Mask(err)
+		}
+	}
+
+	if pre == "unsubscribe.confirm" {
+		pag.Button, err = s.render.Message(loc, pre+".button")
+		if err != nil {
+			return unsubscribePage{}, tracer.Mask(err)
+		}
+	}
+
+	return pag, nil
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/venturemark/apiworker/pkg/suppression"
+)
+
+const (
+	// webhookLimit is the maximum size of webhook payloads in bytes.
+	webhookLimit = 1 << 20
+)
+
+// postmarkEvent is the subset of the bounce and spam complaint webhook
+// payloads of postmark needed to suppress recipients, see
+// https://postmarkapp.com/developer/webhooks/bounce-webhook.
+type postmarkEvent struct {
+	Email      string `json:"Email"`
+	Inactive   bool   `json:"Inactive"`
+	RecordType string `json:"RecordType"`
+	Type       string `json:"Type"`
+}
+
+// reason returns the suppression reason of the event, or an empty string if
+// the event should not cause a suppression, e.g. for soft bounces.
+func (e postmarkEvent) reason() string {
+	switch e.RecordType {
+	case "Bounce":
+		if e.Type == "HardBounce" || e.Inactive {
+			return suppression.ReasonBounce
+		}
+	case "SpamComplaint":
+		return suppression.ReasonSpamComplaint
+	}
+
+	return ""
+}
+
+// webhook receives the bounce and spam complaint webhooks of postmark and
+// suppresses the affected recipients. Postmark has to be configured to send
+// the webhook token as basic auth password. All other events are accepted
+// and ignored, so that postmark does not retry them.
+func (s *Server) webhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	{
+		_, pas, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(pas), []byte(s.webhookToken)) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var eve postmarkEvent
+	{
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, webhookLimit)).Decode(&eve)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if eve.Email == "" || eve.reason() == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	{
+		e := &suppression.Entry{
+			Address: eve.Email,
+			Created: time.Now().UTC(),
+			Reason:  eve.reason(),
+		}
+
+		err := s.suppression.Create(e)
+		if err != nil {
+			s.logger.Log(r.Context(), "level", "error", "message", "failed to suppress recipient", "error", err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	s.logger.Log(r.Context(), "level", "info", "message", "suppressed recipient", "reason", eve.reason())
+
+	w.WriteHeader(http.StatusOK)
+}
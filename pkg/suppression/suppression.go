@@ -0,0 +1,113 @@
+package suppression
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/redigo/pkg/simple"
+	"github.com/xh3b4sd/tracer"
+)
+
+const (
+	// Prefix is the key prefix under which suppressed email addresses are
+	// persisted. The full key of a suppression is the prefix followed by the
+	// normalised email address, see Key.
+	Prefix = "apiworker.venturemark.co:sup"
+)
+
+const (
+	// ReasonBounce is the reason of suppressions caused by hard bounces
+	// reported via webhooks.
+	ReasonBounce = "bounce"
+	// ReasonInactive is the reason of suppressions caused by the mail
+	// backend rejecting a message because it knows the recipient to be
+	// inactive.
+	ReasonInactive = "inactive"
+	// ReasonSpamComplaint is the reason of suppressions caused by recipients
+	// marking a message as spam.
+	ReasonSpamComplaint = "spam-complaint"
+)
+
+// Entry is a single suppressed email address. No emails are sent to the
+// address as long as the suppression exists.
+type Entry struct {
+	Address string    `json:"address"`
+	Created time.Time `json:"created"`
+	Reason  string    `json:"reason"`
+}
+
+type Config struct {
+	Redigo redigo.Interface
+}
+
+// Store persists suppressed email addresses in redis.
+type Store struct {
+	redigo redigo.Interface
+}
+
+func New(config Config) (*Store, error) {
+	if config.Redigo == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Redigo must not be empty", config)
+	}
+
+	s := &Store{
+		redigo: config.Redigo,
+	}
+
+	return s, nil
+}
+
+// Create suppresses the address of the given entry. An existing suppression
+// of the same address is overwritten.
+func (s *Store) Create(e *Entry) error {
+	byt, err := json.Marshal(e)
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	err = s.redigo.Simple().Create().Element(Key(e.Address), string(byt))
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	return nil
+}
+
+// Delete lifts the suppression of the given address. Emails are sent to the
+// address again afterwards.
+func (s *Store) Delete(add string) error {
+	err := s.redigo.Simple().Delete().Element(Key(add))
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	return nil
+}
+
+// Search returns the suppression of the given address. An error matched by
+// IsNotFound is returned if the address is not suppressed.
+func (s *Store) Search(add string) (*Entry, error) {
+	val, err := s.redigo.Simple().Search().Value(Key(add))
+	if simple.IsNotFound(err) {
+		return nil, tracer.Maskf(notFoundError, "address %s is not suppressed", add)
+	} else if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	e := &Entry{}
+	err = json.Unmarshal([]byte(val), e)
+	if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	return e, nil
+}
+
+// Key returns the simple key holding the suppression of the given address.
+// Addresses are compared case insensitively.
+func Key(add string) string {
+	return fmt.Sprintf("%s:%s", Prefix, strings.ToLower(strings.TrimSpace(add)))
+}
@@ -0,0 +1,490 @@
+package tombstone
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/redigo/pkg/simple"
+	"github.com/xh3b4sd/rescue/pkg/task"
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/handler"
+	"github.com/venturemark/apiworker/pkg/transaction"
+)
+
+const (
+	// Key is the sorted set holding all tombstones. Tombstones are scored by
+	// the unix nano timestamp at which their grace period ends. Scores are
+	// not unique, so tombstones are always removed by member.
+	Key = "apiworker.venturemark.co:tom"
+	// IndexPrefix is the key prefix of the sorted sets indexing tombstones by
+	// the resource they belong to. Every tombstone is indexed for the
+	// resource it got buried for and for all of its parent resources, so
+	// that restoring a resource finds its own tombstones and the ones of its
+	// children without reading all tombstones. The index sets are scored
+	// like Key.
+	IndexPrefix = "apiworker.venturemark.co:toi"
+	// Restored is the sorted set holding the paths of restored resources,
+	// see Path, scored by the unix nano timestamp of their restore. Delete
+	// tasks enqueued for the children of a resource before it got restored
+	// are dropped, see IsRestored.
+	Restored = "apiworker.venturemark.co:tor"
+)
+
+const (
+	// page is the number of tombstones read from redis at once when
+	// searching tombstones.
+	page = 100
+)
+
+const (
+	KindSimple = "simple"
+	KindSorted = "sorted"
+)
+
+var (
+	// level lists the resource IDs making up the path of a resource, from
+	// the outermost resource to the innermost one.
+	level = []struct {
+		Key  string
+		Name string
+	}{
+		{Key: metadata.VentureID, Name: "ven"},
+		{Key: metadata.TimelineID, Name: "tim"},
+		{Key: metadata.UpdateID, Name: "upd"},
+		{Key: metadata.MessageID, Name: "mes"},
+	}
+)
+
+// Entry is the tombstone of a single deleted resource. It carries the
+// metadata of the deletion task, so that tombstones can be looked up by
+// resource IDs, and all the elements that got removed from redis, so that
+// they can be put back on restore.
+type Entry struct {
+	Deadline int64             `json:"deadline"`
+	Element  []*Element        `json:"element"`
+	Metadata map[string]string `json:"metadata"`
+
+	// member is the raw value the entry is stored with in the sorted set,
+	// if the entry got read from redis.
+	member string
+}
+
+type Element struct {
+	Key   string  `json:"key"`
+	Kind  string  `json:"kind"`
+	Score float64 `json:"score,omitempty"`
+	Value string  `json:"value"`
+}
+
+type Config struct {
+	Redigo      redigo.Interface
+	Transaction transaction.Interface
+
+	// Grace is the period of time after which tombstones expire and their
+	// data gets irrecoverable. Soft deletion is disabled when Grace is zero.
+	Grace time.Duration
+}
+
+type Tombstone struct {
+	redigo      redigo.Interface
+	transaction transaction.Interface
+
+	grace time.Duration
+}
+
+func New(config Config) (*Tombstone, error) {
+	if config.Redigo == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Redigo must not be empty", config)
+	}
+	if config.Transaction == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Transaction must not be empty", config)
+	}
+
+	t := &Tombstone{
+		redigo:      config.Redigo,
+		transaction: config.Transaction,
+
+		grace: config.Grace,
+	}
+
+	return t, nil
+}
+
+// Bury returns the operations writing a tombstone for the given elements.
+// Callers are meant to execute them within the same transaction that deletes
+// the elements. Elements being nil are ignored, so that callers can pass
+// through the results of Simple and Sorted for data that does not exist
+// anymore.
+func (t *Tombstone) Bury(met map[string]string, ele ...*Element) ([]transaction.Operation, error) {
+	var e *Entry
+	{
+		e = &Entry{
+			Deadline: time.Now().UTC().Add(t.grace).UnixNano(),
+			Metadata: map[string]string{},
+		}
+
+		for k, v := range met {
+			e.Metadata[k] = v
+		}
+
+		for _, l := range ele {
+			if l != nil {
+				e.Element = append(e.Element, l)
+			}
+		}
+	}
+
+	if len(e.Element) == 0 {
+		return nil, nil
+	}
+
+	var ops []transaction.Operation
+	{
+		byt, err := json.Marshal(e)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		ops = append(ops, transaction.SortedCreate(Key, string(byt), float64(e.Deadline)))
+
+		for _, p := range paths(e.Metadata) {
+			ops = append(ops, transaction.SortedCreate(Index(p), string(byt), float64(e.Deadline)))
+		}
+	}
+
+	return ops, nil
+}
+
+// Delete removes the given tombstone, which makes its data irrecoverable.
+func (t *Tombstone) Delete(e *Entry) error {
+	ops, err := remove(e)
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	err = t.transaction.Execute(ops...)
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	return nil
+}
+
+// Enabled expresses whether deletion handlers should write tombstones before
+// deleting data.
+func (t *Tombstone) Enabled() bool {
+	return t.grace != 0
+}
+
+// Expired returns all tombstones of which the grace period ended.
+func (t *Tombstone) Expired() ([]*Entry, error) {
+	str, err := t.redigo.Sorted().Search().Score(Key, 0, float64(time.Now().UTC().UnixNano()))
+	if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	ent, err := unmarshal(str)
+	if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	return ent, nil
+}
+
+// Restore writes all elements of the given tombstone back to their original
+// keys and removes the tombstone within the same transaction.
+func (t *Tombstone) Restore(e *Entry) error {
+	var ops []transaction.Operation
+
+	for _, l := range e.Element {
+		switch l.Kind {
+		case KindSimple:
+			ops = append(ops, transaction.SimpleCreate(l.Key, l.Value))
+		case KindSorted:
+			ops = append(ops, transaction.SortedCreate(l.Key, l.Value, l.Score))
+		}
+	}
+
+	{
+		o, err := remove(e)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		ops = append(ops, o...)
+	}
+
+	{
+		err := t.transaction.Execute(ops...)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	return nil
+}
+
+// IsRestored returns whether the given task got enqueued by the deletion of a
+// parent resource, see handler.Cascade, and the resource the task belongs to,
+// or any of its parent resources, got restored afterwards. Such tasks must be
+// dropped, since they would delete restored data again.
+func (t *Tombstone) IsRestored(tsk *task.Task) (bool, error) {
+	var since time.Time
+	{
+		c, ok := handler.CascadeTime(tsk)
+		if !ok {
+			return false, nil
+		}
+
+		since = c
+	}
+
+	var met map[string]string
+	{
+		met = tsk.Obj.Metadata
+	}
+
+	var p string
+	{
+		p = Path(met)
+	}
+
+	if p == "" {
+		return false, nil
+	}
+
+	str, err := t.redigo.Sorted().Search().Score(Restored, float64(since.UnixNano()), math.MaxFloat64)
+	if err != nil {
+		return false, tracer.Mask(err)
+	}
+
+	for _, s := range str {
+		if p == s || strings.HasPrefix(p, s+":") {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Mark records the restore of the resource described by the given metadata,
+// so that delete tasks enqueued for its children before the restore can be
+// dropped, see IsRestored. Marks are kept for the grace period, see Prune.
+func (t *Tombstone) Mark(met map[string]string) error {
+	var p string
+	{
+		p = Path(met)
+	}
+
+	if p == "" {
+		return nil
+	}
+
+	err := t.transaction.Execute(transaction.SortedCreate(Restored, p, float64(time.Now().UTC().UnixNano())))
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	return nil
+}
+
+// Prune removes all restore marks older than the grace period. Delete tasks
+// are expected to be executed long before, so that there is nothing left to
+// drop for them.
+func (t *Tombstone) Prune() (int, error) {
+	str, err := t.redigo.Sorted().Search().Score(Restored, 0, float64(time.Now().UTC().Add(-t.grace).UnixNano()))
+	if err != nil {
+		return 0, tracer.Mask(err)
+	}
+
+	if len(str) == 0 {
+		return 0, nil
+	}
+
+	var ops []transaction.Operation
+	for _, s := range str {
+		ops = append(ops, transaction.SortedRemove(Restored, s))
+	}
+
+	err = t.transaction.Execute(ops...)
+	if err != nil {
+		return 0, tracer.Mask(err)
+	}
+
+	return len(str), nil
+}
+
+// Search returns the tombstones of the resource described by the given
+// metadata and of all of its children, by reading the index of the resource.
+// Searching for a venture ID returns the tombstones of the venture and all of
+// its timelines, updates, messages and roles. Note that tombstones buried
+// before the index got introduced are not found and expire with their grace
+// period.
+func (t *Tombstone) Search(met map[string]string) ([]*Entry, error) {
+	var k string
+	{
+		p := Path(met)
+		if p == "" {
+			return nil, nil
+		}
+
+		k = Index(p)
+	}
+
+	var res []*Entry
+
+	for i := 0; ; i += page {
+		str, err := t.redigo.Sorted().Search().Order(k, i, i+page-1)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		ent, err := unmarshal(str)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		res = append(res, ent...)
+
+		if len(str) < page {
+			break
+		}
+	}
+
+	return res, nil
+}
+
+// Simple returns the element stored at the given simple key, or nil if the
+// key does not exist.
+func (t *Tombstone) Simple(k string) (*Element, error) {
+	val, err := t.redigo.Simple().Search().Value(k)
+	if simple.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	e := &Element{
+		Key:   k,
+		Kind:  KindSimple,
+		Value: val,
+	}
+
+	return e, nil
+}
+
+// Sorted returns the element stored at the given score of the given sorted
+// set, or nil if there is no such element.
+func (t *Tombstone) Sorted(k string, s float64) (*Element, error) {
+	val, err := t.redigo.Sorted().Search().Score(k, s, s)
+	if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	if len(val) == 0 {
+		return nil, nil
+	}
+
+	e := &Element{
+		Key:   k,
+		Kind:  KindSorted,
+		Score: s,
+		Value: val[0],
+	}
+
+	return e, nil
+}
+
+// Index returns the sorted set indexing the tombstones of the resource with
+// the given path, see Path.
+func Index(p string) string {
+	return fmt.Sprintf("%s:%s", IndexPrefix, p)
+}
+
+// Path returns the path of the resource described by the given metadata, e.g.
+// ven:1:tim:3 for a timeline. The path is made up of the resource IDs of the
+// resource and all of its parents, and is empty if the metadata lacks a
+// venture ID. The paths of parent resources are prefixes of the paths of
+// their children.
+func Path(met map[string]string) string {
+	var l []string
+	for _, v := range level {
+		i := met[v.Key]
+		if i == "" {
+			break
+		}
+
+		l = append(l, v.Name, i)
+	}
+
+	return strings.Join(l, ":")
+}
+
+// paths returns the path of the resource described by the given metadata and
+// the paths of all of its parents.
+func paths(met map[string]string) []string {
+	var l []string
+	var p []string
+	for _, v := range level {
+		i := met[v.Key]
+		if i == "" {
+			break
+		}
+
+		p = append(p, v.Name, i)
+		l = append(l, strings.Join(p, ":"))
+	}
+
+	return l
+}
+
+// remove returns the operations removing the given tombstone from the sorted
+// set and from the indices of its resource. Tombstones buried within the same
+// batch may share their deadline, so tombstones are removed by member and
+// never by score.
+func remove(e *Entry) ([]transaction.Operation, error) {
+	m := e.member
+
+	if m == "" {
+		byt, err := json.Marshal(e)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		m = string(byt)
+	}
+
+	ops := []transaction.Operation{
+		transaction.SortedRemove(Key, m),
+	}
+
+	for _, p := range paths(e.Metadata) {
+		ops = append(ops, transaction.SortedRemove(Index(p), m))
+	}
+
+	return ops, nil
+}
+
+func unmarshal(str []string) ([]*Entry, error) {
+	var ent []*Entry
+
+	for _, s := range str {
+		e := &Entry{}
+		err := json.Unmarshal([]byte(s), e)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		e.member = s
+
+		ent = append(ent, e)
+	}
+
+	return ent, nil
+}
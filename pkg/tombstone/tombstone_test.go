@@ -0,0 +1,80 @@
+package tombstone
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/venturemark/apicommon/pkg/metadata"
+)
+
+func Test_Tombstone_Path(t *testing.T) {
+	testCases := []struct {
+		met map[string]string
+		pat string
+		pas []string
+	}{
+		// Case 0 ensures that metadata without venture ID has no path.
+		{
+			met: map[string]string{
+				metadata.TimelineID: "2",
+			},
+			pat: "",
+			pas: nil,
+		},
+		// Case 1 ensures that the path of a venture is made up of its ID.
+		{
+			met: map[string]string{
+				metadata.VentureID: "1",
+			},
+			pat: "ven:1",
+			pas: []string{"ven:1"},
+		},
+		// Case 2 ensures that the paths of messages include all parents.
+		{
+			met: map[string]string{
+				metadata.MessageID:  "4",
+				metadata.TimelineID: "2",
+				metadata.UpdateID:   "3",
+				metadata.VentureID:  "1",
+			},
+			pat: "ven:1:tim:2:upd:3:mes:4",
+			pas: []string{"ven:1", "ven:1:tim:2", "ven:1:tim:2:upd:3", "ven:1:tim:2:upd:3:mes:4"},
+		},
+		// Case 3 ensures that IDs below a missing level are ignored, e.g. for
+		// roles of timelines.
+		{
+			met: map[string]string{
+				metadata.RoleID:     "5",
+				metadata.TimelineID: "2",
+				metadata.UpdateID:   "3",
+				metadata.VentureID:  "1",
+			},
+			pat: "ven:1:tim:2:upd:3",
+			pas: []string{"ven:1", "ven:1:tim:2", "ven:1:tim:2:upd:3"},
+		},
+		// Case 4 ensures that IDs following a gap are ignored.
+		{
+			met: map[string]string{
+				metadata.UpdateID:  "3",
+				metadata.VentureID: "1",
+			},
+			pat: "ven:1",
+			pas: []string{"ven:1"},
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			pat := Path(tc.met)
+			if pat != tc.pat {
+				t.Fatalf("expected %q, got %q", tc.pat, pat)
+			}
+
+			pas := paths(tc.met)
+			if !reflect.DeepEqual(pas, tc.pas) {
+				t.Fatalf("expected %v, got %v", tc.pas, pas)
+			}
+		})
+	}
+}
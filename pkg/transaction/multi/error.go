@@ -0,0 +1,43 @@
+package multi
+
+import (
+	"errors"
+
+	"github.com/xh3b4sd/tracer"
+)
+
+var invalidConfigError = &tracer.Error{
+	Kind: "invalidConfigError",
+}
+
+func IsInvalidConfig(err error) bool {
+	return errors.Is(err, invalidConfigError)
+}
+
+var sentinelError = &tracer.Error{
+	Kind: "sentinelError",
+}
+
+func IsSentinel(err error) bool {
+	return errors.Is(err, sentinelError)
+}
+
+// executionError is returned when redis rejected a transaction as a whole, or
+// any of the commands within a transaction failed.
+var executionError = &tracer.Error{
+	Kind: "executionError",
+}
+
+func IsExecution(err error) bool {
+	return errors.Is(err, executionError)
+}
+
+// conflictError is returned when keys watched by a transaction kept getting
+// modified concurrently.
+var conflictError = &tracer.Error{
+	Kind: "conflictError",
+}
+
+func IsConflict(err error) bool {
+	return errors.Is(err, conflictError)
+}
@@ -0,0 +1,220 @@
+package multi
+
+import (
+	"net"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/transaction"
+)
+
+const (
+	// attempts is the number of times Watch derives and executes operations
+	// before giving up on keys being modified concurrently.
+	attempts = 3
+)
+
+type PoolConfig struct {
+	// Address is the address of the redis instance, or of a sentinel in
+	// case Kind is sentinel.
+	Address string
+	Kind    string
+	// Master is the name of the master monitored by the sentinel, in case
+	// Kind is sentinel.
+	Master string
+}
+
+// NewPool returns the connection pool transactions are executed with. The
+// pool is meant to be configured with the same redis settings as the redigo
+// client of the process, so that transactions and ordinary reads and writes
+// always talk to the same redis master.
+func NewPool(config PoolConfig) (*redis.Pool, error) {
+	if config.Address == "" {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Address must not be empty", config)
+	}
+	if config.Kind != "single" && config.Kind != "sentinel" {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Kind must be single or sentinel", config)
+	}
+	if config.Kind == "sentinel" && config.Master == "" {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Master must not be empty", config)
+	}
+
+	p := &redis.Pool{
+		MaxIdle:     2,
+		IdleTimeout: time.Minute,
+		Dial:        dial(config.Address, config.Kind, config.Master),
+	}
+
+	return p, nil
+}
+
+type TransactionConfig struct {
+	// Pool is the connection pool transactions are executed with, see
+	// NewPool.
+	Pool *redis.Pool
+}
+
+type Transaction struct {
+	pool *redis.Pool
+}
+
+func NewTransaction(config TransactionConfig) (*Transaction, error) {
+	if config.Pool == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Pool must not be empty", config)
+	}
+
+	t := &Transaction{
+		pool: config.Pool,
+	}
+
+	return t, nil
+}
+
+func (t *Transaction) Execute(ops ...transaction.Operation) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	con := t.pool.Get()
+	defer con.Close()
+
+	err := exec(con, ops)
+	if IsConflict(err) {
+		return tracer.Maskf(executionError, "transaction got aborted")
+	} else if err != nil {
+		return tracer.Mask(err)
+	}
+
+	return nil
+}
+
+func (t *Transaction) Watch(keys []string, fun func() ([]transaction.Operation, error)) error {
+	for i := 0; i < attempts; i++ {
+		err := t.watch(keys, fun)
+		if IsConflict(err) {
+			continue
+		} else if err != nil {
+			return tracer.Mask(err)
+		}
+
+		return nil
+	}
+
+	return tracer.Maskf(conflictError, "watched keys kept changing for %d attempts", attempts)
+}
+
+// watch executes a single attempt of Watch. Should fun fail, the keys stay
+// watched until the connection is returned to the pool, which unwatches them.
+func (t *Transaction) watch(keys []string, fun func() ([]transaction.Operation, error)) error {
+	con := t.pool.Get()
+	defer con.Close()
+
+	if len(keys) != 0 {
+		_, err := con.Do("WATCH", redis.Args{}.AddFlat(keys)...)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	ops, err := fun()
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	if len(ops) == 0 {
+		_, err = con.Do("UNWATCH")
+		if err != nil {
+			return tracer.Mask(err)
+		}
+
+		return nil
+	}
+
+	err = exec(con, ops)
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	return nil
+}
+
+// exec applies the given operations within a single MULTI/EXEC block on the
+// given connection. A conflict error is returned if redis aborted the
+// transaction because watched keys got modified.
+func exec(con redis.Conn, ops []transaction.Operation) error {
+	{
+		err := con.Send("MULTI")
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	for _, o := range ops {
+		err := con.Send(o.Command, o.Arguments...)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	// Commands which cannot be queued, e.g. due to a wrong number of
+	// arguments, cause EXEC to fail as a whole and nothing is applied. Commands
+	// failing at runtime, e.g. due to WRONGTYPE, do not affect the other
+	// commands of the transaction, so their errors are only part of the reply
+	// of EXEC and must be looked up one by one.
+	var rep []interface{}
+	{
+		var err error
+		rep, err = redis.Values(con.Do("EXEC"))
+		if err == redis.ErrNil {
+			return tracer.Maskf(conflictError, "transaction got aborted")
+		} else if err != nil {
+			return tracer.Maskf(executionError, "%s", err.Error())
+		}
+	}
+
+	for i, r := range rep {
+		e, ok := r.(redis.Error)
+		if ok {
+			return tracer.Maskf(executionError, "%s %s", ops[i].Command, e.Error())
+		}
+	}
+
+	return nil
+}
+
+// dial returns the dial function of the connection pool. In case of a
+// sentinel setup the address of the current master is looked up for every new
+// connection, so that the pool follows failovers.
+func dial(address string, kind string, master string) func() (redis.Conn, error) {
+	return func() (redis.Conn, error) {
+		a := address
+
+		if kind == "sentinel" {
+			con, err := redis.Dial("tcp", address)
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+			defer con.Close()
+
+			res, err := redis.Strings(con.Do("SENTINEL", "get-master-addr-by-name", master))
+			if err != nil {
+				return nil, tracer.Mask(err)
+			}
+
+			if len(res) != 2 {
+				return nil, tracer.Maskf(sentinelError, "master %s not found", master)
+			}
+
+			a = net.JoinHostPort(res[0], res[1])
+		}
+
+		con, err := redis.Dial("tcp", a)
+		if err != nil {
+			return nil, tracer.Mask(err)
+		}
+
+		return con, nil
+	}
+}
@@ -0,0 +1,201 @@
+package multi
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/venturemark/apiworker/pkg/transaction"
+)
+
+// conn is a fake redis connection recording the commands sent to it and
+// replying to EXEC with the configured reply.
+type conn struct {
+	cmd []string
+	err error
+	rep interface{}
+}
+
+func (c *conn) Close() error { return nil }
+func (c *conn) Err() error   { return nil }
+
+func (c *conn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "" {
+		return nil, nil
+	}
+
+	c.cmd = append(c.cmd, cmd)
+
+	if cmd == "EXEC" {
+		return c.rep, c.err
+	}
+
+	return "OK", nil
+}
+
+func (c *conn) Flush() error                  { return nil }
+func (c *conn) Receive() (interface{}, error) { return nil, nil }
+
+func (c *conn) Send(cmd string, args ...interface{}) error {
+	c.cmd = append(c.cmd, cmd)
+	return nil
+}
+
+func newTestTransaction(t *testing.T, c *conn) *Transaction {
+	p := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return c, nil
+		},
+	}
+
+	tra, err := NewTransaction(TransactionConfig{Pool: p})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tra
+}
+
+func Test_Transaction_Execute(t *testing.T) {
+	ops := []transaction.Operation{
+		transaction.SimpleCreate("foo", "bar"),
+		transaction.SortedCreate("baz", "bar", 1),
+	}
+
+	testCases := []struct {
+		name string
+		rep  interface{}
+		err  error
+		exe  bool
+	}{
+		{
+			name: "case 0: all operations succeed",
+			rep:  []interface{}{"OK", int64(1)},
+			exe:  false,
+		},
+		{
+			name: "case 1: a single operation fails at runtime",
+			rep:  []interface{}{"OK", redis.Error("WRONGTYPE Operation against a key holding the wrong kind of value")},
+			exe:  true,
+		},
+		{
+			name: "case 2: an operation could not be queued",
+			err:  redis.Error("EXECABORT Transaction discarded because of previous errors."),
+			exe:  true,
+		},
+		{
+			name: "case 3: the transaction got aborted",
+			rep:  nil,
+			exe:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &conn{
+				err: tc.err,
+				rep: tc.rep,
+			}
+
+			err := newTestTransaction(t, c).Execute(ops...)
+			if IsExecution(err) != tc.exe {
+				t.Fatalf("expected execution error %t, got %#v", tc.exe, err)
+			}
+			if !tc.exe && err != nil {
+				t.Fatalf("expected no error, got %#v", err)
+			}
+
+			cmd := []string{"MULTI", "SET", "ZADD", "EXEC"}
+			if !reflect.DeepEqual(c.cmd, cmd) {
+				t.Fatalf("expected commands %v, got %v", cmd, c.cmd)
+			}
+		})
+	}
+}
+
+func Test_Transaction_Execute_Empty(t *testing.T) {
+	c := &conn{}
+
+	err := newTestTransaction(t, c).Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.cmd) != 0 {
+		t.Fatalf("expected no commands, got %v", c.cmd)
+	}
+}
+
+func Test_Transaction_Watch(t *testing.T) {
+	ops := []transaction.Operation{
+		transaction.SimpleCreate("foo", "bar"),
+		transaction.SortedCreate("baz", "bar", 1),
+	}
+
+	testCases := []struct {
+		name string
+		rep  interface{}
+		ops  []transaction.Operation
+		con  bool
+		cal  int
+		cmd  []string
+	}{
+		{
+			name: "case 0: watched keys did not change",
+			rep:  []interface{}{"OK", int64(1)},
+			ops:  ops,
+			con:  false,
+			cal:  1,
+			cmd:  []string{"WATCH", "MULTI", "SET", "ZADD", "EXEC"},
+		},
+		{
+			name: "case 1: watched keys kept changing",
+			rep:  nil,
+			ops:  ops,
+			con:  true,
+			cal:  attempts,
+			cmd: []string{
+				"WATCH", "MULTI", "SET", "ZADD", "EXEC",
+				"WATCH", "MULTI", "SET", "ZADD", "EXEC",
+				"WATCH", "MULTI", "SET", "ZADD", "EXEC",
+			},
+		},
+		{
+			name: "case 2: there is nothing to execute",
+			ops:  nil,
+			con:  false,
+			cal:  1,
+			cmd:  []string{"WATCH", "UNWATCH"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &conn{
+				rep: tc.rep,
+			}
+
+			var cal int
+			fun := func() ([]transaction.Operation, error) {
+				cal++
+				return tc.ops, nil
+			}
+
+			err := newTestTransaction(t, c).Watch([]string{"foo"}, fun)
+			if IsConflict(err) != tc.con {
+				t.Fatalf("expected conflict error %t, got %#v", tc.con, err)
+			}
+			if !tc.con && err != nil {
+				t.Fatalf("expected no error, got %#v", err)
+			}
+
+			if cal != tc.cal {
+				t.Fatalf("expected %d calls, got %d", tc.cal, cal)
+			}
+			if !reflect.DeepEqual(c.cmd, tc.cmd) {
+				t.Fatalf("expected commands %v, got %v", tc.cmd, c.cmd)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package transaction
+
+import "time"
+
+type Interface interface {
+	// Execute applies all the given operations within a single MULTI/EXEC
+	// block, so that no other client observes a partial state. Operations
+	// which cannot be queued cause none of them to take effect. Redis does not
+	// roll back operations failing at runtime though, e.g. due to WRONGTYPE,
+	// so Execute returns the first such error, while the other operations
+	// may have taken effect.
+	Execute(ops ...Operation) error
+	// Watch applies the operations returned by fun like Execute, but only if
+	// none of the given keys got modified after fun started. All reads the
+	// operations are derived from must therefore happen within fun, and all
+	// keys read must be watched. Should any of them be modified concurrently,
+	// fun is called again, so that the operations are derived from the
+	// current state. An error is returned if the keys keep changing.
+	Watch(keys []string, fun func() ([]Operation, error)) error
+}
+
+type Operation struct {
+	Command   string
+	Arguments []interface{}
+}
+
+// Delete removes the given key, regardless whether it is a simple key or a
+// sorted set.
+func Delete(k string) Operation {
+	return Operation{Command: "DEL", Arguments: []interface{}{k}}
+}
+
+// SimpleCreate sets the value of the given simple key.
+func SimpleCreate(k string, v string) Operation {
+	return Operation{Command: "SET", Arguments: []interface{}{k, v}}
+}
+
+// SimpleExpire sets the value of the given simple key, which expires after the
+// given ttl.
+func SimpleExpire(k string, v string, ttl time.Duration) Operation {
+	return Operation{Command: "SET", Arguments: []interface{}{k, v, "PX", ttl.Milliseconds()}}
+}
+
+// SortedCreate adds the given element with the given score to the given
+// sorted set.
+func SortedCreate(k string, v string, s float64) Operation {
+	return Operation{Command: "ZADD", Arguments: []interface{}{k, s, v}}
+}
+
+// SortedDelete removes the element with the given score from the given
+// sorted set.
+func SortedDelete(k string, s float64) Operation {
+	return Operation{Command: "ZREMRANGEBYSCORE", Arguments: []interface{}{k, s, s}}
+}
+
+// SortedRemove removes the given element from the given sorted set,
+// regardless of its score.
+func SortedRemove(k string, v string) Operation {
+	return Operation{Command: "ZREM", Arguments: []interface{}{k, v}}
+}
@@ -0,0 +1,25 @@
+package unsubscribe
+
+import (
+	"errors"
+
+	"github.com/xh3b4sd/tracer"
+)
+
+var invalidConfigError = &tracer.Error{
+	Kind: "invalidConfigError",
+}
+
+func IsInvalidConfig(err error) bool {
+	return errors.Is(err, invalidConfigError)
+}
+
+var invalidTokenError = &tracer.Error{
+	Kind: "invalidTokenError",
+}
+
+// IsInvalidToken returns whether the given error is caused by a token that
+// is malformed, carries an invalid signature or expired.
+func IsInvalidToken(err error) bool {
+	return errors.Is(err, invalidTokenError)
+}
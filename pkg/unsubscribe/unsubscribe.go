@@ -0,0 +1,199 @@
+package unsubscribe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xh3b4sd/tracer"
+
+	"github.com/venturemark/apiworker/pkg/preference"
+)
+
+const (
+	// KindReminder is the notification type of reminder emails.
+	KindReminder = "reminder"
+)
+
+type Config struct {
+	Preference *preference.Store
+
+	// Secret is the key tokens are signed with. Unsubscribing is disabled
+	// when Secret is empty.
+	Secret string
+	// TTL is the period of time after which tokens expire.
+	TTL time.Duration
+	// URL is the public URL of the unsubscribe endpoint, e.g.
+	// https://example.com/unsubscribe.
+	URL string
+}
+
+// Unsubscribe issues and verifies signed tokens allowing users to opt out of
+// notifications with a single click, without having to log in. Tokens are of
+// the form <payload>.<signature>, where the payload encodes the user ID, the
+// notification type and the expiry of the token.
+type Unsubscribe struct {
+	preference *preference.Store
+
+	secret []byte
+	ttl    time.Duration
+	url    string
+}
+
+func New(config Config) (*Unsubscribe, error) {
+	if config.Preference == nil {
+		return nil, tracer.Maskf(invalidConfigError, "%T.Preference must not be empty", config)
+	}
+
+	if config.Secret != "" {
+		if config.TTL == 0 {
+			return nil, tracer.Maskf(invalidConfigError, "%T.TTL must not be empty", config)
+		}
+		if config.URL == "" {
+			return nil, tracer.Maskf(invalidConfigError, "%T.URL must not be empty", config)
+		}
+	}
+
+	u := &Unsubscribe{
+		preference: config.Preference,
+
+		secret: []byte(config.Secret),
+		ttl:    config.TTL,
+		url:    config.URL,
+	}
+
+	return u, nil
+}
+
+func (u *Unsubscribe) Enabled() bool {
+	return len(u.secret) != 0
+}
+
+// Execute verifies the given token and opts the user out of the notification
+// type the token got issued for.
+func (u *Unsubscribe) Execute(tok string) error {
+	uid, kin, err := u.verify(tok)
+	if err != nil {
+		return tracer.Mask(err)
+	}
+
+	var pre *preference.Preference
+	{
+		pre, err = u.preference.Search(uid)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	switch kin {
+	case KindReminder:
+		pre.Frequency = preference.FrequencyOff
+	default:
+		return tracer.Maskf(invalidTokenError, "unknown notification type %q", kin)
+	}
+
+	{
+		err = u.preference.Update(uid, pre)
+		if err != nil {
+			return tracer.Mask(err)
+		}
+	}
+
+	return nil
+}
+
+// Locale returns the locale of the user the given token got issued for, so
+// that the pages served for the token are rendered in the user's language. The
+// locale is empty for invalid tokens.
+func (u *Unsubscribe) Locale(tok string) (string, error) {
+	uid, _, err := u.verify(tok)
+	if IsInvalidToken(err) {
+		return "", nil
+	} else if err != nil {
+		return "", tracer.Mask(err)
+	}
+
+	pre, err := u.preference.Search(uid)
+	if err != nil {
+		return "", tracer.Mask(err)
+	}
+
+	return pre.Locale, nil
+}
+
+// Header returns the email headers allowing mail clients to offer one-click
+// unsubscribing as defined by RFC 8058.
+func (u *Unsubscribe) Header(uid string, kin string) map[string]string {
+	return map[string]string{
+		"List-Unsubscribe":      fmt.Sprintf("<%s>", u.Link(uid, kin)),
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
+
+// Link returns the URL of the unsubscribe endpoint including a fresh token
+// for the given user and notification type.
+func (u *Unsubscribe) Link(uid string, kin string) string {
+	return fmt.Sprintf("%s?token=%s", u.url, url.QueryEscape(u.token(uid, kin, time.Now().Add(u.ttl))))
+}
+
+func (u *Unsubscribe) sign(pay string) string {
+	m := hmac.New(sha256.New, u.secret)
+	m.Write([]byte(pay))
+
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+func (u *Unsubscribe) token(uid string, kin string, exp time.Time) string {
+	pay := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s:%d", uid, kin, exp.Unix())))
+
+	return fmt.Sprintf("%s.%s", pay, u.sign(pay))
+}
+
+func (u *Unsubscribe) verify(tok string) (string, string, error) {
+	var pay string
+	{
+		l := strings.Split(tok, ".")
+		if len(l) != 2 {
+			return "", "", tracer.Maskf(invalidTokenError, "token must consist of payload and signature")
+		}
+
+		if !hmac.Equal([]byte(l[1]), []byte(u.sign(l[0]))) {
+			return "", "", tracer.Maskf(invalidTokenError, "signature must be valid")
+		}
+
+		pay = l[0]
+	}
+
+	var uid string
+	var kin string
+	{
+		byt, err := base64.RawURLEncoding.DecodeString(pay)
+		if err != nil {
+			return "", "", tracer.Maskf(invalidTokenError, "payload must be base64 encoded")
+		}
+
+		l := strings.Split(string(byt), ":")
+		if len(l) != 3 {
+			return "", "", tracer.Maskf(invalidTokenError, "payload must consist of user, type and expiry")
+		}
+
+		exp, err := strconv.ParseInt(l[2], 10, 64)
+		if err != nil {
+			return "", "", tracer.Maskf(invalidTokenError, "expiry must be a unix timestamp")
+		}
+
+		if time.Now().After(time.Unix(exp, 0)) {
+			return "", "", tracer.Maskf(invalidTokenError, "token must not be expired")
+		}
+
+		uid = l[0]
+		kin = l[1]
+	}
+
+	return uid, kin, nil
+}
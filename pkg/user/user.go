@@ -0,0 +1,55 @@
+package user
+
+import (
+	"encoding/json"
+	"regexp"
+
+	"github.com/venturemark/apicommon/pkg/key"
+	"github.com/venturemark/apicommon/pkg/metadata"
+	"github.com/venturemark/apicommon/pkg/schema"
+	"github.com/xh3b4sd/redigo"
+	"github.com/xh3b4sd/redigo/pkg/simple"
+	"github.com/xh3b4sd/tracer"
+)
+
+var (
+	// idExpression matches user IDs as created by apiserver, which are
+	// numeric.
+	idExpression = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// IsValidID returns whether the given user ID has the format of IDs created by
+// apiserver. User IDs taken from task metadata must be checked before they end
+// up in keys or paths.
+func IsValidID(uid string) bool {
+	return idExpression.MatchString(uid)
+}
+
+// Key returns the simple key under which apiserver persists the user with the
+// given ID.
+func Key(uid string) string {
+	met := map[string]string{
+		metadata.UserID: uid,
+	}
+
+	return key.User(met).Elem()
+}
+
+// Search returns the user with the given ID. Nil is returned without error if
+// the user does not exist, e.g. because it got deleted in the meantime.
+func Search(red redigo.Interface, uid string) (*schema.User, error) {
+	val, err := red.Simple().Search().Value(Key(uid))
+	if simple.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	u := &schema.User{}
+	err = json.Unmarshal([]byte(val), u)
+	if err != nil {
+		return nil, tracer.Mask(err)
+	}
+
+	return u, nil
+}